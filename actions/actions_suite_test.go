@@ -1,8 +1,11 @@
 package actions_test
 
 import (
+	"encoding/json"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"k8s.io/client-go/pkg/runtime"
 
 	"testing"
 )
@@ -11,3 +14,26 @@ func TestActions(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Actions Suite")
 }
+
+// newStatefulSet returns a StatefulSet for the Sets of a fake client.
+func newStatefulSet(name string, labels map[string]string) *runtime.Unstructured {
+	statefulSet := &runtime.Unstructured{Object: map[string]interface{}{}}
+	statefulSet.SetAPIVersion("apps/v1")
+	statefulSet.SetKind("StatefulSet")
+	statefulSet.SetName(name)
+	statefulSet.SetNamespace("bosh-namespace")
+	statefulSet.SetLabels(labels)
+	return statefulSet
+}
+
+// fieldJSON returns the JSON of the field at path of an unstructured object.
+func fieldJSON(obj *runtime.Unstructured, path ...string) string {
+	var value interface{} = obj.Object
+	for _, field := range path {
+		value = value.(map[string]interface{})[field]
+	}
+
+	data, err := json.Marshal(value)
+	Expect(err).NotTo(HaveOccurred())
+	return string(data)
+}
@@ -1,8 +1,9 @@
 package actions
 
 import (
+	"encoding/json"
 	"fmt"
-	"reflect"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/clock"
@@ -10,18 +11,79 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/resource"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/labels"
-	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/pkg/runtime"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
+const (
+	// RequestedSizeAnnotation records the size requested by the director
+	// when the rounding rules changed the storage request of the PVC.
+	RequestedSizeAnnotation = "bosh.cloudfoundry.org/requested-size"
+
+	// ProvisionedSizeAnnotation records the capacity of the bound volume
+	// when it is larger than the storage request of the PVC.
+	ProvisionedSizeAnnotation = "bosh.cloudfoundry.org/provisioned-size"
+)
+
+// The volume modes of disks. Block disks are attached as raw devices.
+const (
+	FilesystemVolumeMode = "Filesystem"
+	BlockVolumeMode      = "Block"
+)
+
 type CreateDiskCloudProperties struct {
 	Context            string `json:"context"`
 	StorageClass       string `json:"storage_class"`
 	StorageProvisioner string `json:"storage_provisioner"`
+
+	// AccessModes are the access modes of the claim. They default to
+	// ReadWriteOnce, which every storage class supports.
+	AccessModes []v1.PersistentVolumeAccessMode `json:"access_modes,omitempty"`
+
+	// VolumeMode is Filesystem, the default, or Block.
+	VolumeMode string `json:"volume_mode,omitempty"`
+
+	// Labels and Annotations are added to the claim. They cannot replace
+	// the labels and annotations set by the CPI.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Zone is the zone of the volume. It defaults to the zone of the VM the
+	// disk is created for. It is advisory: the claim records it, but only
+	// the selected node or the allowedTopologies of the storage class
+	// confine the volume to a zone.
+	Zone string `json:"zone,omitempty"`
+
+	// MinimumSize and SizeIncrement are the rounding rules of the storage
+	// provider in MiB. The storage request is at least MinimumSize and a
+	// multiple of SizeIncrement.
+	MinimumSize   uint `json:"minimum_size,omitempty"`
+	SizeIncrement uint `json:"size_increment,omitempty"`
+
+	// SnapshotClass is the VolumeSnapshotClass used by snapshot_disk.
+	SnapshotClass string `json:"snapshot_class,omitempty"`
+
+	// Source is the data the disk is created with. The disk is empty when
+	// it is not set.
+	Source *DiskSource `json:"source,omitempty"`
+}
+
+// DiskSource names exactly one snapshot, disk or claim in the context of
+// the disk. It becomes the dataSource of the claim of the disk.
+type DiskSource struct {
+	// Snapshot is the CID of a snapshot taken by snapshot_disk.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Disk is the CID of a disk to clone.
+	Disk string `json:"disk,omitempty"`
+
+	// PVC is the name of a claim to clone.
+	PVC string `json:"pvc,omitempty"`
 }
 
 // DiskCreator simply creates a PersistentVolumeClaim.
@@ -31,6 +93,7 @@ type DiskCreator struct {
 	ClientProvider    kubecluster.ClientProvider
 	Clock             clock.Clock
 	DiskReadyTimeout  time.Duration
+	DiskCloneTimeout  time.Duration
 	GUIDGeneratorFunc func() (string, error)
 }
 
@@ -40,9 +103,21 @@ func (d *DiskCreator) CreateDisk(size uint, cloudProps CreateDiskCloudProperties
 		return "", bosherr.WrapError(err, "Creating disk")
 	}
 
-	volumeSize, err := resource.ParseQuantity(fmt.Sprintf("%dGi", size))
+	switch cloudProps.VolumeMode {
+	case "", FilesystemVolumeMode, BlockVolumeMode:
+	default:
+		return "", bosherr.Errorf("Invalid volume_mode %q: expected %s or %s", cloudProps.VolumeMode, FilesystemVolumeMode, BlockVolumeMode)
+	}
+
+	accessModes := cloudProps.AccessModes
+	if len(accessModes) == 0 {
+		accessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}
+	}
+
+	roundedSize := roundDiskSize(size, cloudProps.MinimumSize, cloudProps.SizeIncrement)
+	volumeSize, err := diskSize(roundedSize)
 	if err != nil {
-		return "", bosherr.WrapError(err, "Parsing quantity")
+		return "", err
 	}
 
 	client, err := d.ClientProvider.New(cloudProps.Context)
@@ -50,6 +125,72 @@ func (d *DiskCreator) CreateDisk(size uint, cloudProps CreateDiskCloudProperties
 		return "", bosherr.WrapError(err, "Creating client")
 	}
 
+	placement, err := vmDiskPlacement(client, vmcid)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Getting placement of VM")
+	}
+
+	if cloudProps.StorageClass == "" {
+		cloudProps.StorageClass = placement.StorageClass
+	}
+
+	if cloudProps.Zone != "" && placement.Zone != "" && cloudProps.Zone != placement.Zone {
+		return "", bosherr.Errorf("Disk zone %q differs from zone %q of VM %s", cloudProps.Zone, placement.Zone, vmcid)
+	}
+	if cloudProps.Zone == "" {
+		cloudProps.Zone = placement.Zone
+	}
+
+	labels := map[string]string{}
+	for k, v := range cloudProps.Labels {
+		labels[k] = v
+	}
+	labels["bosh.cloudfoundry.org/disk-id"] = diskID
+
+	annotations := map[string]string{}
+	for k, v := range cloudProps.Annotations {
+		annotations[k] = v
+	}
+	// An empty storage class annotation would turn off the default class.
+	if cloudProps.StorageClass != "" {
+		annotations["volume.beta.kubernetes.io/storage-class"] = cloudProps.StorageClass
+	}
+	if cloudProps.StorageProvisioner != "" {
+		annotations["volume.beta.kubernetes.io/storage-provisioner"] = cloudProps.StorageProvisioner
+	}
+	if cloudProps.Zone != "" {
+		annotations[ZoneAnnotation] = cloudProps.Zone
+	}
+	if placement.NodeName != "" {
+		annotations[SelectedNodeAnnotation] = placement.NodeName
+	}
+	if cloudProps.SnapshotClass != "" {
+		annotations[SnapshotClassAnnotation] = cloudProps.SnapshotClass
+	}
+	if roundedSize != size {
+		annotations[RequestedSizeAnnotation] = fmt.Sprintf("%dMi", size)
+	}
+
+	spec := map[string]interface{}{}
+	if cloudProps.StorageClass != "" {
+		spec["storageClassName"] = cloudProps.StorageClass
+	}
+	if cloudProps.VolumeMode != "" {
+		spec["volumeMode"] = cloudProps.VolumeMode
+	}
+
+	// Provisioners copy the data of the source before the claim is bound,
+	// which takes longer than provisioning an empty volume.
+	timeout := d.DiskReadyTimeout
+	if cloudProps.Source != nil {
+		dataSource, err := diskDataSource(client, *cloudProps.Source, volumeSize)
+		if err != nil {
+			return "", bosherr.WrapError(err, "Getting disk source")
+		}
+		spec["dataSource"] = dataSource
+		timeout = d.DiskCloneTimeout
+	}
+
 	// volumeName := "volume-" + diskID
 
 	// _, err = client.PersistentVolumes().Create(&v1.PersistentVolume{
@@ -72,83 +213,245 @@ func (d *DiskCreator) CreateDisk(size uint, cloudProps CreateDiskCloudProperties
 	// 	return "", err
 	// }
 
-	pvc, err := client.PersistentVolumeClaims().Create(&v1.PersistentVolumeClaim{
+	pvc, err := createClaim(client, &v1.PersistentVolumeClaim{
 		ObjectMeta: v1.ObjectMeta{
-			Name:      "disk-" + diskID,
-			Namespace: client.Namespace(),
-			Annotations: map[string]string{
-				"volume.beta.kubernetes.io/storage-class":       cloudProps.StorageClass,
-				"volume.beta.kubernetes.io/storage-provisioner": cloudProps.StorageProvisioner,
-			},
-			Labels: map[string]string{
-				"bosh.cloudfoundry.org/disk-id": diskID,
-			},
+			Name:        "disk-" + diskID,
+			Namespace:   client.Namespace(),
+			Annotations: annotations,
+			Labels:      labels,
 		},
 		Spec: v1.PersistentVolumeClaimSpec{
 			// VolumeName:  volumeName,
-			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			AccessModes: accessModes,
 			Resources: v1.ResourceRequirements{
 				Requests: v1.ResourceList{
 					v1.ResourceStorage: volumeSize,
 				},
 			},
 		},
-	})
+	}, spec)
 
 	if err != nil {
 		return "", bosherr.WrapError(err, "Creating PVC")
 	}
 
-	if err := d.waitForDisk(client.PersistentVolumeClaims(), diskID, pvc.ResourceVersion); err != nil {
-		return "", bosherr.WrapError(err, "Waiting for disk")
+	bound, err := d.waitForDisk(client.PersistentVolumeClaims(), diskID, pvc.ResourceVersion, timeout)
+	if err != nil {
+		return "", bosherr.WrapError(diskDiagnostics(client, diskID, err), "Waiting for disk")
+	}
+
+	if err := checkDiskCapacity(client, bound, volumeSize); err != nil {
+		// The director never learns the CID of a disk that is too small, so
+		// the claim is removed on a best effort basis.
+		client.PersistentVolumeClaims().Delete(pvc.Name, &v1.DeleteOptions{})
+
+		return "", err
 	}
 
 	return NewDiskCID(client.Context(), diskID), nil
 }
 
-func (d *DiskCreator) waitForDisk(pvcService core.PersistentVolumeClaimInterface, diskID string, resourceVersion string) error {
-	diskSelector, err := labels.Parse("bosh.cloudfoundry.org/disk-id=" + diskID)
+// diskSize returns the storage request of a disk of the size in MiB
+// requested by the director. The quantity is in its canonical form, so
+// 1024 MiB is 1Gi.
+func diskSize(size uint) (resource.Quantity, error) {
+	mebibytes := resource.NewQuantity(int64(size)*1024*1024, resource.BinarySI)
+	quantity, err := resource.ParseQuantity(mebibytes.String())
 	if err != nil {
-		return bosherr.WrapError(err, "Parsing disk selector")
+		return resource.Quantity{}, bosherr.WrapError(err, "Parsing quantity")
 	}
+	return quantity, nil
+}
 
-	listOptions := v1.ListOptions{
-		LabelSelector:   diskSelector.String(),
-		ResourceVersion: resourceVersion,
-		Watch:           true,
+// roundDiskSize rounds the size in MiB up to the minimum size and to a
+// multiple of the increment. Zero disables either rule.
+func roundDiskSize(size, minimum, increment uint) uint {
+	if size < minimum {
+		size = minimum
+	}
+	if increment > 0 && size%increment != 0 {
+		size += increment - size%increment
 	}
+	return size
+}
 
-	timer := d.Clock.NewTimer(d.DiskReadyTimeout)
-	defer timer.Stop()
+// checkDiskCapacity compares the capacity of the bound claim with its
+// storage request. A smaller volume is an error; a larger one is recorded
+// on the claim so the deviation shows up next to the bill.
+func checkDiskCapacity(client kubecluster.Client, pvc *v1.PersistentVolumeClaim, size resource.Quantity) error {
+	capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]
+	if !ok {
+		return nil
+	}
 
-	pvcWatch, err := pvcService.Watch(listOptions)
+	switch cmp := capacity.Cmp(size); {
+	case cmp < 0:
+		return bosherr.Errorf("Disk capacity %s is smaller than the requested size %s", capacity.String(), size.String())
+	case cmp == 0:
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{ProvisionedSizeAnnotation: capacity.String()},
+		},
+	})
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling patch")
+	}
+
+	_, err = client.UnstructuredPersistentVolumeClaims().Patch(pvc.Name, api.MergePatchType, patch)
 	if err != nil {
-		return bosherr.WrapError(err, "Watching PVC")
-	}
-	defer pvcWatch.Stop()
-
-	for {
-		select {
-		case event := <-pvcWatch.ResultChan():
-			switch event.Type {
-			case watch.Modified:
-				pvc, ok := event.Object.(*v1.PersistentVolumeClaim)
-				if !ok {
-					return bosherr.Errorf("Unexpected object type: %v", reflect.TypeOf(event.Object))
-				}
-
-				if isDiskReady(pvc) {
-					return nil
-				}
-
-			default:
-				return bosherr.Errorf("Unexpected pvc watch event: %s", event.Type)
+		return bosherr.WrapError(err, "Annotating PVC")
+	}
+
+	return nil
+}
+
+// createClaim creates the claim with the additional fields of its spec.
+// The vendored PersistentVolumeClaim type has no storageClassName,
+// volumeMode or dataSource, so claims are created as unstructured objects.
+func createClaim(client kubecluster.Client, claim *v1.PersistentVolumeClaim, spec map[string]interface{}) (*v1.PersistentVolumeClaim, error) {
+	obj, err := toUnstructured(claim, v1.SchemeGroupVersion.String(), "PersistentVolumeClaim")
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range spec {
+		obj.Object["spec"].(map[string]interface{})[k] = v
+	}
+
+	created, err := client.UnstructuredPersistentVolumeClaims().Create(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	pvc := &v1.PersistentVolumeClaim{}
+	if err := fromUnstructured(created, pvc); err != nil {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+// diskDataSource returns the dataSource of a claim for the source. The
+// source has to exist and must not be larger than the disk.
+func diskDataSource(client kubecluster.Client, source DiskSource, size resource.Quantity) (map[string]interface{}, error) {
+	count := 0
+	for _, value := range []string{source.Snapshot, source.Disk, source.PVC} {
+		if value != "" {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, bosherr.Error("Disk source must name exactly one of snapshot, disk or pvc")
+	}
+
+	if source.Snapshot != "" {
+		snapshotID, err := sourceID(client, source.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot, err := client.VolumeSnapshots().Get("snapshot-" + snapshotID)
+		if isNotFoundStatusError(err) {
+			return nil, bosherr.Errorf("Snapshot %s not found", source.Snapshot)
+		}
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Getting VolumeSnapshot")
+		}
+
+		if restoreSize, ok := unstructuredField(snapshot, "status", "restoreSize").(string); ok {
+			if err := checkSourceSize(size, restoreSize, "snapshot "+source.Snapshot); err != nil {
+				return nil, err
 			}
+		}
+
+		return map[string]interface{}{
+			"apiGroup": kubecluster.SnapshotGroupVersion.Group,
+			"kind":     "VolumeSnapshot",
+			"name":     snapshot.GetName(),
+		}, nil
+	}
 
-		case <-timer.C():
-			return bosherr.Error("Pod recreate failed with a timeout")
+	name, description := source.PVC, "claim "+source.PVC
+	if source.Disk != "" {
+		diskID, err := sourceID(client, source.Disk)
+		if err != nil {
+			return nil, err
 		}
+		name, description = "disk-"+diskID, "disk "+source.Disk
 	}
+
+	pvc, err := client.PersistentVolumeClaims().Get(name)
+	if isNotFoundStatusError(err) {
+		return nil, bosherr.Errorf("Source %s not found", description)
+	}
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting PVC")
+	}
+
+	capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]
+	if !ok {
+		capacity = pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	}
+	if err := checkSourceSize(size, capacity.String(), description); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"kind": "PersistentVolumeClaim",
+		"name": pvc.Name,
+	}, nil
+}
+
+// sourceID returns the ID of a disk or snapshot CID. Data sources have to
+// be in the namespace of the claim, so the CID must be in the context of
+// the client.
+func sourceID(client kubecluster.Client, cid string) (string, error) {
+	parts := strings.SplitN(cid, ":", 2)
+	if len(parts) != 2 {
+		return "", bosherr.Errorf("Invalid source CID %q", cid)
+	}
+	if parts[0] != client.Context() {
+		return "", bosherr.Errorf("Source %s is not in context %q", cid, client.Context())
+	}
+	return parts[1], nil
+}
+
+func checkSourceSize(size resource.Quantity, sourceSize, description string) error {
+	quantity, err := resource.ParseQuantity(sourceSize)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Parsing size of %s", description)
+	}
+	if size.Cmp(quantity) < 0 {
+		return bosherr.Errorf("Disk size %s is smaller than the size %s of %s", size.String(), quantity.String(), description)
+	}
+	return nil
+}
+
+// waitForDisk waits until the claim of the disk is bound and returns it.
+func (d *DiskCreator) waitForDisk(pvcService core.PersistentVolumeClaimInterface, diskID string, resourceVersion string, timeout time.Duration) (*v1.PersistentVolumeClaim, error) {
+	diskSelector, err := labels.Parse("bosh.cloudfoundry.org/disk-id=" + diskID)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Parsing disk selector")
+	}
+
+	w := newPVCWaiter(d.Clock, timeout, pvcService, v1.ListOptions{
+		LabelSelector:   diskSelector.String(),
+		ResourceVersion: resourceVersion,
+	})
+	w.TimeoutMessage = "Disk binding failed with a timeout"
+
+	var bound *v1.PersistentVolumeClaim
+	err = w.Wait(func(objects []runtime.Object) (bool, error) {
+		for _, obj := range objects {
+			if pvc := obj.(*v1.PersistentVolumeClaim); isDiskReady(pvc) {
+				bound = pvc
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	return bound, err
 }
 
 func isDiskReady(pvc *v1.PersistentVolumeClaim) bool {
@@ -1,12 +1,16 @@
 package actions_test
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
 	"time"
 
 	"code.cloudfoundry.org/clock/fakeclock"
 
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
 	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/pkg/watch"
@@ -37,6 +41,17 @@ var _ = Describe("CreateDisk", func() {
 		vmcid cpi.VMCID
 	)
 
+	// typedClaim converts a claim created as an unstructured object. The
+	// fields v1.PersistentVolumeClaim lacks are dropped.
+	typedClaim := func(obj runtime.Object) *v1.PersistentVolumeClaim {
+		data, err := json.Marshal(obj.(*runtime.Unstructured).Object)
+		Expect(err).NotTo(HaveOccurred())
+
+		pvc := &v1.PersistentVolumeClaim{}
+		Expect(json.Unmarshal(data, pvc)).To(Succeed())
+		return pvc
+	}
+
 	BeforeEach(func() {
 		pvcMeta = v1.ObjectMeta{
 			Name:      "disk-disk-guid",
@@ -92,6 +107,7 @@ var _ = Describe("CreateDisk", func() {
 			ClientProvider:    fakeProvider,
 			Clock:             fakeclock.NewFakeClock(time.Now()),
 			DiskReadyTimeout:  5 * time.Second,
+			DiskCloneTimeout:  20 * time.Second,
 			GUIDGeneratorFunc: func() (string, error) { return "disk-guid", nil },
 		}
 
@@ -99,7 +115,7 @@ var _ = Describe("CreateDisk", func() {
 	})
 
 	It("gets a client for the appropriate context", func() {
-		_, err := diskCreator.CreateDisk(1, cloudProps, vmcid)
+		_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
@@ -108,7 +124,7 @@ var _ = Describe("CreateDisk", func() {
 
 	// Skip for now until we decide where to go with volumes
 	XIt("creates a persistent volume", func() {
-		diskCID, err := diskCreator.CreateDisk(1, cloudProps, vmcid)
+		diskCID, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(diskCID).To(Equal(cpi.DiskCID("bosh:disk-guid")))
 
@@ -136,7 +152,7 @@ var _ = Describe("CreateDisk", func() {
 	})
 
 	It("creates a persistent volume claim", func() {
-		diskCID, err := diskCreator.CreateDisk(1, cloudProps, vmcid)
+		diskCID, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(diskCID).To(Equal(cpi.DiskCID("bosh:disk-guid")))
 
@@ -146,8 +162,9 @@ var _ = Describe("CreateDisk", func() {
 		createAction := matches[0].(testing.CreateAction)
 		Expect(createAction.GetNamespace()).To(Equal("bosh-namespace"))
 
-		pvc := createAction.GetObject().(*v1.PersistentVolumeClaim)
+		pvc := typedClaim(createAction.GetObject())
 		Expect(pvc).To(Equal(&v1.PersistentVolumeClaim{
+			TypeMeta: unversioned.TypeMeta{Kind: "PersistentVolumeClaim", APIVersion: "v1"},
 			ObjectMeta: v1.ObjectMeta{
 				Name:      "disk-disk-guid",
 				Namespace: "bosh-namespace",
@@ -161,7 +178,7 @@ var _ = Describe("CreateDisk", func() {
 			},
 			Spec: v1.PersistentVolumeClaimSpec{
 				// VolumeName:  "volume-disk-guid",
-				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
 				Resources: v1.ResourceRequirements{
 					Requests: v1.ResourceList{
 						v1.ResourceStorage: resource.MustParse("1Gi"),
@@ -169,6 +186,270 @@ var _ = Describe("CreateDisk", func() {
 				},
 			},
 		}))
+
+		claim := createAction.GetObject().(*runtime.Unstructured)
+		Expect(claim.Object["spec"]).To(HaveKeyWithValue("storageClassName", "fake-class"))
+		Expect(claim.Object["spec"]).NotTo(HaveKey("volumeMode"))
+	})
+
+	Context("when the cloud properties set the claim options", func() {
+		BeforeEach(func() {
+			cloudProps.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany, v1.ReadWriteMany}
+			cloudProps.VolumeMode = "Block"
+			cloudProps.Labels = map[string]string{
+				"team":                          "storage",
+				"bosh.cloudfoundry.org/disk-id": "ignored",
+			}
+			cloudProps.Annotations = map[string]string{
+				"backup.example.com/schedule":             "daily",
+				"volume.beta.kubernetes.io/storage-class": "ignored",
+			}
+		})
+
+		It("creates the claim with them", func() {
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			claim := matches[0].(testing.CreateAction).GetObject().(*runtime.Unstructured)
+			Expect(claim.Object["spec"]).To(HaveKeyWithValue("volumeMode", "Block"))
+
+			pvc := typedClaim(claim)
+			Expect(pvc.Spec.AccessModes).To(Equal([]v1.PersistentVolumeAccessMode{v1.ReadOnlyMany, v1.ReadWriteMany}))
+			Expect(pvc.Labels).To(Equal(map[string]string{
+				"team":                          "storage",
+				"bosh.cloudfoundry.org/disk-id": "disk-guid",
+			}))
+			Expect(pvc.Annotations).To(HaveKeyWithValue("backup.example.com/schedule", "daily"))
+			Expect(pvc.Annotations).To(HaveKeyWithValue("volume.beta.kubernetes.io/storage-class", "fake-class"))
+		})
+
+		It("rejects an invalid volume mode", func() {
+			cloudProps.VolumeMode = "Raw"
+
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).To(MatchError(`Invalid volume_mode "Raw": expected Filesystem or Block`))
+			Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(BeEmpty())
+		})
+	})
+
+	Context("when the cloud properties do not name a storage class", func() {
+		BeforeEach(func() {
+			cloudProps.StorageClass = ""
+			vmcid = "bosh:agent-guid"
+
+			_, err := fakeClient.Pods().Create(&v1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:        "agent-agent-guid",
+					Namespace:   "bosh-namespace",
+					Labels:      map[string]string{"bosh.cloudfoundry.org/agent-id": "agent-guid"},
+					Annotations: map[string]string{actions.StorageClassAnnotation: "vm-class"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("uses the storage class of the VM", func() {
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			pvc := typedClaim(matches[0].(testing.CreateAction).GetObject())
+			Expect(pvc.Annotations).To(HaveKeyWithValue("volume.beta.kubernetes.io/storage-class", "vm-class"))
+		})
+	})
+
+	Context("when neither the cloud properties nor the VM name a storage class", func() {
+		BeforeEach(func() {
+			cloudProps.StorageClass = ""
+			cloudProps.StorageProvisioner = ""
+		})
+
+		It("leaves the claim to the default storage class", func() {
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			claim := matches[0].(testing.CreateAction).GetObject().(*runtime.Unstructured)
+			Expect(claim.GetAnnotations()).NotTo(HaveKey("volume.beta.kubernetes.io/storage-class"))
+			Expect(claim.GetAnnotations()).NotTo(HaveKey("volume.beta.kubernetes.io/storage-provisioner"))
+			Expect(claim.Object["spec"]).NotTo(HaveKey("storageClassName"))
+		})
+	})
+
+	Context("when the cloud properties name a snapshot class", func() {
+		BeforeEach(func() {
+			cloudProps.SnapshotClass = "csi-snapclass"
+		})
+
+		It("records it on the claim", func() {
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			pvc := typedClaim(matches[0].(testing.CreateAction).GetObject())
+			Expect(pvc.Annotations).To(HaveKeyWithValue(actions.SnapshotClassAnnotation, "csi-snapclass"))
+		})
+	})
+
+	Describe("sizing the disk", func() {
+		bindWithCapacity := func(capacity string) {
+			boundWatch := watch.NewFakeWithChanSize(1, false)
+			boundWatch.Modify(&v1.PersistentVolumeClaim{
+				ObjectMeta: pvcMeta,
+				Spec:       initialPvcSpec,
+				Status: v1.PersistentVolumeClaimStatus{
+					Phase:    v1.ClaimBound,
+					Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse(capacity)},
+				},
+			})
+			fakeClient.PrependWatchReactor("*", testing.DefaultWatchReactor(boundWatch, nil))
+		}
+
+		createdClaim := func() *v1.PersistentVolumeClaim {
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+			return typedClaim(matches[0].(testing.CreateAction).GetObject())
+		}
+
+		It("requests the size in MiB", func() {
+			bindWithCapacity("10Gi")
+
+			_, err := diskCreator.CreateDisk(10240, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			pvc := createdClaim()
+			Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(v1.ResourceStorage, resource.MustParse("10Gi")))
+			Expect(pvc.Annotations).NotTo(HaveKey(actions.RequestedSizeAnnotation))
+			Expect(fakeClient.MatchingActions("patch", "persistentvolumeclaims")).To(BeEmpty())
+		})
+
+		It("rounds the size up with the rules of the cloud properties", func() {
+			cloudProps.MinimumSize = 1024
+			cloudProps.SizeIncrement = 1024
+
+			for _, c := range []struct {
+				size      uint
+				request   string
+				requested string
+			}{
+				{size: 100, request: "1Gi", requested: "100Mi"},
+				{size: 1500, request: "2Gi", requested: "1500Mi"},
+				{size: 2048, request: "2Gi"},
+			} {
+				fakeClient.ClearActions()
+				bindWithCapacity(c.request)
+
+				_, err := diskCreator.CreateDisk(c.size, cloudProps, vmcid)
+				Expect(err).NotTo(HaveOccurred())
+
+				pvc := createdClaim()
+				Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(v1.ResourceStorage, resource.MustParse(c.request)))
+				if c.requested == "" {
+					Expect(pvc.Annotations).NotTo(HaveKey(actions.RequestedSizeAnnotation))
+				} else {
+					Expect(pvc.Annotations).To(HaveKeyWithValue(actions.RequestedSizeAnnotation, c.requested))
+				}
+
+				Expect(fakeClient.PersistentVolumeClaims().Delete("disk-disk-guid", &v1.DeleteOptions{})).To(Succeed())
+			}
+		})
+
+		It("records a larger capacity of the volume on the claim", func() {
+			_, err := diskCreator.CreateDisk(1000, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("patch", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].(testing.PatchActionImpl).GetName()).To(Equal("disk-disk-guid"))
+			Expect(matches[0].(testing.PatchActionImpl).GetPatch()).To(MatchJSON(`{"metadata": {"annotations": {"bosh.cloudfoundry.org/provisioned-size": "1Gi"}}}`))
+
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvc.Annotations).To(HaveKeyWithValue(actions.ProvisionedSizeAnnotation, "1Gi"))
+		})
+
+		Context("when the capacity of the volume is smaller than the request", func() {
+			It("deletes the claim and returns an error", func() {
+				_, err := diskCreator.CreateDisk(2048, cloudProps, vmcid)
+				Expect(err).To(MatchError("Disk capacity 1Gi is smaller than the requested size 2Gi"))
+
+				matches := fakeClient.MatchingActions("delete", "persistentvolumeclaims")
+				Expect(matches).To(HaveLen(1))
+				Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("disk-disk-guid"))
+			})
+		})
+	})
+
+	Context("when the VM is scheduled on a node", func() {
+		BeforeEach(func() {
+			vmcid = "bosh:agent-guid"
+
+			_, err := fakeClient.Core().Nodes().Create(&v1.Node{
+				ObjectMeta: v1.ObjectMeta{
+					Name:   "node-1",
+					Labels: map[string]string{actions.ZoneLabel: "zone-a"},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = fakeClient.Pods().Create(&v1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "agent-agent-guid",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "agent-guid"},
+				},
+				Spec: v1.PodSpec{NodeName: "node-1"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("provisions the volume in the zone of the node", func() {
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			pvc := typedClaim(matches[0].(testing.CreateAction).GetObject())
+			Expect(pvc.Annotations).To(HaveKeyWithValue(actions.ZoneAnnotation, "zone-a"))
+			Expect(pvc.Annotations).To(HaveKeyWithValue(actions.SelectedNodeAnnotation, "node-1"))
+		})
+
+		Context("when the cloud properties name another zone", func() {
+			BeforeEach(func() {
+				cloudProps.Zone = "zone-b"
+			})
+
+			It("returns an error", func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).To(MatchError(`Disk zone "zone-b" differs from zone "zone-a" of VM bosh:agent-guid`))
+				Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(BeEmpty())
+			})
+		})
+
+		Context("when the VM is in another context", func() {
+			BeforeEach(func() {
+				vmcid = "other:agent-guid"
+			})
+
+			It("does not use the placement of the VM", func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).NotTo(HaveOccurred())
+
+				pvc := typedClaim(fakeClient.MatchingActions("create", "persistentvolumeclaims")[0].(testing.CreateAction).GetObject())
+				Expect(pvc.Annotations).NotTo(HaveKey(actions.ZoneAnnotation))
+				Expect(pvc.Annotations).NotTo(HaveKey(actions.SelectedNodeAnnotation))
+			})
+		})
 	})
 
 	Context("when getting the client fails", func() {
@@ -177,7 +458,7 @@ var _ = Describe("CreateDisk", func() {
 		})
 
 		It("gets a client for the appropriate context", func() {
-			_, err := diskCreator.CreateDisk(1, cloudProps, vmcid)
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
 			Expect(err).To(MatchError(bosherr.WrapError(errors.New("boom"), "Creating client")))
 		})
 	})
@@ -190,9 +471,335 @@ var _ = Describe("CreateDisk", func() {
 		})
 
 		It("returns an error", func() {
-			_, err := diskCreator.CreateDisk(1, cloudProps, vmcid)
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
 			Expect(err).To(MatchError(bosherr.WrapError(errors.New("create-pvc-welp"), "Creating PVC")))
 			Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(HaveLen(1))
 		})
 	})
+
+	Describe("creating the disk from a source", func() {
+		createdClaim := func() *runtime.Unstructured {
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+			return matches[0].(testing.CreateAction).GetObject().(*runtime.Unstructured)
+		}
+
+		BeforeEach(func() {
+			snapshot := &runtime.Unstructured{Object: map[string]interface{}{
+				"status": map[string]interface{}{"readyToUse": true, "restoreSize": "1Gi"},
+			}}
+			snapshot.SetName("snapshot-snapshot-guid")
+			_, err := fakeClient.VolumeSnapshots().Create(snapshot)
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, name := range []string{"disk-other-guid", "data"} {
+				_, err := fakeClient.PersistentVolumeClaims().Create(&v1.PersistentVolumeClaim{
+					ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "bosh-namespace"},
+					Spec:       initialPvcSpec,
+					Status: v1.PersistentVolumeClaimStatus{
+						Phase:    v1.ClaimBound,
+						Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			fakeClient.ClearActions()
+		})
+
+		It("restores a snapshot", func() {
+			cloudProps.Source = &actions.DiskSource{Snapshot: "bosh:snapshot-guid"}
+
+			diskCID, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diskCID).To(Equal(cpi.DiskCID("bosh:disk-guid")))
+
+			claim := createdClaim()
+			Expect(claim.GetAPIVersion()).To(Equal("v1"))
+			Expect(claim.GetKind()).To(Equal("PersistentVolumeClaim"))
+			Expect(claim.GetName()).To(Equal("disk-disk-guid"))
+			Expect(claim.GetLabels()).To(Equal(map[string]string{"bosh.cloudfoundry.org/disk-id": "disk-guid"}))
+			Expect(claim.Object["spec"]).To(HaveKeyWithValue("dataSource", map[string]interface{}{
+				"apiGroup": "snapshot.storage.k8s.io",
+				"kind":     "VolumeSnapshot",
+				"name":     "snapshot-snapshot-guid",
+			}))
+
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-guid")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvc.Spec.AccessModes).To(Equal([]v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}))
+		})
+
+		It("clones a disk", func() {
+			cloudProps.Source = &actions.DiskSource{Disk: "bosh:other-guid"}
+
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(createdClaim().Object["spec"]).To(HaveKeyWithValue("dataSource", map[string]interface{}{
+				"kind": "PersistentVolumeClaim",
+				"name": "disk-other-guid",
+			}))
+		})
+
+		It("clones a claim", func() {
+			cloudProps.Source = &actions.DiskSource{PVC: "data"}
+
+			_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(createdClaim().Object["spec"]).To(HaveKeyWithValue("dataSource", map[string]interface{}{
+				"kind": "PersistentVolumeClaim",
+				"name": "data",
+			}))
+		})
+
+		It("waits for the claim with the clone timeout", func() {
+			fakeClient.PrependWatchReactor("persistentvolumeclaims", testing.DefaultWatchReactor(watch.NewFake(), nil))
+			cloudProps.Source = &actions.DiskSource{PVC: "data"}
+
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				errCh <- err
+			}()
+
+			fakeClock := diskCreator.Clock.(*fakeclock.FakeClock)
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			fakeClock.Increment(6 * time.Second)
+			Consistently(errCh).ShouldNot(Receive())
+
+			fakeClock.Increment(15 * time.Second)
+			var err error
+			Eventually(errCh).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("Disk binding failed with a timeout")))
+		})
+
+		It("rejects invalid sources", func() {
+			sources := map[actions.DiskSource]string{
+				{}:                                     "Disk source must name exactly one of snapshot, disk or pvc",
+				{Disk: "bosh:other-guid", PVC: "data"}: "Disk source must name exactly one of snapshot, disk or pvc",
+				{Snapshot: "bosh:missing"}:             "Snapshot bosh:missing not found",
+				{Disk: "bosh:missing"}:                 "Source disk bosh:missing not found",
+				{PVC: "missing"}:                       "Source claim missing not found",
+				{Disk: "other:other-guid"}:             `Source other:other-guid is not in context "bosh"`,
+				{Snapshot: "snapshot-guid"}:            `Invalid source CID "snapshot-guid"`,
+			}
+
+			for source, message := range sources {
+				source := source
+				cloudProps.Source = &source
+
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).To(MatchError("Getting disk source: "+message), message)
+			}
+			Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(BeEmpty())
+		})
+
+		Context("when the source is larger than the disk", func() {
+			BeforeEach(func() {
+				snapshot := fakeClient.Snapshots["snapshot-snapshot-guid"]
+				snapshot.Object["status"] = map[string]interface{}{"readyToUse": true, "restoreSize": "2Gi"}
+			})
+
+			It("returns an error", func() {
+				cloudProps.Source = &actions.DiskSource{Snapshot: "bosh:snapshot-guid"}
+
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).To(MatchError("Getting disk source: Disk size 1Gi is smaller than the size 2Gi of snapshot bosh:snapshot-guid"))
+			})
+		})
+	})
+
+	Context("when the claim is not bound before the timeout", func() {
+		BeforeEach(func() {
+			fakeClient.PrependWatchReactor("persistentvolumeclaims", testing.DefaultWatchReactor(watch.NewFake(), nil))
+
+			_, err := fakeClient.Events().Create(&v1.Event{
+				ObjectMeta: v1.ObjectMeta{Name: "disk-disk-guid.1", Namespace: "bosh-namespace"},
+				InvolvedObject: v1.ObjectReference{
+					Kind: "PersistentVolumeClaim",
+					Name: "disk-disk-guid",
+				},
+				Type:    v1.EventTypeWarning,
+				Reason:  "ProvisioningFailed",
+				Message: `storageclass "fake-class" not found`,
+				Count:   3,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the state and the events of the claim", func() {
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				errCh <- err
+			}()
+
+			fakeClock := diskCreator.Clock.(*fakeclock.FakeClock)
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+			fakeClock.Increment(6 * time.Second)
+
+			var err error
+			Eventually(errCh).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("Waiting for disk: Disk binding failed with a timeout")))
+
+			diagnostics := cpi.DiagnosticLog(err)
+			Expect(diagnostics).To(HavePrefix("PersistentVolumeClaim disk-disk-guid:"))
+			Expect(diagnostics).To(ContainSubstring(`Event Warning ProvisioningFailed (x3): storageclass "fake-class" not found`))
+		})
+	})
+
+	Describe("waiting for the claim to be bound", func() {
+		var (
+			boundPVC  *v1.PersistentVolumeClaim
+			fakeClock *fakeclock.FakeClock
+			watchers  []*watch.FakeWatcher
+		)
+
+		BeforeEach(func() {
+			boundPVC = &v1.PersistentVolumeClaim{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "disk-disk-guid",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/disk-id": "disk-guid"},
+				},
+				Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+			}
+			fakeClock = diskCreator.Clock.(*fakeclock.FakeClock)
+
+			watchers = nil
+			fakeClient.PrependWatchReactor("persistentvolumeclaims", func(action testing.Action) (bool, watch.Interface, error) {
+				w := watchers[0]
+				watchers = watchers[1:]
+				return true, w, nil
+			})
+		})
+
+		createDisk := func() <-chan error {
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				errCh <- err
+			}()
+			return errCh
+		}
+
+		Context("when the claim is bound before the watch starts", func() {
+			BeforeEach(func() {
+				fakeClient.PrependReactor("list", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, &v1.PersistentVolumeClaimList{Items: []v1.PersistentVolumeClaim{*boundPVC}}, nil
+				})
+			})
+
+			It("does not watch the claim", func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeClient.MatchingActions("watch", "persistentvolumeclaims")).To(BeEmpty())
+			})
+		})
+
+		Context("when the bound claim is added", func() {
+			BeforeEach(func() {
+				added := watch.NewFakeWithChanSize(1, false)
+				added.Add(boundPVC)
+				watchers = []*watch.FakeWatcher{added}
+			})
+
+			It("stops waiting", func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).NotTo(HaveOccurred())
+			})
+		})
+
+		Context("when the watch is closed", func() {
+			BeforeEach(func() {
+				closed := watch.NewFake()
+				closed.Stop()
+
+				modified := watch.NewFakeWithChanSize(1, false)
+				modified.Modify(boundPVC)
+				watchers = []*watch.FakeWatcher{closed, modified}
+			})
+
+			It("lists and watches the claim again after a delay", func() {
+				errCh := createDisk()
+
+				Eventually(fakeClock.WatcherCount).Should(Equal(2))
+				Consistently(errCh).ShouldNot(Receive())
+				fakeClock.Increment(actions.WatchRetryDelay)
+
+				Eventually(errCh).Should(Receive(BeNil()))
+				Expect(fakeClient.MatchingActions("list", "persistentvolumeclaims")).To(HaveLen(2))
+				Expect(fakeClient.MatchingActions("watch", "persistentvolumeclaims")).To(HaveLen(2))
+			})
+		})
+
+		Context("when the resource version of the watch expired", func() {
+			BeforeEach(func() {
+				expired := watch.NewFakeWithChanSize(1, false)
+				expired.Error(&unversioned.Status{
+					Status: unversioned.StatusFailure,
+					Code:   http.StatusGone,
+					Reason: unversioned.StatusReasonExpired,
+				})
+
+				modified := watch.NewFakeWithChanSize(1, false)
+				modified.Modify(boundPVC)
+				watchers = []*watch.FakeWatcher{expired, modified}
+			})
+
+			It("lists and watches the claim again", func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeClient.MatchingActions("list", "persistentvolumeclaims")).To(HaveLen(2))
+				Expect(fakeClient.MatchingActions("watch", "persistentvolumeclaims")).To(HaveLen(2))
+			})
+		})
+
+		Context("when the watch fails with an error event", func() {
+			BeforeEach(func() {
+				failed := watch.NewFakeWithChanSize(1, false)
+				failed.Error(&unversioned.Status{
+					Status:  unversioned.StatusFailure,
+					Code:    http.StatusForbidden,
+					Reason:  unversioned.StatusReasonForbidden,
+					Message: "watch-welp",
+				})
+				watchers = []*watch.FakeWatcher{failed}
+			})
+
+			It("returns the error", func() {
+				_, err := diskCreator.CreateDisk(1024, cloudProps, vmcid)
+				Expect(err).To(MatchError(ContainSubstring("Watching: watch-welp")))
+			})
+		})
+
+		Context("when the claim cannot be watched", func() {
+			BeforeEach(func() {
+				diskCreator.DiskReadyTimeout = 4 * actions.PollInterval
+				fakeClient.PrependWatchReactor("persistentvolumeclaims", func(action testing.Action) (bool, watch.Interface, error) {
+					return true, nil, kubeerrors.NewMethodNotSupported(unversioned.GroupResource{Resource: "persistentvolumeclaims"}, "watch")
+				})
+
+				lists := 0
+				fakeClient.PrependReactor("list", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+					lists++
+					if lists == 1 {
+						return true, &v1.PersistentVolumeClaimList{}, nil
+					}
+					return true, &v1.PersistentVolumeClaimList{Items: []v1.PersistentVolumeClaim{*boundPVC}}, nil
+				})
+			})
+
+			It("polls the claim", func() {
+				errCh := createDisk()
+
+				Eventually(fakeClock.WatcherCount).Should(Equal(2))
+				fakeClock.Increment(actions.PollInterval)
+
+				Eventually(errCh).Should(Receive(BeNil()))
+				Expect(fakeClient.MatchingActions("list", "persistentvolumeclaims")).To(HaveLen(2))
+			})
+		})
+	})
 })
@@ -3,7 +3,6 @@ package actions
 import (
 	"encoding/json"
 	"io/ioutil"
-	"reflect"
 	"sync"
 	"time"
 
@@ -15,8 +14,7 @@ import (
 	kubeerrors "k8s.io/client-go/pkg/api/errors"
 	api "k8s.io/client-go/pkg/api/v1"
 	v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
-	"k8s.io/client-go/pkg/labels"
-	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/pkg/runtime"
 
 	"github.ibm.com/Bluemix/kubernetes-cpi/agent"
 	"github.ibm.com/Bluemix/kubernetes-cpi/config"
@@ -30,9 +28,11 @@ import (
 
 type VMCreator struct {
 	AgentConfig            *config.Agent
+	CPIConfig              *config.CPI
 	ClientProvider         kubecluster.ClientProvider
 	Clock                  clock.Clock
 	DeploymentReadyTimeout time.Duration
+	PodReadyTimeout        time.Duration
 }
 
 type Service struct {
@@ -108,12 +108,45 @@ type Resources struct {
 	Requests ResourceList `json:"requests"`
 }
 
+// StorageClassAnnotation records the storage class for the persistent
+// disks of a VM on its pods.
+const StorageClassAnnotation = "bosh.cloudfoundry.org/storage-class"
+
 type VMCloudProperties struct {
 	Context   string    `json:"context"`
 	Services  []Service `json:"services,omitempty"`
 	Secrets   []Secret  `json:"secrets,omitempty"`
 	Resources Resources `json:"resources,omitempty"`
 	Replicas  *int32    `json:"replicas"`
+
+	// Workload is "deployment" or "statefulset" for replicated VMs. VMs
+	// with replicas are deployments unless the workload says otherwise.
+	Workload string `json:"workload,omitempty"`
+
+	// PersistentDiskSize is the size in MiB of the per-replica persistent
+	// disks of a StatefulSet.
+	PersistentDiskSize int `json:"persistent_disk_size,omitempty"`
+
+	// VMType names the profile from the CPI configuration that was applied
+	// to these cloud properties.
+	VMType           string            `json:"vm_type,omitempty"`
+	ImagePullSecrets []string          `json:"image_pull_secrets,omitempty"`
+	NodeSelector     map[string]string `json:"node_selector,omitempty"`
+
+	Tolerations               []v1.Toleration            `json:"tolerations,omitempty"`
+	Affinity                  *v1.Affinity               `json:"affinity,omitempty"`
+	TopologySpreadConstraints []TopologySpreadConstraint `json:"topology_spread_constraints,omitempty"`
+
+	// Zone restricts the pods of the VM to the nodes of a zone.
+	Zone string `json:"zone,omitempty"`
+
+	// AntiAffinity spreads the VMs of a BOSH instance group across nodes.
+	// It is "preferred", "required" or empty.
+	AntiAffinity string `json:"anti_affinity,omitempty"`
+
+	// StorageClass is used for persistent disks created for the VM when
+	// the disk cloud properties do not name a storage class.
+	StorageClass string `json:"storage_class,omitempty"`
 }
 
 func (v *VMCreator) Create(
@@ -124,81 +157,185 @@ func (v *VMCreator) Create(
 	diskCIDs []cpi.DiskCID,
 	env cpi.Environment,
 ) (cpi.VMCID, error) {
+	_, _, _, vmcid, err := v.create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+	return vmcid, err
+}
 
-	// only one network is supported
-	network, err := getNetwork(networks)
+// CreateV2 implements the version 2 contract of create_vm. The result holds
+// the VM CID and the networks with the IP addresses assigned to the pod.
+func (v *VMCreator) CreateV2(
+	agentID string,
+	stemcellCID cpi.StemcellCID,
+	cloudProps VMCloudProperties,
+	networks cpi.Networks,
+	diskCIDs []cpi.DiskCID,
+	env cpi.Environment,
+) ([]interface{}, error) {
+	client, plan, pod, vmcid, err := v.create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 	if err != nil {
-		return "", bosherr.WrapError(err, "Getting network")
+		return nil, err
+	}
+
+	// The pod of a single-pod VM has its IP once the agent is ready.
+	if pod == nil {
+		pod, err = v.waitForPodIP(client.Pods(), agentID)
+		if err != nil {
+			err = agentDiagnostics(client, agentID, err)
+			v.removeVM(vmcid)
+			return nil, bosherr.WrapError(err, "Waiting for pod IP")
+		}
+	}
+
+	assignedIPs := plan.AssignedIPs(pod)
+	vmNetworks := cpi.Networks{}
+	for name, network := range networks {
+		if ip, ok := assignedIPs[name]; ok {
+			network.IP = ip
+		}
+		vmNetworks[name] = network
+	}
+
+	return []interface{}{vmcid, vmNetworks}, nil
+}
+
+func (v *VMCreator) create(
+	agentID string,
+	stemcellCID cpi.StemcellCID,
+	cloudProps VMCloudProperties,
+	networks cpi.Networks,
+	diskCIDs []cpi.DiskCID,
+	env cpi.Environment,
+) (kubecluster.Client, *vmNetworks, *v1.Pod, cpi.VMCID, error) {
+
+	// the default network is the pod network, the others are attachments
+	plan, err := planNetworks(networks)
+	if err != nil {
+		return nil, nil, nil, "", bosherr.WrapError(err, "Getting network")
+	}
+
+	switch cloudProps.Workload {
+	case "", WorkloadDeployment, WorkloadStatefulSet:
+	default:
+		return nil, nil, nil, "", bosherr.Errorf("Invalid workload %q: expected %q or %q", cloudProps.Workload, WorkloadDeployment, WorkloadStatefulSet)
+	}
+	if cloudProps.Workload != "" && cloudProps.Replicas != nil && *cloudProps.Replicas < 1 {
+		return nil, nil, nil, "", bosherr.Error("Invalid number of Replicas specified in Cloud Properties")
+	}
+	if cloudProps.Workload == WorkloadDeployment && cloudProps.Replicas == nil {
+		replicas := int32(1)
+		cloudProps.Replicas = &replicas
 	}
 
 	// create the client set
 	client, err := v.ClientProvider.New(cloudProps.Context)
 	if err != nil {
-		return "", bosherr.WrapError(err, "Creating client")
+		return nil, nil, nil, "", bosherr.WrapError(err, "Creating client")
+	}
+
+	pinner, err := contextIPPinner(v.CPIConfig, client.Context())
+	if err != nil {
+		return nil, nil, nil, "", err
 	}
 
 	// create the target namespace if it doesn't already exist
 	err = createNamespace(client.Core(), client.Namespace())
 	if err != nil {
-		return "", bosherr.WrapError(err, "Creating namespace")
+		return nil, nil, nil, "", bosherr.WrapError(err, "Creating namespace")
 	}
 
+	vmcid := NewVMCID(client.Context(), agentID)
+	readyPod, err := v.createObjects(client, pinner, agentID, stemcellCID, plan, cloudProps, networks, diskCIDs, env)
+	if err != nil {
+		v.removeVM(vmcid)
+		return nil, nil, nil, "", err
+	}
+
+	return client, plan, readyPod, vmcid, nil
+}
+
+// createObjects creates the config map, services, secret and workload of
+// the VM and waits for the agent of a single-pod VM to become ready.
+func (v *VMCreator) createObjects(
+	client kubecluster.Client,
+	pinner IPPinner,
+	agentID string,
+	stemcellCID cpi.StemcellCID,
+	plan *vmNetworks,
+	cloudProps VMCloudProperties,
+	networks cpi.Networks,
+	diskCIDs []cpi.DiskCID,
+	env cpi.Environment,
+) (*v1.Pod, error) {
 	// NOTE: This is a workaround for the fake Clientset. This should be
 	// removed once https://github.com/kubernetes/client-go/issues/48 is
 	// resolved.
 	ns := client.Namespace()
 	instanceSettings, err := v.InstanceSettings(agentID, networks, env)
 	if err != nil {
-		return "", bosherr.WrapError(err, "Creating instance settings")
+		return nil, bosherr.WrapError(err, "Creating instance settings")
 	}
 
 	// create the config map
 	if _, err = createConfigMap(client.ConfigMaps(), ns, agentID, instanceSettings); err != nil {
-		return "", bosherr.WrapError(err, "Creating config map")
+		return nil, bosherr.WrapError(err, "Creating config map")
 	}
 
 	// create the service
 	if err = createServices(client, ns, agentID, cloudProps.Services); err != nil {
-		return "", bosherr.WrapError(err, "Creating services")
+		return nil, bosherr.WrapError(err, "Creating services")
 	}
 
 	if err = createSecret(client.Core(), ns, agentID, cloudProps.Secrets); err != nil {
-		return "", bosherr.WrapError(err, "Creating secret")
+		return nil, bosherr.WrapError(err, "Creating secret")
 	}
 
-	if cloudProps.Replicas == nil {
+	group := boshGroup(env)
+	var readyPod *v1.Pod
+	if cloudProps.Workload == WorkloadStatefulSet {
+		if _, err = v.createStatefulSet(client, ns, agentID, string(stemcellCID), group, plan, cloudProps, diskCIDs); err != nil {
+			return nil, cpi.VMCreationFailedError{Cause: bosherr.WrapError(err, "Creating stateful set")}
+		}
+	} else if cloudProps.Replicas == nil {
 		// create the pod
-		if _, err = createPod(client.Pods(), ns, agentID, string(stemcellCID), *network, cloudProps.Resources); err != nil {
-			return "", bosherr.WrapError(err, "Creating pod")
+		if _, err = createPod(client, ns, agentID, string(stemcellCID), v.AgentConfig.MessageBus, group, plan, cloudProps, pinner); err != nil {
+			return nil, cpi.VMCreationFailedError{Cause: bosherr.WrapError(err, "Creating pod")}
+		}
+
+		if pinner != nil {
+			if err = v.verifyPodIP(client.Pods(), agentID, plan.DefaultNetwork().IP); err != nil {
+				return nil, cpi.VMCreationFailedError{Cause: err}
+			}
+		}
+
+		if readyPod, err = v.waitForPodReady(client.Pods(), agentID); err != nil {
+			return nil, cpi.VMCreationFailedError{Cause: bosherr.WrapError(agentDiagnostics(client, agentID, err), "Waiting for agent")}
 		}
 	} else if *cloudProps.Replicas >= 1 {
 		// create the deployments
-		if _, err = v.createDeployment(client.Deployments(), ns, agentID, string(stemcellCID), *network, cloudProps); err != nil {
-			return "", bosherr.WrapError(err, "Creating deployment")
+		if _, err = v.createDeployment(client, ns, agentID, string(stemcellCID), group, plan, cloudProps); err != nil {
+			return nil, cpi.VMCreationFailedError{Cause: bosherr.WrapError(err, "Creating deployment")}
 		}
 	} else {
-		return "", bosherr.Error("Invalid number of Replicas specified in Cloud Properties")
+		return nil, bosherr.Error("Invalid number of Replicas specified in Cloud Properties")
 	}
 
-	return NewVMCID(client.Context(), agentID), nil
+	return readyPod, nil
 }
 
-func getNetwork(networks cpi.Networks) (*cpi.Network, error) {
-	switch len(networks) {
-	case 0:
-		return nil, bosherr.Error("a network is required")
-	case 1:
-		for _, nw := range networks {
-			return &nw, nil
-		}
-	default:
-		return nil, bosherr.Error("multiple networks not supported")
-	}
-
-	panic("unreachable")
+// removeVM deletes the objects of a VM that failed to create. The director
+// never learns the CID of such a VM, so they are removed on a best effort
+// basis.
+func (v *VMCreator) removeVM(vmcid cpi.VMCID) {
+	deleter := &VMDeleter{ClientProvider: v.ClientProvider}
+	deleter.Delete(vmcid)
 }
 
 func (v *VMCreator) InstanceSettings(agentID string, networks cpi.Networks, env cpi.Environment) (*agent.Settings, error) {
+	plan, err := planNetworks(networks)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting network")
+	}
+
 	agentNetworks := agent.Networks{}
 	for name, cpiNetwork := range networks {
 		agentNetwork := agent.Network{}
@@ -206,6 +343,7 @@ func (v *VMCreator) InstanceSettings(agentID string, networks cpi.Networks, env
 			return nil, bosherr.WrapError(err, "Remarshalling network")
 		}
 		agentNetwork.Preconfigured = true
+		agentNetwork.Interface = plan.Interfaces[name]
 		agentNetworks[name] = agentNetwork
 	}
 
@@ -403,206 +541,289 @@ func createSecret(coreClient core.CoreV1Interface, ns, agentID string, secrets [
 	return nil
 }
 
-func createPod(podClient core.PodInterface, ns, agentID, image string, network cpi.Network, resources Resources) (*v1.Pod, error) {
-	trueValue := true
-	rootUID := int64(0)
+func createPod(client kubecluster.Client, ns, agentID, image, mbus, group string, networks *vmNetworks, cloudProps VMCloudProperties, pinner IPPinner) (*v1.Pod, error) {
+	scheduling, err := schedulingFields(cloudProps, group)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting scheduling fields")
+	}
+
+	annotations, err := podAnnotations(networks, cloudProps)
+	if err != nil {
+		return nil, err
+	}
 
-	annotations := map[string]string{}
-	if len(network.IP) > 0 {
-		annotations["bosh.cloudfoundry.org/ip-address"] = network.IP
+	if err := pinIP(pinner, networks.DefaultNetwork(), annotations); err != nil {
+		return nil, bosherr.WrapError(err, "Pinning static IP")
 	}
 
-	resourceReqs, err := getPodResourceRequirements(resources)
+	podSpec, err := newPodSpec(agentID, image, mbus, cloudProps)
 	if err != nil {
-		return nil, bosherr.WrapError(err, "Getting pod resource requirements")
+		return nil, err
 	}
 
-	return podClient.Create(&v1.Pod{
+	return createUnstructuredPod(client, &v1.Pod{
 		ObjectMeta: v1.ObjectMeta{
 			Name:        "agent-" + agentID,
 			Namespace:   ns,
 			Annotations: annotations,
-			Labels: map[string]string{
-				"bosh.cloudfoundry.org/agent-id": agentID,
-			},
+			Labels:      podLabels(agentID, group),
 		},
-		Spec: v1.PodSpec{
-			Hostname: agentID,
-			Containers: []v1.Container{{
-				Name:            "bosh-job",
-				Image:           image,
-				ImagePullPolicy: v1.PullAlways,
-				Command:         []string{"/usr/sbin/runsvdir-start"},
-				Args:            []string{},
-				Resources:       resourceReqs,
-				SecurityContext: &v1.SecurityContext{
-					Privileged: &trueValue,
-					RunAsUser:  &rootUID,
-				},
-				VolumeMounts: []v1.VolumeMount{{
-					Name:      "bosh-config",
-					MountPath: "/var/vcap/bosh/instance_settings.json",
-					SubPath:   "instance_settings.json",
-				}, {
-					Name:      "bosh-ephemeral",
-					MountPath: "/var/vcap/data",
-				}},
+		Spec: podSpec,
+	}, nil, scheduling)
+}
+
+func podAnnotations(networks *vmNetworks, cloudProps VMCloudProperties) (map[string]string, error) {
+	annotations, err := networks.Annotations()
+	if err != nil {
+		return nil, err
+	}
+
+	if network := networks.DefaultNetwork(); len(network.IP) > 0 {
+		annotations[IPAddressAnnotation] = network.IP
+	}
+	if len(cloudProps.StorageClass) > 0 {
+		annotations[StorageClassAnnotation] = cloudProps.StorageClass
+	}
+	if len(cloudProps.Zone) > 0 {
+		annotations[ZoneAnnotation] = cloudProps.Zone
+	}
+	return annotations, nil
+}
+
+// newPodSpec returns the spec of the pods that run the agent.
+func newPodSpec(agentID, image, mbus string, cloudProps VMCloudProperties) (v1.PodSpec, error) {
+	trueValue := true
+	rootUID := int64(0)
+
+	resourceReqs, err := getPodResourceRequirements(cloudProps.Resources)
+	if err != nil {
+		return v1.PodSpec{}, bosherr.WrapError(err, "Getting pod resource requirements")
+	}
+
+	var imagePullSecrets []v1.LocalObjectReference
+	for _, name := range cloudProps.ImagePullSecrets {
+		imagePullSecrets = append(imagePullSecrets, v1.LocalObjectReference{Name: name})
+	}
+
+	return v1.PodSpec{
+		Hostname:         agentID,
+		NodeSelector:     cloudProps.NodeSelector,
+		ImagePullSecrets: imagePullSecrets,
+		Containers: []v1.Container{{
+			Name:            "bosh-job",
+			Image:           image,
+			ImagePullPolicy: v1.PullAlways,
+			Command:         []string{"/usr/sbin/runsvdir-start"},
+			Args:            []string{},
+			Resources:       resourceReqs,
+			ReadinessProbe:  agentReadinessProbe(mbus),
+			SecurityContext: &v1.SecurityContext{
+				Privileged: &trueValue,
+				RunAsUser:  &rootUID,
+			},
+			VolumeMounts: []v1.VolumeMount{{
+				Name:      "bosh-config",
+				MountPath: "/var/vcap/bosh/instance_settings.json",
+				SubPath:   "instance_settings.json",
+			}, {
+				Name:      "bosh-ephemeral",
+				MountPath: "/var/vcap/data",
 			}},
-			Volumes: []v1.Volume{{
-				Name: "bosh-config",
-				VolumeSource: v1.VolumeSource{
-					ConfigMap: &v1.ConfigMapVolumeSource{
-						LocalObjectReference: v1.LocalObjectReference{
-							Name: "agent-" + agentID,
-						},
-						Items: []v1.KeyToPath{{
-							Key:  "instance_settings",
-							Path: "instance_settings.json",
-						}},
+		}},
+		Volumes: []v1.Volume{{
+			Name: "bosh-config",
+			VolumeSource: v1.VolumeSource{
+				ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{
+						Name: "agent-" + agentID,
 					},
+					Items: []v1.KeyToPath{{
+						Key:  "instance_settings",
+						Path: "instance_settings.json",
+					}},
 				},
-			}, {
-				Name: "bosh-ephemeral",
-				VolumeSource: v1.VolumeSource{
-					EmptyDir: &v1.EmptyDirVolumeSource{},
-				},
-			}},
-		},
-	})
+			},
+		}, {
+			Name: "bosh-ephemeral",
+			VolumeSource: v1.VolumeSource{
+				EmptyDir: &v1.EmptyDirVolumeSource{},
+			},
+		}},
+	}, nil
 }
 
-func (v *VMCreator) createDeployment(deploymentClient extensions.DeploymentInterface,
-	ns, agentID, image string,
-	network cpi.Network,
+func (v *VMCreator) createDeployment(client kubecluster.Client,
+	ns, agentID, image, group string,
+	networks *vmNetworks,
 	cloudProps VMCloudProperties,
 ) (*v1beta1.Deployment, error) {
-	trueValue := true
-	rootUID := int64(0)
+	podSpec, err := newPodSpec(agentID, image, v.AgentConfig.MessageBus, cloudProps)
+	if err != nil {
+		return nil, err
+	}
 
-	annotations := map[string]string{}
-	if len(network.IP) > 0 {
-		annotations["bosh.cloudfoundry.org/ip-address"] = network.IP
+	scheduling, err := schedulingFields(cloudProps, group)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting scheduling fields")
 	}
 
-	resourceReqs, err := getPodResourceRequirements(cloudProps.Resources)
+	annotations, err := podAnnotations(networks, cloudProps)
 	if err != nil {
 		return nil, err
 	}
 
-	deployment, err := deploymentClient.Create(&v1beta1.Deployment{
+	deployment, err := createDeploymentObject(client, &v1beta1.Deployment{
 		ObjectMeta: api.ObjectMeta{
 			Name:      "agent-" + agentID,
 			Namespace: ns,
+			Labels: map[string]string{
+				"bosh.cloudfoundry.org/agent-id": agentID,
+			},
 		},
 		Spec: v1beta1.DeploymentSpec{
 			Replicas: cloudProps.Replicas,
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: api.ObjectMeta{
-					Labels: map[string]string{
-						"bosh.cloudfoundry.org/agent-id": agentID,
-					},
-				},
-				Spec: v1.PodSpec{
-					Hostname: agentID,
-					Containers: []v1.Container{{
-						Name:            "bosh-job",
-						Image:           image,
-						ImagePullPolicy: v1.PullAlways,
-						Command:         []string{"/usr/sbin/runsvdir-start"},
-						Args:            []string{},
-						Resources:       resourceReqs,
-						SecurityContext: &v1.SecurityContext{
-							Privileged: &trueValue,
-							RunAsUser:  &rootUID,
-						},
-						VolumeMounts: []v1.VolumeMount{{
-							Name:      "bosh-config",
-							MountPath: "/var/vcap/bosh/instance_settings.json",
-							SubPath:   "instance_settings.json",
-						}, {
-							Name:      "bosh-ephemeral",
-							MountPath: "/var/vcap/data",
-						}},
-					}},
-					Volumes: []v1.Volume{{
-						Name: "bosh-config",
-						VolumeSource: v1.VolumeSource{
-							ConfigMap: &v1.ConfigMapVolumeSource{
-								LocalObjectReference: v1.LocalObjectReference{
-									Name: "agent-" + agentID,
-								},
-								Items: []v1.KeyToPath{{
-									Key:  "instance_settings",
-									Path: "instance_settings.json",
-								}},
-							},
-						},
-					}, {
-						Name: "bosh-ephemeral",
-						VolumeSource: v1.VolumeSource{
-							EmptyDir: &v1.EmptyDirVolumeSource{},
-						},
-					}},
+					Annotations: annotations,
+					Labels:      podLabels(agentID, group),
 				},
+				Spec: podSpec,
 			},
 			ProgressDeadlineSeconds: &ProgressDeadlineSeconds,
 		},
-	})
+	}, scheduling)
 	if err != nil {
 		return nil, bosherr.WrapError(err, "Creating deployment")
 	}
 
-	if err = v.waitForDeployment(deploymentClient, agentID, deployment.ResourceVersion); err != nil {
-		return nil, bosherr.WrapError(err, "Waiting for deployment")
+	if err = v.waitForDeployment(client.Deployments(), agentID, deployment.ResourceVersion); err != nil {
+		return nil, bosherr.WrapError(agentDiagnostics(client, agentID, err), "Waiting for deployment")
 	}
 
 	return deployment, nil
 }
 
-func (v *VMCreator) waitForDeployment(deploymentService extensions.DeploymentInterface, agentId, resourceVersion string) error {
-	diskSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentId)
+// createDeploymentObject creates the deployment with the scheduling fields
+// in its pod template. The vendored PodSpec lacks them, so deployments with
+// scheduling fields are created as unstructured objects.
+func createDeploymentObject(client kubecluster.Client, deployment *v1beta1.Deployment, scheduling map[string]interface{}) (*v1beta1.Deployment, error) {
+	if len(scheduling) == 0 {
+		return client.Deployments().Create(deployment)
+	}
+
+	obj, err := toUnstructured(deployment, v1beta1.SchemeGroupVersion.String(), "Deployment")
 	if err != nil {
-		return bosherr.WrapError(err, "Parsing disk selector")
+		return nil, err
+	}
+	if err := setPodSpecFields(obj, scheduling, "spec", "template", "spec"); err != nil {
+		return nil, err
 	}
 
-	listOptions := v1.ListOptions{
-		LabelSelector:   diskSelector.String(),
-		ResourceVersion: resourceVersion,
-		Watch:           true,
+	created, err := client.UnstructuredDeployments().Create(obj)
+	if err != nil {
+		return nil, err
 	}
 
-	timer := v.Clock.NewTimer(v.DeploymentReadyTimeout)
-	defer timer.Stop()
+	typed := &v1beta1.Deployment{}
+	if err := fromUnstructured(created, typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
 
-	deploymentWatch, err := deploymentService.Watch(listOptions)
+func (v *VMCreator) waitForDeployment(deploymentService extensions.DeploymentInterface, agentId, resourceVersion string) error {
+	listOptions, err := agentListOptions(agentId)
 	if err != nil {
-		return bosherr.WrapError(err, "Watching deployment")
-	}
-	defer deploymentWatch.Stop()
-
-	for {
-		select {
-		case event := <-deploymentWatch.ResultChan():
-			switch event.Type {
-			case watch.Modified:
-				deployment, ok := event.Object.(*v1beta1.Deployment)
-				if !ok {
-					return bosherr.Errorf("Unexpected object type: %v", reflect.TypeOf(event.Object))
-				}
-				var isReady bool
-				isReady = isDeploymentReady(deployment)
-				if isReady {
-					return nil
-				}
+		return err
+	}
+	listOptions.ResourceVersion = resourceVersion
+
+	w := newDeploymentWaiter(v.Clock, v.DeploymentReadyTimeout, deploymentService, listOptions)
+	w.TimeoutMessage = "Deployment creation failed with a timeout."
 
-			default:
-				return bosherr.Errorf("Unexpected deployment watch event: %s", event.Type)
+	return w.Wait(func(objects []runtime.Object) (bool, error) {
+		for _, obj := range objects {
+			if isDeploymentReady(obj.(*v1beta1.Deployment)) {
+				return true, nil
 			}
+		}
+		return false, nil
+	})
+}
+
+// verifyPodIP waits for the pod of the agent to get an IP and checks that
+// it is the static IP. A pod with another IP is deleted so that the static
+// IP is not mistaken for being in use.
+func (v *VMCreator) verifyPodIP(podService core.PodInterface, agentID, staticIP string) error {
+	if staticIP == "" {
+		return nil
+	}
+
+	pod, err := v.waitForPodIP(podService, agentID)
+	if err != nil {
+		return bosherr.WrapError(err, "Waiting for pod IP")
+	}
+
+	if err := verifyIP(pod.Name, staticIP, pod.Status.PodIP); err != nil {
+		podService.Delete(pod.Name, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+		return err
+	}
+
+	return nil
+}
+
+// waitForPodIP returns the first pod of the agent that has an IP address.
+func (v *VMCreator) waitForPodIP(podService core.PodInterface, agentID string) (*v1.Pod, error) {
+	listOptions, err := agentListOptions(agentID)
+	if err != nil {
+		return nil, err
+	}
 
-		case <-timer.C():
-			return bosherr.Error("Deployment creation failed with a timeout.")
+	w := newPodWaiter(v.Clock, v.PodReadyTimeout, podService, listOptions)
+	w.TimeoutMessage = "Pod IP assignment failed with a timeout"
+
+	var pod *v1.Pod
+	err = w.Wait(func(objects []runtime.Object) (bool, error) {
+		for _, obj := range objects {
+			if p := obj.(*v1.Pod); len(p.Status.PodIP) > 0 {
+				pod = p
+				return true, nil
+			}
 		}
+		return false, nil
+	})
+
+	return pod, err
+}
+
+// waitForPodReady waits until the readiness probe of the bosh-job
+// container reports that the agent is up. It gives up early when the pod
+// cannot be scheduled or its containers cannot start.
+func (v *VMCreator) waitForPodReady(podService core.PodInterface, agentID string) (*v1.Pod, error) {
+	listOptions, err := agentListOptions(agentID)
+	if err != nil {
+		return nil, err
 	}
+
+	w := newPodWaiter(v.Clock, v.PodReadyTimeout, podService, listOptions)
+	w.TimeoutMessage = "Agent readiness failed with a timeout"
+
+	var pod *v1.Pod
+	err = w.Wait(func(objects []runtime.Object) (bool, error) {
+		for _, obj := range objects {
+			p := obj.(*v1.Pod)
+			if isAgentContainerRunning(p) {
+				pod = p
+				return true, nil
+			}
+			if err := podFailure(p); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	})
+
+	return pod, err
 }
 
 func isDeploymentReady(deployment *v1beta1.Deployment) bool {
@@ -19,5 +19,10 @@ func (d *DiskDeleter) DeleteDisk(diskCID cpi.DiskCID) error {
 		return bosherr.WrapError(err, "Creating client")
 	}
 
-	return client.PersistentVolumeClaims().Delete("disk-"+diskID, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	err = client.PersistentVolumeClaims().Delete("disk-"+diskID, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	if isNotFoundStatusError(err) {
+		return cpi.DiskNotFoundError{DiskCID: diskCID}
+	}
+
+	return err
 }
@@ -3,7 +3,9 @@ package actions_test
 import (
 	"errors"
 
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
 	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/testing"
@@ -98,4 +100,17 @@ var _ = Describe("DeleteDisk", func() {
 			Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(HaveLen(1))
 		})
 	})
+
+	Context("when the pv claim does not exist", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("delete", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, kubeerrors.NewNotFound(unversioned.GroupResource{Resource: "persistentvolumeclaims"}, "disk-disk-id")
+			})
+		})
+
+		It("returns a disk not found error", func() {
+			err := diskDeleter.DeleteDisk(diskCID)
+			Expect(err).To(MatchError(cpi.DiskNotFoundError{DiskCID: diskCID}))
+		})
+	})
 })
@@ -0,0 +1,30 @@
+package actions
+
+import (
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+	"k8s.io/client-go/pkg/api/v1"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+type SnapshotDeleter struct {
+	ClientProvider kubecluster.ClientProvider
+}
+
+// DeleteSnapshot removes the VolumeSnapshot. A snapshot that is already gone
+// is not an error.
+func (s *SnapshotDeleter) DeleteSnapshot(snapshotCID cpi.SnapshotCID) error {
+	context, snapshotID := ParseSnapshotCID(snapshotCID)
+	client, err := s.ClientProvider.New(context)
+	if err != nil {
+		return bosherr.WrapError(err, "Creating client")
+	}
+
+	err = client.VolumeSnapshots().Delete("snapshot-"+snapshotID, &v1.DeleteOptions{})
+	if err != nil && !isNotFoundStatusError(err) {
+		return bosherr.WrapError(err, "Deleting VolumeSnapshot")
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package actions_test
+
+import (
+	"errors"
+
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+var _ = Describe("DeleteSnapshot", func() {
+	var (
+		fakeClient   *fakes.Client
+		fakeProvider *fakes.ClientProvider
+		snapshotCID  cpi.SnapshotCID
+
+		snapshotDeleter *actions.SnapshotDeleter
+	)
+
+	BeforeEach(func() {
+		snapshotCID = actions.NewSnapshotCID("bosh", "snapshot-id")
+
+		fakeClient = fakes.NewClient()
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+
+		snapshot := &runtime.Unstructured{Object: map[string]interface{}{}}
+		snapshot.SetName("snapshot-snapshot-id")
+		_, err := fakeClient.VolumeSnapshots().Create(snapshot)
+		Expect(err).NotTo(HaveOccurred())
+		fakeClient.ClearActions()
+
+		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewReturns(fakeClient, nil)
+
+		snapshotDeleter = &actions.SnapshotDeleter{ClientProvider: fakeProvider}
+	})
+
+	It("gets a client for the context of the snapshot", func() {
+		err := snapshotDeleter.DeleteSnapshot(snapshotCID)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeProvider.NewCallCount()).To(Equal(1))
+		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+	})
+
+	It("deletes the VolumeSnapshot", func() {
+		err := snapshotDeleter.DeleteSnapshot(snapshotCID)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("delete", "volumesnapshots")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("snapshot-snapshot-id"))
+		Expect(matches[0].(testing.DeleteAction).GetNamespace()).To(Equal("bosh-namespace"))
+		Expect(fakeClient.Snapshots).To(BeEmpty())
+	})
+
+	Context("when the snapshot does not exist", func() {
+		BeforeEach(func() {
+			snapshotCID = actions.NewSnapshotCID("bosh", "missing")
+		})
+
+		It("succeeds", func() {
+			err := snapshotDeleter.DeleteSnapshot(snapshotCID)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when deleting the snapshot fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("delete", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("boom")
+			})
+		})
+
+		It("returns an error", func() {
+			err := snapshotDeleter.DeleteSnapshot(snapshotCID)
+			Expect(err).To(MatchError(bosherr.WrapError(errors.New("boom"), "Deleting VolumeSnapshot")))
+		})
+	})
+})
@@ -6,17 +6,23 @@ import (
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
+	extensions "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
 	kubeerrors "k8s.io/client-go/pkg/api/errors"
 
 	"k8s.io/client-go/pkg/api/unversioned"
 	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/labels"
+	"k8s.io/client-go/pkg/runtime"
 )
 
 type VMDeleter struct {
 	ClientProvider kubecluster.ClientProvider
 }
 
+// Delete removes every object the CPI created for the agent. Objects are
+// found by their agent label; the pod, deployment and config map are also
+// deleted by name for VMs that were created before they were labeled.
+// Objects that are already gone are skipped, so Delete can be retried.
 func (v *VMDeleter) Delete(vmcid cpi.VMCID) error {
 	context, agentID := ParseVMCID(vmcid)
 
@@ -25,16 +31,52 @@ func (v *VMDeleter) Delete(vmcid cpi.VMCID) error {
 		return bosherr.WrapError(err, "Creating client")
 	}
 
+	listOptions, err := agentListOptions(agentID)
+	if err != nil {
+		return err
+	}
+
+	// The deployment goes first so its replica sets stop recreating pods.
+	err = deleteDeployments(client.Deployments(), agentID, listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Deleting deployments")
+	}
+
+	err = deleteStatefulSets(client.StatefulSets(), listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Deleting stateful sets")
+	}
+
+	err = deleteReplicaSets(client.ReplicaSets(), listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Deleting replica sets")
+	}
+
 	err = deletePod(client.Pods(), agentID)
 	if err != nil {
 		return bosherr.WrapError(err, "Deleting pod")
 	}
 
-	err = deleteServices(client.Services(), agentID)
+	err = deletePods(client.Pods(), listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Deleting pods")
+	}
+
+	err = deleteServices(client.Services(), listOptions)
 	if err != nil {
 		return bosherr.WrapError(err, "Deleting services")
 	}
 
+	err = deleteIngresses(client.IngressService(), listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Deleting ingresses")
+	}
+
+	err = deleteSecrets(client.Secrets(), listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Deleting secrets")
+	}
+
 	err = deleteConfigMap(client.ConfigMaps(), agentID)
 	if err != nil {
 		return bosherr.WrapError(err, "Deleting configmaps")
@@ -43,31 +85,130 @@ func (v *VMDeleter) Delete(vmcid cpi.VMCID) error {
 	return nil
 }
 
+func agentListOptions(agentID string) (v1.ListOptions, error) {
+	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
+	if err != nil {
+		return v1.ListOptions{}, bosherr.WrapError(err, "Parsing agent selector")
+	}
+
+	return v1.ListOptions{LabelSelector: agentSelector.String()}, nil
+}
+
 func deleteConfigMap(configMapService core.ConfigMapInterface, agentID string) error {
 	err := configMapService.Delete("agent-"+agentID, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
-	if statusError, ok := err.(*kubeerrors.StatusError); ok {
-		if statusError.Status().Reason == unversioned.StatusReasonNotFound {
-			return nil
+	return ignoreNotFound(err)
+}
+
+func deleteServices(serviceClient core.ServiceInterface, listOptions v1.ListOptions) error {
+	serviceList, err := serviceClient.List(listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Listing service")
+	}
+
+	for _, service := range serviceList.Items {
+		err := serviceClient.Delete(service.Name, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting service %s", service.Name)
 		}
 	}
-	return err
+
+	return nil
 }
 
-func deleteServices(serviceClient core.ServiceInterface, agentID string) error {
-	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
+func deleteIngresses(ingressClient extensions.IngressInterface, listOptions v1.ListOptions) error {
+	ingressList, err := ingressClient.List(listOptions)
 	if err != nil {
-		return bosherr.WrapError(err, "Parsing agent selector")
+		return bosherr.WrapError(err, "Listing ingresses")
+	}
+
+	for _, ingress := range ingressList.Items {
+		err := ingressClient.Delete(ingress.Name, &v1.DeleteOptions{})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting ingress %s", ingress.Name)
+		}
 	}
 
-	serviceList, err := serviceClient.List(v1.ListOptions{LabelSelector: agentSelector.String()})
+	return nil
+}
+
+func deleteSecrets(secretClient core.SecretInterface, listOptions v1.ListOptions) error {
+	secretList, err := secretClient.List(listOptions)
 	if err != nil {
-		return bosherr.WrapError(err, "Listing service")
+		return bosherr.WrapError(err, "Listing secrets")
 	}
 
-	for _, service := range serviceList.Items {
-		err := serviceClient.Delete(service.Name, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
-		if err != nil {
-			return bosherr.WrapErrorf(err, "Deleting service %s", service.Name)
+	for _, secret := range secretList.Items {
+		err := secretClient.Delete(secret.Name, &v1.DeleteOptions{})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting secret %s", secret.Name)
+		}
+	}
+
+	return nil
+}
+
+// deleteDeployments deletes the deployment of the agent and lets the
+// garbage collector remove its replica sets and pods.
+func deleteDeployments(deploymentClient extensions.DeploymentInterface, agentID string, listOptions v1.ListOptions) error {
+	deploymentList, err := deploymentClient.List(listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Listing deployments")
+	}
+
+	names := []string{"agent-" + agentID}
+	for _, deployment := range deploymentList.Items {
+		if deployment.Name != names[0] {
+			names = append(names, deployment.Name)
+		}
+	}
+
+	for _, name := range names {
+		err := deploymentClient.Delete(name, &v1.DeleteOptions{OrphanDependents: boolPtr(false)})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting deployment %s", name)
+		}
+	}
+
+	return nil
+}
+
+// deleteStatefulSets deletes the stateful sets of the agent. Kubernetes
+// keeps the claims of their volume claim templates, and so does delete_vm:
+// the stateful set of a recreated VM has the same name and its replicas
+// mount the claims again.
+func deleteStatefulSets(statefulSetClient kubecluster.UnstructuredInterface, listOptions v1.ListOptions) error {
+	list, err := statefulSetClient.List(&listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Listing stateful sets")
+	}
+
+	statefulSetList, ok := list.(*runtime.UnstructuredList)
+	if !ok {
+		return bosherr.Errorf("Listing stateful sets: unexpected list %T", list)
+	}
+
+	for _, statefulSet := range statefulSetList.Items {
+		err := statefulSetClient.Delete(statefulSet.GetName(), &v1.DeleteOptions{OrphanDependents: boolPtr(false)})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting stateful set %s", statefulSet.GetName())
+		}
+	}
+
+	return nil
+}
+
+// deleteReplicaSets removes the replica sets the garbage collector has not
+// removed yet.
+func deleteReplicaSets(replicaSetClient extensions.ReplicaSetInterface, listOptions v1.ListOptions) error {
+	replicaSetList, err := replicaSetClient.List(listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Listing replica sets")
+	}
+
+	for _, replicaSet := range replicaSetList.Items {
+		err := replicaSetClient.Delete(replicaSet.Name, &v1.DeleteOptions{OrphanDependents: boolPtr(false)})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting replica set %s", replicaSet.Name)
 		}
 	}
 
@@ -76,6 +217,29 @@ func deleteServices(serviceClient core.ServiceInterface, agentID string) error {
 
 func deletePod(podClient core.PodInterface, agentID string) error {
 	err := podClient.Delete("agent-"+agentID, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	return ignoreNotFound(err)
+}
+
+// deletePods deletes the remaining pods of the agent, such as the replicas
+// of its deployment.
+func deletePods(podClient core.PodInterface, listOptions v1.ListOptions) error {
+	podList, err := podClient.List(listOptions)
+	if err != nil {
+		return bosherr.WrapError(err, "Listing pods")
+	}
+
+	for _, pod := range podList.Items {
+		err := podClient.Delete(pod.Name, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Deleting pod %s", pod.Name)
+		}
+	}
+
+	return nil
+}
+
+
+func ignoreNotFound(err error) error {
 	if statusError, ok := err.(*kubeerrors.StatusError); ok {
 		if statusError.Status().Reason == unversioned.StatusReasonNotFound {
 			return nil
@@ -87,3 +251,7 @@ func deletePod(podClient core.PodInterface, agentID string) error {
 func int64Ptr(i int64) *int64 {
 	return &i
 }
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -8,8 +8,8 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
-	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/pkg/labels"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/testing"
@@ -28,13 +28,6 @@ var _ = Describe("DeleteVM", func() {
 	)
 
 	BeforeEach(func() {
-		fakeClient = fakes.NewClient()
-		fakeClient.ContextReturns("bosh")
-		fakeClient.NamespaceReturns("bosh-namespace")
-
-		fakeProvider = &fakes.ClientProvider{}
-		fakeProvider.NewReturns(fakeClient, nil)
-
 		agentID = "agent-id"
 		vmcid = actions.NewVMCID("bosh", agentID)
 
@@ -48,11 +41,37 @@ var _ = Describe("DeleteVM", func() {
 			},
 		}}
 
-		fakeClient.Clientset = *fake.NewSimpleClientset(
+		agentLabels := map[string]string{"bosh.cloudfoundry.org/agent-id": agentID}
+		otherLabels := map[string]string{"bosh.cloudfoundry.org/agent-id": "other-agent"}
+		groupLabels := map[string]string{"bosh.cloudfoundry.org/group": "web"}
+
+		fakeClient = fakes.NewClient(
 			&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
+			&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "agent-agent-id-1234-abcd", Namespace: "bosh-namespace", Labels: agentLabels}},
+			&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "agent-other-agent", Namespace: "bosh-namespace", Labels: otherLabels}},
 			&v1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
 			&v1.ServiceList{Items: services},
+			&v1beta1.Deployment{ObjectMeta: v1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace", Labels: agentLabels}},
+			&v1beta1.ReplicaSet{ObjectMeta: v1.ObjectMeta{Name: "agent-agent-id-1234", Namespace: "bosh-namespace", Labels: agentLabels}},
+			&v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Name: "web", Namespace: "bosh-namespace", Labels: agentLabels}},
+			&v1.Secret{ObjectMeta: v1.ObjectMeta{Name: "certs", Namespace: "bosh-namespace", Labels: agentLabels}},
+			&v1.Secret{ObjectMeta: v1.ObjectMeta{Name: "other-certs", Namespace: "bosh-namespace", Labels: otherLabels}},
+			&v1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{Name: "bosh-persistent-web-0", Namespace: "bosh-namespace", Labels: groupLabels}},
+			&v1.PersistentVolumeClaim{ObjectMeta: v1.ObjectMeta{
+				Name:      "disk-bosh-disk",
+				Namespace: "bosh-namespace",
+				Labels: map[string]string{
+					"bosh.cloudfoundry.org/agent-id": agentID,
+					"bosh.cloudfoundry.org/disk-id":  "bosh-disk",
+				},
+			}},
 		)
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+		fakeClient.Sets["web"] = newStatefulSet("web", agentLabels)
+
+		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewReturns(fakeClient, nil)
 
 		vmDeleter = &actions.VMDeleter{ClientProvider: fakeProvider}
 	})
@@ -81,7 +100,7 @@ var _ = Describe("DeleteVM", func() {
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("delete", "pods")
-		Expect(matches).To(HaveLen(1))
+		Expect(matches).To(HaveLen(2))
 
 		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("agent-" + agentID))
 		Expect(matches[0].(testing.DeleteAction).GetNamespace()).To(Equal("bosh-namespace"))
@@ -106,6 +125,62 @@ var _ = Describe("DeleteVM", func() {
 		Expect(matches[0].(testing.DeleteAction).GetNamespace()).To(Equal("bosh-namespace"))
 	})
 
+	It("deletes the deployment and its replica sets", func() {
+		err := vmDeleter.Delete(vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("delete", "deployments")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("agent-" + agentID))
+
+		matches = fakeClient.MatchingActions("delete", "replicasets")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("agent-agent-id-1234"))
+	})
+
+	It("deletes stateful sets labeled with the agent ID", func() {
+		err := vmDeleter.Delete(vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("delete", "statefulsets")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("web"))
+	})
+
+	It("keeps the claims of the stateful set disks and BOSH disks", func() {
+		err := vmDeleter.Delete(vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(BeEmpty())
+
+		claims, err := fakeClient.PersistentVolumeClaims().List(v1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claims.Items).To(HaveLen(2))
+	})
+
+	It("deletes the remaining pods labeled with the agent ID", func() {
+		err := vmDeleter.Delete(vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		pods, err := fakeClient.Pods().List(v1.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pods.Items).To(HaveLen(1))
+		Expect(pods.Items[0].Name).To(Equal("agent-other-agent"))
+	})
+
+	It("deletes ingresses and secrets labeled with the agent ID", func() {
+		err := vmDeleter.Delete(vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("delete", "ingresses")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("web"))
+
+		matches = fakeClient.MatchingActions("delete", "secrets")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("certs"))
+	})
+
 	It("deletes the config map", func() {
 		err := vmDeleter.Delete(vmcid)
 		Expect(err).NotTo(HaveOccurred())
@@ -127,8 +202,13 @@ var _ = Describe("DeleteVM", func() {
 			err := vmDeleter.Delete(vmcid)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeClient.Actions()).To(HaveLen(7))
-			Expect(fakeClient.MatchingActions("delete", "pods")).To(HaveLen(2))
+			Expect(fakeClient.Actions()).To(HaveLen(26))
+			Expect(fakeClient.MatchingActions("delete", "deployments")).To(HaveLen(2))
+			Expect(fakeClient.MatchingActions("delete", "replicasets")).To(HaveLen(1))
+			Expect(fakeClient.MatchingActions("delete", "statefulsets")).To(HaveLen(1))
+			Expect(fakeClient.MatchingActions("delete", "pods")).To(HaveLen(3))
+			Expect(fakeClient.MatchingActions("delete", "ingresses")).To(HaveLen(1))
+			Expect(fakeClient.MatchingActions("delete", "secrets")).To(HaveLen(1))
 			Expect(fakeClient.MatchingActions("list", "services")).To(HaveLen(2))
 			Expect(fakeClient.MatchingActions("delete", "services")).To(HaveLen(1))
 			Expect(fakeClient.MatchingActions("delete", "configmaps")).To(HaveLen(2))
@@ -149,6 +229,20 @@ var _ = Describe("DeleteVM", func() {
 		})
 	})
 
+	Context("when deleting the deployment fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("delete", "deployments", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("deployments-welp")
+			})
+		})
+
+		It("returns an error", func() {
+			err := vmDeleter.Delete(vmcid)
+			Expect(err).To(MatchError(ContainSubstring("deployments-welp")))
+			Expect(fakeClient.MatchingActions("delete", "pods")).To(BeEmpty())
+		})
+	})
+
 	Context("when deleting the config map fails", func() {
 		BeforeEach(func() {
 			fakeClient.PrependReactor("delete", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
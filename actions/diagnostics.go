@@ -0,0 +1,192 @@
+package actions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/pkg/api/v1"
+	v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/fields"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+)
+
+var (
+	// DiagnosticEvents is the number of recent events reported for every
+	// object in the diagnostics of a failure.
+	DiagnosticEvents = 10
+
+	// DiagnosticLogLines is the number of lines of the bosh-job container log
+	// reported for every pod in the diagnostics of a failure.
+	DiagnosticLogLines int64 = 20
+)
+
+// agentDiagnostics adds the state of the deployment and the pods of the agent
+// to err. Diagnostics are collected on a best effort basis; failures to
+// collect them are reported in the diagnostics.
+func agentDiagnostics(client kubecluster.Client, agentID string, err error) error {
+	var lines []string
+
+	deployment, getErr := vmDeployment(client, agentID)
+	if getErr != nil {
+		lines = append(lines, getErr.Error())
+	}
+	if deployment != nil {
+		lines = append(lines, describeDeployment(client, deployment)...)
+	}
+
+	listOptions, listErr := agentListOptions(agentID)
+	if listErr == nil {
+		var podList *v1.PodList
+		podList, listErr = client.Pods().List(listOptions)
+		if podList != nil {
+			for i := range podList.Items {
+				lines = append(lines, describePod(client, &podList.Items[i])...)
+			}
+		}
+	}
+	if listErr != nil {
+		lines = append(lines, fmt.Sprintf("Listing pods: %s", listErr))
+	}
+
+	return withDiagnostics(err, lines)
+}
+
+// diskDiagnostics adds the state of the claim of the disk to err.
+func diskDiagnostics(client kubecluster.Client, diskID string, err error) error {
+	var lines []string
+
+	pvc, getErr := client.PersistentVolumeClaims().Get("disk-" + diskID)
+	if getErr != nil {
+		lines = append(lines, fmt.Sprintf("Getting PVC: %s", getErr))
+	} else {
+		lines = append(lines, fmt.Sprintf("PersistentVolumeClaim %s: %s", pvc.Name, pvc.Status.Phase))
+		lines = append(lines, describeEvents(client, "PersistentVolumeClaim", pvc.Name)...)
+	}
+
+	return withDiagnostics(err, lines)
+}
+
+// snapshotDiagnostics adds the state of the VolumeSnapshot to err.
+func snapshotDiagnostics(client kubecluster.Client, name string, err error) error {
+	var lines []string
+
+	snapshot, getErr := client.VolumeSnapshots().Get(name)
+	if getErr != nil {
+		lines = append(lines, fmt.Sprintf("Getting VolumeSnapshot: %s", getErr))
+	} else {
+		lines = append(lines, fmt.Sprintf("VolumeSnapshot %s: ready %t", name, isSnapshotReady(snapshot)))
+		if message, ok := unstructuredField(snapshot, "status", "error", "message").(string); ok {
+			lines = append(lines, fmt.Sprintf("  Error: %s", message))
+		}
+		lines = append(lines, describeEvents(client, "VolumeSnapshot", name)...)
+	}
+
+	return withDiagnostics(err, lines)
+}
+
+func withDiagnostics(err error, lines []string) error {
+	if len(lines) == 0 {
+		return err
+	}
+	return cpi.DiagnosticError{Cause: err, Diagnostics: strings.Join(lines, "\n")}
+}
+
+func describeDeployment(client kubecluster.Client, deployment *v1beta1.Deployment) []string {
+	lines := []string{fmt.Sprintf(
+		"Deployment %s: %d updated, %d available, %d unavailable",
+		deployment.Name,
+		deployment.Status.UpdatedReplicas,
+		deployment.Status.AvailableReplicas,
+		deployment.Status.UnavailableReplicas,
+	)}
+
+	for _, condition := range deployment.Status.Conditions {
+		lines = append(lines, fmt.Sprintf("  Condition %s=%s %s: %s", condition.Type, condition.Status, condition.Reason, condition.Message))
+	}
+
+	return append(lines, describeEvents(client, "Deployment", deployment.Name)...)
+}
+
+func describePod(client kubecluster.Client, pod *v1.Pod) []string {
+	lines := []string{fmt.Sprintf("Pod %s: %s", pod.Name, pod.Status.Phase)}
+	if pod.Status.Reason != "" {
+		lines = append(lines, fmt.Sprintf("  Reason %s: %s", pod.Status.Reason, pod.Status.Message))
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		lines = append(lines, fmt.Sprintf("  Condition %s=%s %s: %s", condition.Type, condition.Status, condition.Reason, condition.Message))
+	}
+
+	statuses := append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		lines = append(lines, fmt.Sprintf("  Container %s: %s, ready %t, %d restarts", status.Name, containerState(status.State), status.Ready, status.RestartCount))
+	}
+
+	lines = append(lines, describeEvents(client, "Pod", pod.Name)...)
+
+	logs, err := client.PodLogs(pod.Name, &v1.PodLogOptions{Container: "bosh-job", TailLines: &DiagnosticLogLines})
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("  Getting logs: %s", err))
+	} else if log := strings.TrimRight(string(logs), "\n"); log != "" {
+		lines = append(lines, "  Logs of bosh-job:")
+		for _, line := range strings.Split(log, "\n") {
+			lines = append(lines, "    "+line)
+		}
+	}
+
+	return lines
+}
+
+func containerState(state v1.ContainerState) string {
+	switch {
+	case state.Waiting != nil:
+		return fmt.Sprintf("waiting %s: %s", state.Waiting.Reason, state.Waiting.Message)
+	case state.Terminated != nil:
+		return fmt.Sprintf("terminated %s with exit code %d: %s", state.Terminated.Reason, state.Terminated.ExitCode, state.Terminated.Message)
+	case state.Running != nil:
+		return "running"
+	default:
+		return "unknown"
+	}
+}
+
+// describeEvents returns the most recent events of an object.
+func describeEvents(client kubecluster.Client, kind, name string) []string {
+	eventList, err := client.Events().List(v1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": kind,
+			"involvedObject.name": name,
+		}.AsSelector().String(),
+	})
+	if err != nil {
+		return []string{fmt.Sprintf("  Listing events: %s", err)}
+	}
+
+	var events []v1.Event
+	for _, event := range eventList.Items {
+		if event.InvolvedObject.Kind == kind && event.InvolvedObject.Name == name {
+			events = append(events, event)
+		}
+	}
+
+	sort.Sort(byLastTimestamp(events))
+	if len(events) > DiagnosticEvents {
+		events = events[len(events)-DiagnosticEvents:]
+	}
+
+	var lines []string
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("  Event %s %s (x%d): %s", event.Type, event.Reason, event.Count, event.Message))
+	}
+	return lines
+}
+
+type byLastTimestamp []v1.Event
+
+func (e byLastTimestamp) Len() int           { return len(e) }
+func (e byLastTimestamp) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byLastTimestamp) Less(i, j int) bool { return e[i].LastTimestamp.Before(e[j].LastTimestamp) }
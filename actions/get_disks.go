@@ -23,18 +23,16 @@ func (d *DiskGetter) GetDisks(vmcid cpi.VMCID) ([]cpi.DiskCID, error) {
 		return nil, bosherr.WrapError(err, "Creating client")
 	}
 
-	pod, err := client.Pods().Get("agent-" + agentID)
+	podSpec, err := vmPodSpec(client, agentID)
 	if err != nil {
-		if statusError, ok := err.(*errors.StatusError); ok {
-			if statusError.Status().Code == http.StatusNotFound {
-				return []cpi.DiskCID{}, nil
-			}
-		}
-		return nil, bosherr.WrapError(err, "Getting pod")
+		return nil, err
+	}
+	if podSpec == nil {
+		return []cpi.DiskCID{}, nil
 	}
 
 	diskIDs := []cpi.DiskCID{}
-	for _, v := range pod.Spec.Volumes {
+	for _, v := range podSpec.Volumes {
 		pvc, err := getPVClaim(client.PersistentVolumeClaims(), v.VolumeSource)
 		if err != nil && !isNotFoundStatusError(err) {
 			return nil, bosherr.WrapError(err, "Getting PVC")
@@ -52,6 +50,38 @@ func (d *DiskGetter) GetDisks(vmcid cpi.VMCID) ([]cpi.DiskCID, error) {
 	return diskIDs, nil
 }
 
+// vmPodSpec returns the spec of the pod of a VM or, for VMs backed by a
+// deployment or StatefulSet, the spec of its pod template. Nil is returned
+// when the VM does not exist.
+func vmPodSpec(client kubecluster.Client, agentID string) (*v1.PodSpec, error) {
+	pod, err := client.Pods().Get("agent-" + agentID)
+	if err == nil {
+		return &pod.Spec, nil
+	}
+	if statusError, ok := err.(*errors.StatusError); !ok || statusError.Status().Code != http.StatusNotFound {
+		return nil, bosherr.WrapError(err, "Getting pod")
+	}
+
+	deployment, err := vmDeployment(client, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if deployment != nil {
+		return &deployment.Spec.Template.Spec, nil
+	}
+
+	statefulSet, err := vmStatefulSet(client, agentID)
+	if err != nil || statefulSet == nil {
+		return nil, err
+	}
+
+	podSpec := &v1.PodSpec{}
+	if err := cpi.Remarshal(unstructuredField(statefulSet, "spec", "template", "spec"), podSpec); err != nil {
+		return nil, bosherr.WrapError(err, "Remarshalling pod template of stateful set")
+	}
+	return podSpec, nil
+}
+
 func getPVClaim(pvcClient core.PersistentVolumeClaimInterface, volumeSource v1.VolumeSource) (*v1.PersistentVolumeClaim, error) {
 	if volumeSource.PersistentVolumeClaim != nil {
 		return pvcClient.Get(volumeSource.PersistentVolumeClaim.ClaimName)
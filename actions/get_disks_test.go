@@ -8,6 +8,7 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
 	"k8s.io/client-go/pkg/api/unversioned"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/testing"
 
@@ -141,6 +142,33 @@ var _ = Describe("DiskGetter", func() {
 		})
 	})
 
+	Context("when the VM is backed by a deployment", func() {
+		BeforeEach(func() {
+			_, err := fakeClient.Deployments().Create(&v1beta1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "agent-replicated", Namespace: "bosh-namespace"},
+				Spec: v1beta1.DeploymentSpec{
+					Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{
+							Volumes: []v1.Volume{{
+								Name: "disk-diskID-1",
+								VolumeSource: v1.VolumeSource{
+									PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "disk-diskID-1"},
+								},
+							}},
+						},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the disks of the pod template", func() {
+			disks, err := diskGetter.GetDisks(cpi.VMCID("context-name:replicated"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(disks).To(ConsistOf(cpi.DiskCID("context-name:diskID-1")))
+		})
+	})
+
 	Context("when getting the pod fails", func() {
 		BeforeEach(func() {
 			fakeClient.PrependReactor("get", "pods", func(action testing.Action) (bool, runtime.Object, error) {
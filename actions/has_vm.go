@@ -13,9 +13,27 @@ type VMFinder struct {
 	ClientProvider kubecluster.ClientProvider
 }
 
+// HasVM reports whether the VM has a pod. A VM backed by a deployment or a
+// stateful set also exists while it is rolling out or has no running pods.
 func (f *VMFinder) HasVM(vmcid cpi.VMCID) (bool, error) {
 	_, pod, err := f.FindVM(vmcid)
-	return pod != nil, err
+	if err != nil || pod != nil {
+		return pod != nil, err
+	}
+
+	context, agentID := ParseVMCID(vmcid)
+	client, err := f.ClientProvider.New(context)
+	if err != nil {
+		return false, bosherr.WrapError(err, "Creating client")
+	}
+
+	deployment, err := vmDeployment(client, agentID)
+	if err != nil || deployment != nil {
+		return deployment != nil, err
+	}
+
+	statefulSet, err := vmStatefulSet(client, agentID)
+	return statefulSet != nil, err
 }
 
 func (f *VMFinder) FindVM(vmcid cpi.VMCID) (string, *v1.Pod, error) {
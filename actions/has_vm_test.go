@@ -9,6 +9,7 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/testing"
 
@@ -34,6 +35,8 @@ var _ = Describe("VMFinder", func() {
 			}},
 		})
 
+		fakeClient.NamespaceReturns("bosh-namespace")
+
 		fakeProvider = &fakes.ClientProvider{}
 		fakeProvider.NewReturns(fakeClient, nil)
 
@@ -57,6 +60,39 @@ var _ = Describe("VMFinder", func() {
 			Expect(found).To(BeTrue())
 		})
 
+		Context("when the VM is backed by a deployment without pods", func() {
+			BeforeEach(func() {
+				_, err := fakeClient.Deployments().Create(&v1beta1.Deployment{
+					ObjectMeta: v1.ObjectMeta{Name: "agent-replicated", Namespace: "bosh-namespace"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("returns true", func() {
+				found, err := vmFinder.HasVM(cpi.VMCID("context-name:replicated"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found).To(BeTrue())
+
+				matches := fakeClient.MatchingActions("get", "deployments")
+				Expect(matches).To(HaveLen(1))
+				Expect(matches[0].(testing.GetAction).GetName()).To(Equal("agent-replicated"))
+			})
+		})
+
+		Context("when the VM is backed by a stateful set without pods", func() {
+			BeforeEach(func() {
+				fakeClient.Sets["web"] = newStatefulSet("web", map[string]string{"bosh.cloudfoundry.org/agent-id": "stateful"})
+			})
+
+			It("returns true", func() {
+				found, err := vmFinder.HasVM(cpi.VMCID("context-name:stateful"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(found).To(BeTrue())
+
+				Expect(fakeClient.MatchingActions("list", "statefulsets")).To(HaveLen(1))
+			})
+		})
+
 		It("returns false when the pod is not found", func() {
 			found, err := vmFinder.HasVM(cpi.VMCID("context-name:missing"))
 			Expect(err).NotTo(HaveOccurred())
@@ -1,7 +1,30 @@
 package actions
 
-func Info() map[string]string {
-	info := make(map[string]string)
-	info["api_version"] = "1.0"
+import "github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+
+// APIVersion is the highest CPI API version supported by the actions.
+const APIVersion = 2
+
+// StemcellFormats lists the stemcell formats accepted by create_stemcell. The
+// stemcell is a light stemcell that references a container image.
+var StemcellFormats = []string{"docker-light"}
+
+// Info reports the CPI API version, the stemcell formats and the supported
+// methods of the registry that dispatches the request, including methods
+// registered by embedders.
+func Info(registry *cpi.Registry) map[string]interface{} {
+	methods := []string{}
+	if registry != nil {
+		for _, method := range registry.Methods() {
+			if method.Supported && method.Implemented() {
+				methods = append(methods, method.Name)
+			}
+		}
+	}
+
+	info := make(map[string]interface{})
+	info["api_version"] = APIVersion
+	info["stemcell_formats"] = StemcellFormats
+	info["supported_methods"] = methods
 	return info
 }
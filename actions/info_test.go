@@ -4,15 +4,40 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 )
 
 var _ = Describe("Info", func() {
 	Describe("Info", func() {
 		It("returns the info about CPI", func() {
-			info := actions.Info()
-			expect_info := make(map[string]string)
-			expect_info["api_version"] = "1.0"
-			Expect(info).To(Equal(expect_info))
+			info := actions.Info(actions.NewRegistry())
+			Expect(info).To(HaveKeyWithValue("api_version", 2))
+			Expect(info).To(HaveKeyWithValue("stemcell_formats", []string{"docker-light"}))
+		})
+
+		It("lists the supported methods of the registry", func() {
+			info := actions.Info(actions.NewRegistry())
+			Expect(info["supported_methods"]).To(ContainElement("create_vm"))
+			Expect(info["supported_methods"]).To(ContainElement("resize_disk"))
+			Expect(info["supported_methods"]).NotTo(ContainElement("reboot_vm"))
+			Expect(info["supported_methods"]).NotTo(ContainElement("configure_networks"))
+		})
+
+		It("lists the methods registered by embedders when dispatched", func() {
+			registry := actions.NewRegistry()
+			registry.Register(cpi.Method{
+				Name:      "custom_method",
+				Supported: true,
+				New:       func(cpi.Dependencies) interface{} { return func() error { return nil } },
+			})
+			registry.Register(cpi.Method{Name: "resize_disk"})
+
+			resp, err := registry.Dispatch(&cpi.Request{Method: "info", Args: []interface{}{}}, cpi.Dependencies{})
+			Expect(err).NotTo(HaveOccurred())
+
+			info := resp.Result.(map[string]interface{})
+			Expect(info["supported_methods"]).To(ContainElement("custom_method"))
+			Expect(info["supported_methods"]).NotTo(ContainElement("resize_disk"))
 		})
 	})
 })
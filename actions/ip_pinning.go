@@ -0,0 +1,131 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"text/template"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+)
+
+const (
+	// IPAddressAnnotation records the static IP of a VM on its pods.
+	IPAddressAnnotation = "bosh.cloudfoundry.org/ip-address"
+
+	// CalicoIPAddrsAnnotation requests IP addresses from Calico IPAM.
+	CalicoIPAddrsAnnotation = "cni.projectcalico.org/ipAddrs"
+)
+
+// IPPinner turns the static IP of a network into the pod annotations that
+// make the CNI plugin assign that IP to the pod.
+type IPPinner interface {
+	Annotations(network cpi.Network) (map[string]string, error)
+}
+
+// NewIPPinner returns the IPPinner for a strategy from the CPI
+// configuration. Nil is returned when pinning is disabled.
+func NewIPPinner(conf config.IPPinning) (IPPinner, error) {
+	switch conf.Strategy {
+	case "", "none":
+		return nil, nil
+	case "calico":
+		return CalicoIPPinner{}, nil
+	case "template":
+		return NewTemplateIPPinner(conf.Annotations)
+	default:
+		return nil, bosherr.Errorf("Unknown IP pinning strategy %q", conf.Strategy)
+	}
+}
+
+// CalicoIPPinner requests the static IP through Calico IPAM.
+type CalicoIPPinner struct{}
+
+func (CalicoIPPinner) Annotations(network cpi.Network) (map[string]string, error) {
+	ipAddrs, err := json.Marshal([]string{network.IP})
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling Calico IP addresses")
+	}
+
+	return map[string]string{CalicoIPAddrsAnnotation: string(ipAddrs)}, nil
+}
+
+// TemplateIPPinner renders annotations for CNI plugins without built-in
+// support.
+type TemplateIPPinner struct {
+	templates map[string]*template.Template
+}
+
+func NewTemplateIPPinner(annotations map[string]string) (*TemplateIPPinner, error) {
+	if len(annotations) == 0 {
+		return nil, bosherr.Error("IP pinning strategy template requires annotations")
+	}
+
+	templates := map[string]*template.Template{}
+	for key, text := range annotations {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, bosherr.WrapErrorf(err, "Parsing IP pinning template for %s", key)
+		}
+		templates[key] = tmpl
+	}
+
+	return &TemplateIPPinner{templates: templates}, nil
+}
+
+func (t *TemplateIPPinner) Annotations(network cpi.Network) (map[string]string, error) {
+	keys := make([]string, 0, len(t.templates))
+	for key := range t.templates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	annotations := map[string]string{}
+	for _, key := range keys {
+		var value bytes.Buffer
+		if err := t.templates[key].Execute(&value, network); err != nil {
+			return nil, bosherr.WrapErrorf(err, "Rendering IP pinning template for %s", key)
+		}
+		annotations[key] = value.String()
+	}
+
+	return annotations, nil
+}
+
+// contextIPPinner returns the IPPinner configured for a context.
+func contextIPPinner(cpiConf *config.CPI, context string) (IPPinner, error) {
+	pinner, err := NewIPPinner(cpiConf.ContextIPPinning(context))
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Configuring IP pinning of context %s", context)
+	}
+	return pinner, nil
+}
+
+// pinIP adds the annotations that pin the static IP of the network to the
+// pod annotations. Networks without a static IP are left alone.
+func pinIP(pinner IPPinner, network cpi.Network, annotations map[string]string) error {
+	if pinner == nil || network.IP == "" {
+		return nil
+	}
+
+	pinned, err := pinner.Annotations(network)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range pinned {
+		annotations[key] = value
+	}
+	return nil
+}
+
+// verifyIP checks that a pod got the IP it was pinned to.
+func verifyIP(podName, wantIP, gotIP string) error {
+	if wantIP == "" || wantIP == gotIP {
+		return nil
+	}
+	return bosherr.Errorf("Pod %s was assigned IP %s instead of the static IP %s", podName, gotIP, wantIP)
+}
@@ -0,0 +1,64 @@
+package actions_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+)
+
+var _ = Describe("IPPinner", func() {
+	var network cpi.Network
+
+	BeforeEach(func() {
+		network = cpi.Network{Type: "manual", IP: "10.1.2.3", Netmask: "255.255.255.0", Gateway: "10.1.2.1"}
+	})
+
+	It("returns nil when pinning is disabled", func() {
+		pinner, err := actions.NewIPPinner(config.IPPinning{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pinner).To(BeNil())
+	})
+
+	It("requests the IP from Calico", func() {
+		pinner, err := actions.NewIPPinner(config.IPPinning{Strategy: "calico"})
+		Expect(err).NotTo(HaveOccurred())
+
+		annotations, err := pinner.Annotations(network)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(annotations).To(Equal(map[string]string{"cni.projectcalico.org/ipAddrs": `["10.1.2.3"]`}))
+	})
+
+	It("renders the annotations of the template strategy", func() {
+		pinner, err := actions.NewIPPinner(config.IPPinning{
+			Strategy: "template",
+			Annotations: map[string]string{
+				"ipam.example.com/ip":      "{{.IP}}",
+				"ipam.example.com/gateway": "{{.Gateway}}/{{.Netmask}}",
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		annotations, err := pinner.Annotations(network)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(annotations).To(Equal(map[string]string{
+			"ipam.example.com/ip":      "10.1.2.3",
+			"ipam.example.com/gateway": "10.1.2.1/255.255.255.0",
+		}))
+	})
+
+	It("rejects templates that cannot be parsed", func() {
+		_, err := actions.NewIPPinner(config.IPPinning{
+			Strategy:    "template",
+			Annotations: map[string]string{"ipam.example.com/ip": "{{.IP"},
+		})
+		Expect(err).To(MatchError(ContainSubstring("Parsing IP pinning template for ipam.example.com/ip")))
+	})
+
+	It("rejects unknown strategies", func() {
+		_, err := actions.NewIPPinner(config.IPPinning{Strategy: "weave"})
+		Expect(err).To(MatchError(`Unknown IP pinning strategy "weave"`))
+	})
+})
@@ -0,0 +1,186 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+)
+
+const (
+	// NetworksAnnotation selects the CNI attachments Multus adds to a pod.
+	NetworksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+	// NetworkStatusAnnotation is written by Multus with the interfaces and
+	// addresses of the attachments.
+	NetworkStatusAnnotation = "k8s.v1.cni.cncf.io/network-status"
+
+	// PodInterface is the interface of the pod network.
+	PodInterface = "eth0"
+)
+
+// NetworkCloudProperties are the cloud properties of secondary networks.
+// They select the NetworkAttachmentDefinition that Multus attaches to the
+// pod. Name defaults to the name of the BOSH network and Interface to net1,
+// net2 and so on in the order of the network names.
+type NetworkCloudProperties struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Interface string `json:"interface"`
+}
+
+// NetworkAttachment is an element of the Multus networks annotation.
+type NetworkAttachment struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Interface string `json:"interface,omitempty"`
+}
+
+type networkStatus struct {
+	Name      string   `json:"name"`
+	Interface string   `json:"interface"`
+	IPs       []string `json:"ips"`
+	Default   bool     `json:"default"`
+}
+
+// vmNetworks maps the BOSH networks of a VM to the interfaces of its pods.
+// The default network is the pod network; all other networks are CNI
+// attachments.
+type vmNetworks struct {
+	Default     string
+	Networks    cpi.Networks
+	Interfaces  map[string]string
+	Attachments []NetworkAttachment
+}
+
+// planNetworks picks the default network and the attachments for the other
+// networks. A single network is always the default. With several networks,
+// the default is the one that provides the default gateway.
+func planNetworks(networks cpi.Networks) (*vmNetworks, error) {
+	if len(networks) == 0 {
+		return nil, bosherr.Error("a network is required")
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	plan := &vmNetworks{
+		Networks:   networks,
+		Interfaces: map[string]string{},
+	}
+
+	if len(names) == 1 {
+		plan.Default = names[0]
+	} else {
+		for _, name := range names {
+			if !providesDefault(networks[name], "gateway") {
+				continue
+			}
+			if plan.Default != "" {
+				return nil, bosherr.Errorf("networks %s and %s both provide the default gateway", plan.Default, name)
+			}
+			plan.Default = name
+		}
+		if plan.Default == "" {
+			return nil, bosherr.Error("one network must provide the default gateway")
+		}
+	}
+	plan.Interfaces[plan.Default] = PodInterface
+
+	for _, name := range names {
+		if name == plan.Default {
+			continue
+		}
+
+		var cloudProps NetworkCloudProperties
+		if err := cpi.Remarshal(networks[name].CloudProperties, &cloudProps); err != nil {
+			return nil, bosherr.WrapErrorf(err, "Decoding cloud properties of network %s", name)
+		}
+
+		attachment := NetworkAttachment{
+			Name:      cloudProps.Name,
+			Namespace: cloudProps.Namespace,
+			Interface: cloudProps.Interface,
+		}
+		if attachment.Name == "" {
+			attachment.Name = name
+		}
+		if attachment.Interface == "" {
+			attachment.Interface = fmt.Sprintf("net%d", len(plan.Attachments)+1)
+		}
+
+		for other, iface := range plan.Interfaces {
+			if iface == attachment.Interface {
+				return nil, bosherr.Errorf("networks %s and %s both use interface %s", other, name, iface)
+			}
+		}
+
+		plan.Interfaces[name] = attachment.Interface
+		plan.Attachments = append(plan.Attachments, attachment)
+	}
+
+	return plan, nil
+}
+
+func providesDefault(network cpi.Network, property string) bool {
+	for _, d := range network.Default {
+		if d == property {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultNetwork returns the network that is mapped to the pod network.
+func (n *vmNetworks) DefaultNetwork() cpi.Network {
+	return n.Networks[n.Default]
+}
+
+// Annotations returns the pod annotations that request the attachments.
+func (n *vmNetworks) Annotations() (map[string]string, error) {
+	if len(n.Attachments) == 0 {
+		return map[string]string{}, nil
+	}
+
+	attachments, err := json.Marshal(n.Attachments)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling network attachments")
+	}
+
+	return map[string]string{NetworksAnnotation: string(attachments)}, nil
+}
+
+// AssignedIPs returns the addresses of the networks of a pod by network
+// name. The pod network uses the pod IP; attachments use the network status
+// that Multus recorded on the pod.
+func (n *vmNetworks) AssignedIPs(pod *v1.Pod) map[string]string {
+	ips := map[string]string{}
+	if pod.Status.PodIP != "" {
+		ips[n.Default] = pod.Status.PodIP
+	}
+
+	var statuses []networkStatus
+	if err := json.Unmarshal([]byte(pod.Annotations[NetworkStatusAnnotation]), &statuses); err != nil {
+		return ips
+	}
+
+	for name, iface := range n.Interfaces {
+		if name == n.Default {
+			continue
+		}
+		for _, status := range statuses {
+			if status.Interface == iface && len(status.IPs) > 0 {
+				ips[name] = status.IPs[0]
+			}
+		}
+	}
+
+	return ips
+}
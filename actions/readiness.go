@@ -0,0 +1,98 @@
+package actions
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/intstr"
+)
+
+// AgentProcessCommand checks that the agent is running when the agent does
+// not listen on an mbus port of its own.
+var AgentProcessCommand = []string{"pgrep", "-x", "bosh-agent"}
+
+// agentReadinessProbe returns the readiness probe of the bosh-job container.
+// An agent with an https mbus serves it on the pod, so the probe connects to
+// the mbus port; otherwise it looks for the agent process.
+func agentReadinessProbe(mbus string) *v1.Probe {
+	probe := &v1.Probe{
+		PeriodSeconds:    2,
+		TimeoutSeconds:   1,
+		FailureThreshold: 3,
+	}
+
+	if port, ok := mbusPort(mbus); ok {
+		probe.TCPSocket = &v1.TCPSocketAction{Port: intstr.FromInt(port)}
+	} else {
+		probe.Exec = &v1.ExecAction{Command: AgentProcessCommand}
+	}
+
+	return probe
+}
+
+func mbusPort(mbus string) (int, bool) {
+	mbusURL, err := url.Parse(mbus)
+	if err != nil || mbusURL.Scheme != "https" {
+		return 0, false
+	}
+
+	_, portString, err := net.SplitHostPort(mbusURL.Host)
+	if err != nil {
+		return 0, false
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil || port <= 0 {
+		return 0, false
+	}
+
+	return port, true
+}
+
+// ensureReadinessProbe adds the agent readiness probe to pods that were
+// created without one.
+func ensureReadinessProbe(spec *v1.PodSpec, mbus string) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == "bosh-job" && spec.Containers[i].ReadinessProbe == nil {
+			spec.Containers[i].ReadinessProbe = agentReadinessProbe(mbus)
+		}
+	}
+}
+
+// terminalWaitingReasons are the reasons of waiting containers that will
+// not start without a change to the pod.
+var terminalWaitingReasons = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+	"CrashLoopBackOff":  true,
+}
+
+// podFailure returns an error with the reason when the pod failed or is
+// stuck in a state it will not leave on its own.
+func podFailure(pod *v1.Pod) error {
+	if pod.Status.Phase == v1.PodFailed {
+		return bosherr.Errorf("Pod %s failed: %s: %s", pod.Name, pod.Status.Reason, pod.Status.Message)
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Reason == v1.PodReasonUnschedulable {
+			return bosherr.Errorf("Pod %s is %s: %s", pod.Name, condition.Reason, condition.Message)
+		}
+	}
+
+	statuses := append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting != nil && terminalWaitingReasons[waiting.Reason] {
+			return bosherr.Errorf("Container %s of pod %s is waiting with %s: %s", status.Name, pod.Name, waiting.Reason, waiting.Message)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package actions
+
+import "github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+
+// NewRegistry returns a registry with the CPI methods implemented by this
+// package. Embedders can register additional methods or replace existing
+// ones before handling requests.
+func NewRegistry() *cpi.Registry {
+	registry := cpi.NewRegistry()
+	for _, method := range Methods() {
+		registry.Register(method)
+	}
+	return registry
+}
+
+// Methods describes the CPI methods implemented by this package.
+func Methods() []cpi.Method {
+	return []cpi.Method{
+		// Info
+		{
+			Name:      "info",
+			Supported: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				return func() map[string]interface{} { return Info(deps.Registry) }
+			},
+		},
+
+		// Stemcell management
+		{
+			Name:      "create_stemcell",
+			Supported: true,
+			New:       func(cpi.Dependencies) interface{} { return CreateStemcell },
+		},
+		{
+			Name:      "delete_stemcell",
+			Supported: true,
+			New:       func(cpi.Dependencies) interface{} { return DeleteStemcell },
+		},
+
+		// VM management
+		{
+			Name:            "create_vm",
+			Supported:       true,
+			RequiresContext: true,
+			CloudProperties: &cpi.CloudPropertiesArg{Index: 2, Kind: cpi.VMCloudProperties},
+			New: func(deps cpi.Dependencies) interface{} {
+				vmCreator := &VMCreator{
+					AgentConfig:            deps.AgentConfig,
+					CPIConfig:              deps.CPIConfig,
+					ClientProvider:         deps.ClientProvider,
+					Clock:                  deps.Clock,
+					DeploymentReadyTimeout: deps.Timeouts.DeploymentReady,
+					PodReadyTimeout:        deps.Timeouts.PodReady,
+				}
+				return cpi.Versioned{V1: vmCreator.Create, V2: vmCreator.CreateV2}
+			},
+		},
+		{
+			Name:            "delete_vm",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				vmDeleter := &VMDeleter{ClientProvider: deps.ClientProvider}
+				return vmDeleter.Delete
+			},
+		},
+		{
+			Name:            "has_vm",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				vmFinder := &VMFinder{ClientProvider: deps.ClientProvider}
+				return vmFinder.HasVM
+			},
+		},
+		{
+			Name:            "set_vm_metadata",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				vmMetadataSetter := &VMMetadataSetter{ClientProvider: deps.ClientProvider}
+				return vmMetadataSetter.SetVMMetadata
+			},
+		},
+		{
+			Name: "configure_networks",
+		},
+		{
+			Name: "reboot_vm",
+		},
+
+		// Disk management
+		{
+			Name:            "create_disk",
+			Supported:       true,
+			RequiresContext: true,
+			CloudProperties: &cpi.CloudPropertiesArg{Index: 1, Kind: cpi.DiskCloudProperties},
+			New: func(deps cpi.Dependencies) interface{} {
+				diskCreator := &DiskCreator{
+					ClientProvider:    deps.ClientProvider,
+					Clock:             deps.Clock,
+					DiskReadyTimeout:  deps.Timeouts.DiskReady,
+					DiskCloneTimeout:  deps.Timeouts.DiskCloneReady,
+					GUIDGeneratorFunc: CreateGUID,
+				}
+				return diskCreator.CreateDisk
+			},
+		},
+		{
+			Name:            "attach_disk",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				volumeManager := newVolumeManager(deps)
+				return cpi.Versioned{V1: volumeManager.AttachDisk, V2: volumeManager.AttachDiskV2}
+			},
+		},
+		{
+			Name:            "detach_disk",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				return newVolumeManager(deps).DetachDisk
+			},
+		},
+		{
+			Name:            "set_disk_metadata",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				diskMetadataSetter := &DiskMetadataSetter{ClientProvider: deps.ClientProvider}
+				return diskMetadataSetter.SetDiskMetadata
+			},
+		},
+		{
+			Name:            "has_disk",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				diskFinder := &DiskFinder{ClientProvider: deps.ClientProvider}
+				return diskFinder.HasDisk
+			},
+		},
+		{
+			Name:            "delete_disk",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				diskDeleter := &DiskDeleter{ClientProvider: deps.ClientProvider}
+				return diskDeleter.DeleteDisk
+			},
+		},
+		{
+			Name:            "resize_disk",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				diskResizer := &DiskResizer{
+					ClientProvider:   deps.ClientProvider,
+					CPIConfig:        deps.CPIConfig,
+					Clock:            deps.Clock,
+					DiskReadyTimeout: deps.Timeouts.DiskReady,
+				}
+				return diskResizer.ResizeDisk
+			},
+		},
+		{
+			Name:            "get_disks",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				diskGetter := &DiskGetter{ClientProvider: deps.ClientProvider}
+				return diskGetter.GetDisks
+			},
+		},
+
+		// Snapshots
+		{
+			Name:            "snapshot_disk",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				snapshotCreator := &SnapshotCreator{
+					ClientProvider:       deps.ClientProvider,
+					CPIConfig:            deps.CPIConfig,
+					Clock:                deps.Clock,
+					SnapshotReadyTimeout: deps.Timeouts.SnapshotReady,
+					GUIDGeneratorFunc:    CreateGUID,
+				}
+				return snapshotCreator.SnapshotDisk
+			},
+		},
+		{
+			Name:            "delete_snapshot",
+			Supported:       true,
+			RequiresContext: true,
+			New: func(deps cpi.Dependencies) interface{} {
+				snapshotDeleter := &SnapshotDeleter{ClientProvider: deps.ClientProvider}
+				return snapshotDeleter.DeleteSnapshot
+			},
+		},
+	}
+}
+
+func newVolumeManager(deps cpi.Dependencies) *VolumeManager {
+	return &VolumeManager{
+		AgentConfig:            deps.AgentConfig,
+		ClientProvider:         deps.ClientProvider,
+		CPIConfig:              deps.CPIConfig,
+		Clock:                  deps.Clock,
+		DeploymentReadyTimeout: deps.Timeouts.DeploymentReady,
+		PodReadyTimeout:        deps.Timeouts.PodReady,
+	}
+}
@@ -0,0 +1,72 @@
+package actions_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *cpi.Registry
+	var deps cpi.Dependencies
+
+	BeforeEach(func() {
+		registry = actions.NewRegistry()
+		deps = cpi.Dependencies{
+			ClientProvider: &fakes.ClientProvider{},
+			Clock:          fakeclock.NewFakeClock(time.Now()),
+			AgentConfig:    &config.Agent{},
+			Timeouts:       cpi.DefaultTimeouts,
+		}
+	})
+
+	It("registers the CPI methods", func() {
+		var names []string
+		for _, method := range registry.Methods() {
+			names = append(names, method.Name)
+		}
+
+		Expect(names).To(ConsistOf(
+			"info",
+			"create_stemcell", "delete_stemcell",
+			"create_vm", "delete_vm", "has_vm", "set_vm_metadata", "configure_networks", "reboot_vm",
+			"create_disk", "attach_disk", "detach_disk", "set_disk_metadata", "has_disk", "delete_disk", "resize_disk", "get_disks",
+			"snapshot_disk", "delete_snapshot",
+		))
+	})
+
+	It("constructs an action for every implemented method", func() {
+		for _, method := range registry.Methods() {
+			if method.Implemented() {
+				Expect(method.New(deps)).NotTo(BeNil(), method.Name)
+			}
+		}
+	})
+
+	It("does not require a context for info and stemcell management", func() {
+		for _, name := range []string{"info", "create_stemcell", "delete_stemcell"} {
+			method, ok := registry.Lookup(name)
+			Expect(ok).To(BeTrue())
+			Expect(method.RequiresContext).To(BeFalse(), name)
+		}
+	})
+
+	It("does not support configure_networks and reboot_vm", func() {
+		for _, name := range []string{"configure_networks", "reboot_vm"} {
+			method, ok := registry.Lookup(name)
+			Expect(ok).To(BeTrue())
+			Expect(method.Supported).To(BeFalse(), name)
+		}
+	})
+
+	It("dispatches create_vm according to the API version", func() {
+		method, _ := registry.Lookup("create_vm")
+		Expect(method.New(deps)).To(BeAssignableToTypeOf(cpi.Versioned{}))
+	})
+})
@@ -0,0 +1,207 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/runtime"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// DiskResizer grows disks by expanding the storage request of their claims.
+type DiskResizer struct {
+	ClientProvider   kubecluster.ClientProvider
+	CPIConfig        *config.CPI
+	Clock            clock.Clock
+	DiskReadyTimeout time.Duration
+}
+
+// ResizeDisk expands the claim of the disk and waits until its volume has
+// grown. It fails with NotSupportedError when the volume cannot be
+// expanded, so the director copies the data to a new disk instead. The new
+// size follows the rounding rules of the disk defaults of the context, as
+// in create_disk.
+func (d *DiskResizer) ResizeDisk(diskCID cpi.DiskCID, newSize uint) error {
+	context, diskID := ParseDiskCID(diskCID)
+
+	minimum, increment, err := d.roundingRules(context)
+	if err != nil {
+		return err
+	}
+
+	roundedSize := roundDiskSize(newSize, minimum, increment)
+	size, err := diskSize(roundedSize)
+	if err != nil {
+		return err
+	}
+
+	client, err := d.ClientProvider.New(context)
+	if err != nil {
+		return bosherr.WrapError(err, "Creating client")
+	}
+
+	claims := client.UnstructuredPersistentVolumeClaims()
+	claim, err := claims.Get("disk-" + diskID)
+	if isNotFoundStatusError(err) {
+		return cpi.DiskNotFoundError{DiskCID: diskCID}
+	}
+	if err != nil {
+		return bosherr.WrapError(err, "Getting PVC")
+	}
+
+	requested, err := claimStorage(claim, "spec", "resources", "requests")
+	if err != nil {
+		return err
+	}
+
+	switch cmp := size.Cmp(requested); {
+	case cmp == 0:
+		return nil
+	case cmp < 0:
+		return notSupported(bosherr.Errorf("Disk %s cannot shrink from %s to %s", diskCID, requested.String(), size.String()))
+	}
+
+	if err := checkVolumeExpansion(client, claim); err != nil {
+		return err
+	}
+
+	changes := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"resources": map[string]interface{}{
+				"requests": map[string]interface{}{"storage": size.String()},
+			},
+		},
+	}
+
+	// A requested size left by create_disk or an earlier resize no longer
+	// matches the claim, so it is replaced or removed.
+	if _, ok := claim.GetAnnotations()[RequestedSizeAnnotation]; ok || roundedSize != newSize {
+		var requestedSize interface{}
+		if roundedSize != newSize {
+			requestedSize = fmt.Sprintf("%dMi", newSize)
+		}
+		changes["metadata"] = map[string]interface{}{
+			"annotations": map[string]interface{}{RequestedSizeAnnotation: requestedSize},
+		}
+	}
+
+	patch, err := json.Marshal(changes)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling patch")
+	}
+
+	_, err = claims.Patch(claim.GetName(), api.MergePatchType, patch)
+	if err != nil {
+		return bosherr.WrapError(err, "Patching PVC")
+	}
+
+	if err := d.waitForResize(claims, claim.GetName(), size); err != nil {
+		return bosherr.WrapError(diskDiagnostics(client, diskID, err), "Waiting for disk resize")
+	}
+
+	return nil
+}
+
+// roundingRules returns the minimum_size and size_increment of the disk
+// defaults of the context.
+func (d *DiskResizer) roundingRules(context string) (uint, uint, error) {
+	props, err := d.CPIConfig.ApplyDiskDefaults(config.CloudProperties{"context": context}, context)
+	if err != nil {
+		return 0, 0, bosherr.WrapError(err, "Applying disk defaults")
+	}
+
+	encoded, err := json.Marshal(props)
+	if err != nil {
+		return 0, 0, bosherr.WrapError(err, "Marshalling disk defaults")
+	}
+
+	var cloudProps CreateDiskCloudProperties
+	if err := json.Unmarshal(encoded, &cloudProps); err != nil {
+		return 0, 0, bosherr.WrapError(err, "Parsing disk defaults")
+	}
+
+	return cloudProps.MinimumSize, cloudProps.SizeIncrement, nil
+}
+
+// checkVolumeExpansion returns a NotSupportedError unless the storage class
+// of the claim allows volume expansion.
+func checkVolumeExpansion(client kubecluster.Client, claim *runtime.Unstructured) error {
+	className, _ := unstructuredField(claim, "spec", "storageClassName").(string)
+	if className == "" {
+		className = claim.GetAnnotations()["volume.beta.kubernetes.io/storage-class"]
+	}
+	if className == "" {
+		return notSupported(bosherr.Errorf("Claim %s has no storage class", claim.GetName()))
+	}
+
+	class, err := client.StorageClasses().Get(className)
+	if isNotFoundStatusError(err) {
+		return notSupported(bosherr.Errorf("Storage class %q not found", className))
+	}
+	if err != nil {
+		return bosherr.WrapError(err, "Getting storage class")
+	}
+
+	if allowed, _ := unstructuredField(class, "allowVolumeExpansion").(bool); !allowed {
+		return notSupported(bosherr.Errorf("Storage class %q does not allow volume expansion", className))
+	}
+
+	return nil
+}
+
+// waitForResize polls the claim until its capacity has grown. A pending
+// file system resize also ends the wait: the volume has grown and the
+// kubelet grows the file system when a pod mounts the volume.
+func (d *DiskResizer) waitForResize(claims kubecluster.UnstructuredInterface, name string, size resource.Quantity) error {
+	return poll(d.Clock, d.DiskReadyTimeout, "Disk resize failed with a timeout", func() (bool, error) {
+		claim, err := claims.Get(name)
+		if err != nil {
+			if cpi.IsRetryable(err) {
+				return false, nil
+			}
+			return false, bosherr.WrapError(err, "Getting PVC")
+		}
+
+		if capacity, err := claimStorage(claim, "status", "capacity"); err == nil && capacity.Cmp(size) >= 0 {
+			return true, nil
+		}
+
+		conditions, _ := unstructuredField(claim, "status", "conditions").([]interface{})
+		for _, c := range conditions {
+			condition, _ := c.(map[string]interface{})
+			if condition["type"] == "FileSystemResizePending" && condition["status"] == "True" {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	})
+}
+
+// claimStorage returns the storage quantity of the resource list at the
+// path of fields. A missing quantity is zero.
+func claimStorage(claim *runtime.Unstructured, fields ...string) (resource.Quantity, error) {
+	storage, ok := unstructuredField(claim, append(fields, "storage")...).(string)
+	if !ok {
+		return resource.Quantity{}, nil
+	}
+
+	quantity, err := resource.ParseQuantity(storage)
+	if err != nil {
+		return resource.Quantity{}, bosherr.WrapErrorf(err, "Parsing storage of claim %s", claim.GetName())
+	}
+	return quantity, nil
+}
+
+func notSupported(err error) error {
+	return bosherr.WrapComplexError(err, cpi.NotSupportedError{})
+}
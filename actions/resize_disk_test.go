@@ -0,0 +1,291 @@
+package actions_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/testing"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+var _ = Describe("ResizeDisk", func() {
+	var (
+		fakeClient   *fakes.Client
+		fakeProvider *fakes.ClientProvider
+		fakeClock    *fakeclock.FakeClock
+		diskCID      cpi.DiskCID
+
+		diskResizer *actions.DiskResizer
+	)
+
+	storageClass := func(name string, allowVolumeExpansion bool) *runtime.Unstructured {
+		class := &runtime.Unstructured{Object: map[string]interface{}{
+			"allowVolumeExpansion": allowVolumeExpansion,
+		}}
+		class.SetName(name)
+		return class
+	}
+
+	resizeDisk := func(newSize uint) <-chan error {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- diskResizer.ResizeDisk(diskCID, newSize)
+		}()
+		return errCh
+	}
+
+	BeforeEach(func() {
+		diskCID = actions.NewDiskCID("bosh", "disk-id")
+
+		fakeClient = fakes.NewClient(&v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "disk-disk-id",
+				Namespace: "bosh-namespace",
+				Labels:    map[string]string{"bosh.cloudfoundry.org/disk-id": "disk-id"},
+				Annotations: map[string]string{
+					"volume.beta.kubernetes.io/storage-class": "expandable",
+				},
+			},
+			Spec: v1.PersistentVolumeClaimSpec{
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			},
+			Status: v1.PersistentVolumeClaimStatus{
+				Phase:    v1.ClaimBound,
+				Capacity: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		})
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+		fakeClient.Classes["expandable"] = storageClass("expandable", true)
+		fakeClient.Classes["fixed"] = storageClass("fixed", false)
+
+		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewReturns(fakeClient, nil)
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		diskResizer = &actions.DiskResizer{
+			ClientProvider:   fakeProvider,
+			CPIConfig:        &config.CPI{},
+			Clock:            fakeClock,
+			DiskReadyTimeout: 4 * actions.PollInterval,
+		}
+	})
+
+	It("expands the claim and waits until its capacity has grown", func() {
+		errCh := resizeDisk(2048)
+
+		Eventually(fakeClock.WatcherCount).Should(Equal(2))
+		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+
+		matches := fakeClient.MatchingActions("patch", "persistentvolumeclaims")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.PatchActionImpl).GetName()).To(Equal("disk-disk-id"))
+		Expect(matches[0].(testing.PatchActionImpl).GetPatch()).To(MatchJSON(`{"spec": {"resources": {"requests": {"storage": "2Gi"}}}}`))
+
+		pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(v1.ResourceStorage, resource.MustParse("2Gi")))
+		Consistently(errCh).ShouldNot(Receive())
+
+		pvc.Status.Capacity = v1.ResourceList{v1.ResourceStorage: resource.MustParse("2Gi")}
+		_, err = fakeClient.PersistentVolumeClaims().Update(pvc)
+		Expect(err).NotTo(HaveOccurred())
+		fakeClock.Increment(actions.PollInterval)
+
+		Eventually(errCh).Should(Receive(BeNil()))
+	})
+
+	Context("when the disk defaults have rounding rules", func() {
+		BeforeEach(func() {
+			diskResizer.CPIConfig = &config.CPI{
+				DiskDefaults: config.CloudProperties{"size_increment": 512},
+				Contexts: map[string]*config.ContextCPI{
+					"bosh": {DiskDefaults: config.CloudProperties{"minimum_size": 2048, "size_increment": 1024}},
+				},
+			}
+
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			pvc.Status.Capacity = v1.ResourceList{v1.ResourceStorage: resource.MustParse("4Gi")}
+			_, err = fakeClient.PersistentVolumeClaims().Update(pvc)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("rounds the size with the rules of the context of the disk and records the requested size", func() {
+			err := diskResizer.ResizeDisk(diskCID, 1500)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("patch", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].(testing.PatchActionImpl).GetPatch()).To(MatchJSON(`{
+				"metadata": {"annotations": {"bosh.cloudfoundry.org/requested-size": "1500Mi"}},
+				"spec": {"resources": {"requests": {"storage": "2Gi"}}}
+			}`))
+
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(v1.ResourceStorage, resource.MustParse("2Gi")))
+			Expect(pvc.Annotations).To(HaveKeyWithValue(actions.RequestedSizeAnnotation, "1500Mi"))
+		})
+
+		It("removes a requested size that no longer applies", func() {
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			pvc.Annotations[actions.RequestedSizeAnnotation] = "700Mi"
+			_, err = fakeClient.PersistentVolumeClaims().Update(pvc)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = diskResizer.ResizeDisk(diskCID, 3072)
+			Expect(err).NotTo(HaveOccurred())
+
+			pvc, err = fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvc.Spec.Resources.Requests).To(HaveKeyWithValue(v1.ResourceStorage, resource.MustParse("3Gi")))
+			Expect(pvc.Annotations).NotTo(HaveKey(actions.RequestedSizeAnnotation))
+		})
+	})
+
+	Context("when the file system resize is pending", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				claim := &runtime.Unstructured{Object: map[string]interface{}{
+					"spec": map[string]interface{}{
+						"storageClassName": "expandable",
+						"resources":        map[string]interface{}{"requests": map[string]interface{}{"storage": "1Gi"}},
+					},
+					"status": map[string]interface{}{
+						"capacity":   map[string]interface{}{"storage": "1Gi"},
+						"conditions": []interface{}{map[string]interface{}{"type": "FileSystemResizePending", "status": "True"}},
+					},
+				}}
+				claim.SetName("disk-disk-id")
+				return true, claim, nil
+			})
+		})
+
+		It("stops waiting", func() {
+			err := diskResizer.ResizeDisk(diskCID, 2048)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeClient.MatchingActions("patch", "persistentvolumeclaims")).To(HaveLen(1))
+		})
+	})
+
+	Context("when the disk already has the size", func() {
+		It("does not change the claim", func() {
+			err := diskResizer.ResizeDisk(diskCID, 1024)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeClient.MatchingActions("patch", "persistentvolumeclaims")).To(BeEmpty())
+		})
+	})
+
+	Context("when the volume cannot be expanded", func() {
+		expectNotSupported := func(message string) {
+			err := diskResizer.ResizeDisk(diskCID, 2048)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+			Expect(cpi.NewResponseError(err).Type).To(Equal("Bosh::Clouds::NotSupported"))
+			Expect(fakeClient.MatchingActions("patch", "persistentvolumeclaims")).To(BeEmpty())
+		}
+
+		It("returns a not supported error when the storage class does not allow expansion", func() {
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			pvc.Annotations["volume.beta.kubernetes.io/storage-class"] = "fixed"
+			_, err = fakeClient.PersistentVolumeClaims().Update(pvc)
+			Expect(err).NotTo(HaveOccurred())
+
+			expectNotSupported(`Storage class "fixed" does not allow volume expansion`)
+		})
+
+		It("returns a not supported error when the storage class does not exist", func() {
+			delete(fakeClient.Classes, "expandable")
+			expectNotSupported(`Storage class "expandable" not found`)
+		})
+
+		It("returns a not supported error when the claim has no storage class", func() {
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			pvc.Annotations = nil
+			_, err = fakeClient.PersistentVolumeClaims().Update(pvc)
+			Expect(err).NotTo(HaveOccurred())
+
+			expectNotSupported("Claim disk-disk-id has no storage class")
+		})
+
+		It("returns a not supported error when the disk would shrink", func() {
+			err := diskResizer.ResizeDisk(diskCID, 0)
+			Expect(err).To(MatchError(ContainSubstring("Disk bosh:disk-id cannot shrink from 1Gi to 0")))
+			Expect(cpi.NewResponseError(err).Type).To(Equal("Bosh::Clouds::NotSupported"))
+		})
+	})
+
+	Context("when the claim does not exist", func() {
+		BeforeEach(func() {
+			diskCID = actions.NewDiskCID("bosh", "missing")
+		})
+
+		It("returns a disk not found error", func() {
+			err := diskResizer.ResizeDisk(diskCID, 2048)
+			Expect(err).To(MatchError(cpi.DiskNotFoundError{DiskCID: diskCID}))
+		})
+	})
+
+	Context("when patching the claim fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("patch", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("boom")
+			})
+		})
+
+		It("returns an error", func() {
+			err := diskResizer.ResizeDisk(diskCID, 2048)
+			Expect(err).To(MatchError(bosherr.WrapError(errors.New("boom"), "Patching PVC")))
+		})
+	})
+
+	Context("when the capacity does not grow before the timeout", func() {
+		BeforeEach(func() {
+			_, err := fakeClient.Events().Create(&v1.Event{
+				ObjectMeta: v1.ObjectMeta{Name: "disk-disk-id.1", Namespace: "bosh-namespace"},
+				InvolvedObject: v1.ObjectReference{
+					Kind: "PersistentVolumeClaim",
+					Name: "disk-disk-id",
+				},
+				Type:    v1.EventTypeWarning,
+				Reason:  "VolumeResizeFailed",
+				Message: "quota exceeded",
+				Count:   1,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns the state and the events of the claim", func() {
+			errCh := resizeDisk(2048)
+
+			for i := 0; i < 4; i++ {
+				Eventually(fakeClock.WatcherCount).Should(Equal(2))
+				fakeClock.Increment(actions.PollInterval)
+			}
+
+			var err error
+			Eventually(errCh).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("Waiting for disk resize: Disk resize failed with a timeout")))
+			Expect(cpi.DiagnosticLog(err)).To(ContainSubstring("Event Warning VolumeResizeFailed (x1): quota exceeded"))
+		})
+	})
+})
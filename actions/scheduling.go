@@ -0,0 +1,239 @@
+package actions
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/validation"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+)
+
+const (
+	// GroupLabel holds the BOSH instance group of a VM from env.bosh.group.
+	GroupLabel = "bosh.cloudfoundry.org/group"
+
+	// HostnameTopologyKey is the topology key that spreads pods across nodes.
+	HostnameTopologyKey = "kubernetes.io/hostname"
+
+	AntiAffinityPreferred = "preferred"
+	AntiAffinityRequired  = "required"
+
+	// spreadWeight is the weight of the preferred anti-affinity term of the
+	// instance group.
+	spreadWeight = 100
+
+	DoNotSchedule  = "DoNotSchedule"
+	ScheduleAnyway = "ScheduleAnyway"
+)
+
+// TopologySpreadConstraint spreads the pods matching LabelSelector across
+// the domains of TopologyKey and becomes an entry of the
+// topologySpreadConstraints of the pod spec. LabelSelector defaults to the
+// instance group of the VM, MaxSkew to 1 and WhenUnsatisfiable to
+// ScheduleAnyway.
+type TopologySpreadConstraint struct {
+	MaxSkew           int32             `json:"max_skew"`
+	TopologyKey       string            `json:"topology_key"`
+	WhenUnsatisfiable string            `json:"when_unsatisfiable"`
+	LabelSelector     map[string]string `json:"label_selector,omitempty"`
+}
+
+// boshGroup returns the instance group from the environment the director
+// passes to create_vm.
+func boshGroup(env cpi.Environment) string {
+	bosh, _ := env["bosh"].(map[string]interface{})
+	group, _ := bosh["group"].(string)
+	return group
+}
+
+// groupLabelValue returns group when it is a valid label value and a hash
+// of it otherwise.
+func groupLabelValue(group string) string {
+	if len(validation.IsValidLabelValue(group)) == 0 {
+		return group
+	}
+
+	sum := sha1.Sum([]byte(group))
+	return "sha1-" + hex.EncodeToString(sum[:])
+}
+
+// podLabels returns the labels of the pods of an agent.
+func podLabels(agentID, group string) map[string]string {
+	labels := map[string]string{
+		"bosh.cloudfoundry.org/agent-id": agentID,
+	}
+	if group != "" {
+		labels[GroupLabel] = groupLabelValue(group)
+	}
+	return labels
+}
+
+// schedulingFieldNames are the pod spec fields that place the pods of a VM.
+var schedulingFieldNames = []string{"affinity", "tolerations", "topologySpreadConstraints"}
+
+// schedulingFields returns the affinity, tolerations and topology spread
+// constraints of the pods of a VM as pod spec fields. The vendored PodSpec
+// has none of these fields, so they are added to the unstructured pod spec
+// with setPodSpecFields.
+func schedulingFields(cloudProps VMCloudProperties, group string) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+
+	affinity, err := podAffinity(cloudProps, group)
+	if err != nil {
+		return nil, err
+	}
+	if affinity != nil {
+		fields["affinity"] = affinity
+	}
+
+	if len(cloudProps.Tolerations) > 0 {
+		fields["tolerations"] = cloudProps.Tolerations
+	}
+
+	constraints, err := topologySpreadConstraints(cloudProps, group)
+	if err != nil {
+		return nil, err
+	}
+	if len(constraints) > 0 {
+		fields["topologySpreadConstraints"] = constraints
+	}
+
+	return fields, nil
+}
+
+// topologySpreadConstraints returns the topology spread constraints of the
+// cloud properties as entries of the pod spec field.
+func topologySpreadConstraints(cloudProps VMCloudProperties, group string) ([]interface{}, error) {
+	var constraints []interface{}
+	for i, constraint := range cloudProps.TopologySpreadConstraints {
+		if constraint.TopologyKey == "" {
+			return nil, bosherr.Errorf("Topology spread constraint %d requires a topology_key", i)
+		}
+
+		maxSkew := constraint.MaxSkew
+		if maxSkew == 0 {
+			maxSkew = 1
+		}
+		if maxSkew < 1 {
+			return nil, bosherr.Errorf("Invalid max_skew %d of topology spread constraint %d: expected at least 1", constraint.MaxSkew, i)
+		}
+
+		whenUnsatisfiable := constraint.WhenUnsatisfiable
+		switch whenUnsatisfiable {
+		case "":
+			whenUnsatisfiable = ScheduleAnyway
+		case DoNotSchedule, ScheduleAnyway:
+		default:
+			return nil, bosherr.Errorf("Invalid when_unsatisfiable %q of topology spread constraint %d", constraint.WhenUnsatisfiable, i)
+		}
+
+		matchLabels := constraint.LabelSelector
+		if len(matchLabels) == 0 {
+			if group == "" {
+				return nil, bosherr.Errorf("Topology spread constraint %d requires a label_selector or the instance group in env.bosh.group", i)
+			}
+			matchLabels = map[string]string{GroupLabel: groupLabelValue(group)}
+		}
+
+		constraints = append(constraints, map[string]interface{}{
+			"maxSkew":           maxSkew,
+			"topologyKey":       constraint.TopologyKey,
+			"whenUnsatisfiable": whenUnsatisfiable,
+			"labelSelector":     &unversioned.LabelSelector{MatchLabels: matchLabels},
+		})
+	}
+	return constraints, nil
+}
+
+// podSchedulingFields returns the scheduling fields of an existing pod so
+// they can be carried over when the pod is recreated.
+func podSchedulingFields(pod *runtime.Unstructured) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, name := range schedulingFieldNames {
+		if value := unstructuredField(pod, "spec", name); value != nil {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// setPodSpecFields sets fields of the pod spec found at path in obj.
+func setPodSpecFields(obj *runtime.Unstructured, fields map[string]interface{}, path ...string) error {
+	spec, ok := unstructuredField(obj, path...).(map[string]interface{})
+	if !ok {
+		return bosherr.Errorf("No pod spec at %s", strings.Join(path, "."))
+	}
+
+	for name, value := range fields {
+		var field interface{}
+		if err := cpi.Remarshal(value, &field); err != nil {
+			return bosherr.WrapErrorf(err, "Converting %s", name)
+		}
+		spec[name] = field
+	}
+
+	return nil
+}
+
+// podAffinity combines the affinity from the cloud properties with the zone
+// and the anti-affinity of the instance group. Nil is returned when there
+// is no affinity.
+func podAffinity(cloudProps VMCloudProperties, group string) (*v1.Affinity, error) {
+	affinity := &v1.Affinity{}
+	if cloudProps.Affinity != nil {
+		*affinity = *cloudProps.Affinity
+	}
+
+	if cloudProps.Zone != "" {
+		addZoneAffinity(affinity, cloudProps.Zone)
+	}
+
+	groupSelector := &unversioned.LabelSelector{
+		MatchLabels: map[string]string{GroupLabel: groupLabelValue(group)},
+	}
+
+	switch cloudProps.AntiAffinity {
+	case "":
+	case AntiAffinityPreferred, AntiAffinityRequired:
+		if group == "" {
+			return nil, bosherr.Error("Anti-affinity requires the instance group in env.bosh.group")
+		}
+
+		term := v1.PodAffinityTerm{LabelSelector: groupSelector, TopologyKey: HostnameTopologyKey}
+		antiAffinity := copyPodAntiAffinity(affinity.PodAntiAffinity)
+		if cloudProps.AntiAffinity == AntiAffinityRequired {
+			antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, term)
+		} else {
+			antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, v1.WeightedPodAffinityTerm{
+				Weight:          spreadWeight,
+				PodAffinityTerm: term,
+			})
+		}
+		affinity.PodAntiAffinity = antiAffinity
+	default:
+		return nil, bosherr.Errorf("Invalid anti_affinity %q: expected %q or %q", cloudProps.AntiAffinity, AntiAffinityPreferred, AntiAffinityRequired)
+	}
+
+	if affinity.NodeAffinity == nil && affinity.PodAffinity == nil && affinity.PodAntiAffinity == nil {
+		return nil, nil
+	}
+
+	return affinity, nil
+}
+
+// copyPodAntiAffinity returns a copy that can be appended to without
+// changing the cloud properties.
+func copyPodAntiAffinity(antiAffinity *v1.PodAntiAffinity) *v1.PodAntiAffinity {
+	copied := &v1.PodAntiAffinity{}
+	if antiAffinity != nil {
+		copied.RequiredDuringSchedulingIgnoredDuringExecution = append([]v1.PodAffinityTerm{}, antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		copied.PreferredDuringSchedulingIgnoredDuringExecution = append([]v1.WeightedPodAffinityTerm{}, antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	return copied
+}
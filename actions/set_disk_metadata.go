@@ -28,6 +28,9 @@ func (v *DiskMetadataSetter) SetDiskMetadata(diskCID cpi.DiskCID, metadata map[s
 	}
 
 	disk, err := client.PersistentVolumeClaims().Get("disk-" + diskID)
+	if isNotFoundStatusError(err) {
+		return cpi.DiskNotFoundError{DiskCID: diskCID}
+	}
 	if err != nil {
 		return bosherr.WrapError(err, "Getting PVC")
 	}
@@ -7,7 +7,6 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
 	"k8s.io/client-go/pkg/api"
-	"k8s.io/client-go/pkg/util/strategicpatch"
 	"k8s.io/client-go/pkg/util/validation"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
@@ -25,37 +24,93 @@ func (v *VMMetadataSetter) SetVMMetadata(vmcid cpi.VMCID, metadata map[string]st
 		return bosherr.WrapError(err, "Creating a client")
 	}
 
+	patch, err := metadataPatch(metadata)
+	if err != nil {
+		return err
+	}
+
 	pod, err := client.Pods().Get("agent-" + agentID)
+	if isNotFoundStatusError(err) {
+		return setReplicatedVMMetadata(client, vmcid, agentID, patch)
+	}
 	if err != nil {
 		return bosherr.WrapError(err, "Getting pod")
 	}
 
-	old, err := json.Marshal(pod)
+	_, err = client.Pods().Patch(pod.Name, api.StrategicMergePatchType, patch)
 	if err != nil {
-		return bosherr.WrapError(err, "Marshalling old pod")
+		return bosherr.WrapError(err, "Patching pod")
 	}
 
-	for k, v := range metadata {
-		k = "bosh.cloudfoundry.org/" + strings.ToLower(k)
-		if len(validation.IsQualifiedName(k)) == 0 && len(validation.IsValidLabelValue(v)) == 0 {
-			pod.ObjectMeta.Labels[k] = v
+	return nil
+}
+
+// setReplicatedVMMetadata labels the deployment or StatefulSet of a VM and
+// its current pods. The pod template is left alone because changing it
+// would restart every replica.
+func setReplicatedVMMetadata(client kubecluster.Client, vmcid cpi.VMCID, agentID string, patch []byte) error {
+	deployment, err := vmDeployment(client, agentID)
+	if err != nil {
+		return err
+	}
+
+	if deployment != nil {
+		_, err = client.Deployments().Patch(deployment.Name, api.StrategicMergePatchType, patch)
+		if err != nil {
+			return bosherr.WrapError(err, "Patching deployment")
+		}
+	} else {
+		statefulSet, err := vmStatefulSet(client, agentID)
+		if err != nil {
+			return err
+		}
+		if statefulSet == nil {
+			return cpi.VMNotFoundError{VMCID: vmcid}
+		}
+
+		_, err = client.StatefulSets().Patch(statefulSet.GetName(), api.StrategicMergePatchType, patch)
+		if err != nil {
+			return bosherr.WrapError(err, "Patching stateful set")
 		}
 	}
 
-	new, err := json.Marshal(pod)
+	listOptions, err := agentListOptions(agentID)
 	if err != nil {
-		return bosherr.WrapError(err, "Marshalling new pod")
+		return err
 	}
 
-	patch, err := strategicpatch.CreateTwoWayMergePatch(old, new, pod)
+	podList, err := client.Pods().List(listOptions)
 	if err != nil {
-		return bosherr.WrapError(err, "Creating TwoWayMergePatch")
+		return bosherr.WrapError(err, "Listing pods")
 	}
 
-	_, err = client.Pods().Patch(pod.Name, api.StrategicMergePatchType, patch)
-	if err != nil {
-		return bosherr.WrapError(err, "Patching pod")
+	for _, pod := range podList.Items {
+		_, err = client.Pods().Patch(pod.Name, api.StrategicMergePatchType, patch)
+		if ignoreNotFound(err) != nil {
+			return bosherr.WrapErrorf(err, "Patching pod %s", pod.Name)
+		}
 	}
 
 	return nil
 }
+
+// metadataPatch returns a patch that adds the metadata as prefixed labels.
+// Metadata that does not make a valid label is omitted.
+func metadataPatch(metadata map[string]string) ([]byte, error) {
+	labels := map[string]string{}
+	for k, v := range metadata {
+		k = "bosh.cloudfoundry.org/" + strings.ToLower(k)
+		if len(validation.IsQualifiedName(k)) == 0 && len(validation.IsValidLabelValue(v)) == 0 {
+			labels[k] = v
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+	})
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling label patch")
+	}
+
+	return patch, nil
+}
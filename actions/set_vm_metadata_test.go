@@ -8,8 +8,10 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
-	"k8s.io/client-go/kubernetes/fake"
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/testing"
 
@@ -27,13 +29,6 @@ var _ = Describe("SetVMMetadata", func() {
 	)
 
 	BeforeEach(func() {
-		fakeClient = fakes.NewClient()
-		fakeClient.ContextReturns("bosh")
-		fakeClient.NamespaceReturns("bosh-namespace")
-
-		fakeProvider = &fakes.ClientProvider{}
-		fakeProvider.NewReturns(fakeClient, nil)
-
 		vmcid = actions.NewVMCID("bosh", "agent-id")
 		metadata = map[string]string{
 			"deployment":       "kube-test-bosh",
@@ -44,7 +39,7 @@ var _ = Describe("SetVMMetadata", func() {
 			"valid-key-name":   "***invalid value***",
 		}
 
-		fakeClient.Clientset = *fake.NewSimpleClientset(
+		fakeClient = fakes.NewClient(
 			&v1.Pod{ObjectMeta: v1.ObjectMeta{
 				Name:      "agent-agent-id",
 				Namespace: "bosh-namespace",
@@ -53,6 +48,11 @@ var _ = Describe("SetVMMetadata", func() {
 				},
 			}},
 		)
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+
+		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewReturns(fakeClient, nil)
 
 		vmMetadataSetter = &actions.VMMetadataSetter{ClientProvider: fakeProvider}
 	})
@@ -97,6 +97,75 @@ var _ = Describe("SetVMMetadata", func() {
 		))
 	})
 
+	Context("when the VM is backed by a deployment", func() {
+		BeforeEach(func() {
+			vmcid = actions.NewVMCID("bosh", "replicated")
+
+			agentLabels := map[string]string{"bosh.cloudfoundry.org/agent-id": "replicated"}
+			fakeClient = fakes.NewClient(
+				&v1beta1.Deployment{ObjectMeta: v1.ObjectMeta{Name: "agent-replicated", Namespace: "bosh-namespace", Labels: agentLabels}},
+				&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "agent-replicated-1234-abcd", Namespace: "bosh-namespace", Labels: agentLabels}},
+				&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "agent-replicated-1234-efgh", Namespace: "bosh-namespace", Labels: agentLabels}},
+			)
+			fakeClient.ContextReturns("bosh")
+			fakeClient.NamespaceReturns("bosh-namespace")
+			fakeProvider.NewReturns(fakeClient, nil)
+		})
+
+		It("labels the deployment and its pods", func() {
+			err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("patch", "deployments")
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].(testing.PatchActionImpl).GetName()).To(Equal("agent-replicated"))
+
+			matches = fakeClient.MatchingActions("patch", "pods")
+			Expect(matches).To(HaveLen(2))
+			Expect(matches[0].(testing.PatchActionImpl).GetPatch()).To(MatchJSON(`{
+				"metadata": {
+					"labels": {
+						"bosh.cloudfoundry.org/deployment": "kube-test-bosh",
+						"bosh.cloudfoundry.org/director": "bosh-init",
+						"bosh.cloudfoundry.org/index": "0",
+						"bosh.cloudfoundry.org/job": "bosh"
+					}
+				}
+			}`))
+		})
+	})
+
+	Context("when the VM is backed by a stateful set", func() {
+		BeforeEach(func() {
+			vmcid = actions.NewVMCID("bosh", "stateful")
+
+			agentLabels := map[string]string{"bosh.cloudfoundry.org/agent-id": "stateful"}
+			fakeClient = fakes.NewClient(
+				&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "web-0", Namespace: "bosh-namespace", Labels: agentLabels}},
+				&v1.Pod{ObjectMeta: v1.ObjectMeta{Name: "web-1", Namespace: "bosh-namespace", Labels: agentLabels}},
+			)
+			fakeClient.ContextReturns("bosh")
+			fakeClient.NamespaceReturns("bosh-namespace")
+			fakeClient.Sets["web"] = newStatefulSet("web", agentLabels)
+			fakeProvider.NewReturns(fakeClient, nil)
+		})
+
+		It("labels the stateful set and its pods", func() {
+			err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("patch", "statefulsets")
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].(testing.PatchActionImpl).GetName()).To(Equal("web"))
+
+			var names []string
+			for _, match := range fakeClient.MatchingActions("patch", "pods") {
+				names = append(names, match.(testing.PatchActionImpl).GetName())
+			}
+			Expect(names).To(ConsistOf("web-0", "web-1"))
+		})
+	})
+
 	Context("when getting the client fails", func() {
 		BeforeEach(func() {
 			fakeProvider.NewReturns(nil, errors.New("boom"))
@@ -122,6 +191,19 @@ var _ = Describe("SetVMMetadata", func() {
 		})
 	})
 
+	Context("when the pod does not exist", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("get", "pods", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, kubeerrors.NewNotFound(unversioned.GroupResource{Resource: "pods"}, "agent-agent-id")
+			})
+		})
+
+		It("returns a VM not found error", func() {
+			err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+			Expect(err).To(MatchError(cpi.VMNotFoundError{VMCID: vmcid}))
+		})
+	})
+
 	Context("when patching the pod fails", func() {
 		BeforeEach(func() {
 			fakeClient.PrependReactor("patch", "pods", func(action testing.Action) (bool, runtime.Object, error) {
@@ -0,0 +1,191 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/validation"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// SnapshotClassAnnotation records the VolumeSnapshotClass of the snapshots
+// of a disk on its PVC.
+const SnapshotClassAnnotation = "bosh.cloudfoundry.org/snapshot-class"
+
+// SnapshotCreator takes CSI VolumeSnapshots of the PVCs of disks.
+type SnapshotCreator struct {
+	ClientProvider       kubecluster.ClientProvider
+	CPIConfig            *config.CPI
+	Clock                clock.Clock
+	SnapshotReadyTimeout time.Duration
+	GUIDGeneratorFunc    func() (string, error)
+}
+
+// SnapshotDisk creates a VolumeSnapshot of the disk and waits until it can
+// be used. The snapshot class is the snapshot_class of the disk cloud
+// properties, or of the disk defaults for disks created without one. The
+// metadata values may be numbers, like the index of the instance.
+func (s *SnapshotCreator) SnapshotDisk(diskCID cpi.DiskCID, metadata map[string]interface{}) (cpi.SnapshotCID, error) {
+	context, diskID := ParseDiskCID(diskCID)
+
+	snapshotID, err := s.GUIDGeneratorFunc()
+	if err != nil {
+		return "", bosherr.WrapError(err, "Creating snapshot")
+	}
+
+	labels, annotations, err := snapshotMetadata(metadata)
+	if err != nil {
+		return "", err
+	}
+	labels["bosh.cloudfoundry.org/disk-id"] = diskID
+	labels["bosh.cloudfoundry.org/snapshot-id"] = snapshotID
+
+	client, err := s.ClientProvider.New(context)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Creating client")
+	}
+
+	pvc, err := client.PersistentVolumeClaims().Get("disk-" + diskID)
+	if isNotFoundStatusError(err) {
+		return "", cpi.DiskNotFoundError{DiskCID: diskCID}
+	}
+	if err != nil {
+		return "", bosherr.WrapError(err, "Getting PVC")
+	}
+
+	snapshotClass, err := s.snapshotClass(context, pvc)
+	if err != nil {
+		return "", err
+	}
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": pvc.Name,
+		},
+	}
+	if snapshotClass != "" {
+		spec["volumeSnapshotClassName"] = snapshotClass
+	}
+
+	snapshot := &runtime.Unstructured{Object: map[string]interface{}{
+		"apiVersion": kubecluster.SnapshotGroupVersion.String(),
+		"kind":       "VolumeSnapshot",
+		"spec":       spec,
+	}}
+	snapshot.SetName("snapshot-" + snapshotID)
+	snapshot.SetNamespace(client.Namespace())
+	snapshot.SetLabels(labels)
+	if len(annotations) > 0 {
+		snapshot.SetAnnotations(annotations)
+	}
+
+	_, err = client.VolumeSnapshots().Create(snapshot)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Creating VolumeSnapshot")
+	}
+
+	if err := s.waitForSnapshot(client.VolumeSnapshots(), snapshot.GetName()); err != nil {
+		err = snapshotDiagnostics(client, snapshot.GetName(), err)
+
+		// The director never learns the CID of a failed snapshot, so it is
+		// removed on a best effort basis.
+		client.VolumeSnapshots().Delete(snapshot.GetName(), &v1.DeleteOptions{})
+
+		return "", bosherr.WrapError(err, "Waiting for snapshot")
+	}
+
+	return NewSnapshotCID(client.Context(), snapshotID), nil
+}
+
+func (s *SnapshotCreator) snapshotClass(context string, pvc *v1.PersistentVolumeClaim) (string, error) {
+	if snapshotClass := pvc.Annotations[SnapshotClassAnnotation]; snapshotClass != "" {
+		return snapshotClass, nil
+	}
+
+	props, err := s.CPIConfig.ApplyDiskDefaults(config.CloudProperties{}, context)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Applying disk defaults")
+	}
+
+	snapshotClass, ok := props["snapshot_class"].(string)
+	if !ok && props["snapshot_class"] != nil {
+		return "", bosherr.Errorf("Invalid snapshot_class %v: expected string", props["snapshot_class"])
+	}
+
+	return snapshotClass, nil
+}
+
+// waitForSnapshot polls the snapshot until it is ready to use. A snapshot
+// whose status reports an error will not become ready, so it fails at once.
+func (s *SnapshotCreator) waitForSnapshot(snapshots kubecluster.UnstructuredInterface, name string) error {
+	return poll(s.Clock, s.SnapshotReadyTimeout, "Snapshot creation failed with a timeout", func() (bool, error) {
+		snapshot, err := snapshots.Get(name)
+		if err != nil && !cpi.IsRetryable(err) {
+			return false, bosherr.WrapError(err, "Getting VolumeSnapshot")
+		}
+		if err != nil {
+			return false, nil
+		}
+		if failure := snapshotFailure(snapshot); failure != nil {
+			return false, failure
+		}
+		return isSnapshotReady(snapshot), nil
+	})
+}
+
+// snapshotFailure returns the error the snapshot controller recorded in the
+// status of the snapshot.
+func snapshotFailure(snapshot *runtime.Unstructured) error {
+	status, ok := unstructuredField(snapshot, "status", "error").(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	message, _ := status["message"].(string)
+	if message == "" {
+		message = "unknown error"
+	}
+	return bosherr.Errorf("VolumeSnapshot %s failed: %s", snapshot.GetName(), message)
+}
+
+func isSnapshotReady(snapshot *runtime.Unstructured) bool {
+	ready, _ := unstructuredField(snapshot, "status", "readyToUse").(bool)
+	return ready
+}
+
+// snapshotMetadata turns the BOSH metadata into labels. Values that are not
+// valid label values, like a director name with spaces, become annotations.
+func snapshotMetadata(metadata map[string]interface{}) (map[string]string, map[string]string, error) {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+
+	for k, value := range metadata {
+		v := ""
+		if value != nil {
+			v = fmt.Sprint(value)
+		}
+
+		k = "bosh.cloudfoundry.org/" + k
+		errs := validation.IsQualifiedName(k)
+		if len(errs) > 0 {
+			return nil, nil, bosherr.Errorf("Error setting snapshot metadata: \"%s\": \"%s\": %s", k, v, strings.Join(errs, ": "))
+		}
+
+		if len(validation.IsValidLabelValue(v)) > 0 {
+			annotations[k] = v
+		} else {
+			labels[k] = v
+		}
+	}
+
+	return labels, annotations, nil
+}
@@ -0,0 +1,292 @@
+package actions_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/testing"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/actions"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+var _ = Describe("SnapshotDisk", func() {
+	var (
+		fakeClient   *fakes.Client
+		fakeProvider *fakes.ClientProvider
+		fakeClock    *fakeclock.FakeClock
+		diskCID      cpi.DiskCID
+		metadata     map[string]interface{}
+
+		snapshotCreator *actions.SnapshotCreator
+	)
+
+	readySnapshot := func(action testing.Action) (bool, runtime.Object, error) {
+		snapshot, ok := fakeClient.Snapshots[action.(testing.GetAction).GetName()]
+		if !ok {
+			return false, nil, nil
+		}
+		snapshot.Object["status"] = map[string]interface{}{"readyToUse": true}
+		return true, snapshot, nil
+	}
+
+	createdSnapshot := func() *runtime.Unstructured {
+		matches := fakeClient.MatchingActions("create", "volumesnapshots")
+		Expect(matches).To(HaveLen(1))
+		return matches[0].(testing.CreateAction).GetObject().(*runtime.Unstructured)
+	}
+
+	BeforeEach(func() {
+		diskCID = actions.NewDiskCID("bosh", "disk-id")
+		metadata = map[string]interface{}{
+			"deployment": "redis",
+			"job":        "redis-server",
+			"index":      float64(2),
+		}
+
+		fakeClient = fakes.NewClient(&v1.PersistentVolumeClaim{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      "disk-disk-id",
+				Namespace: "bosh-namespace",
+				Labels:    map[string]string{"bosh.cloudfoundry.org/disk-id": "disk-id"},
+				Annotations: map[string]string{
+					actions.SnapshotClassAnnotation: "csi-snapclass",
+				},
+			},
+		})
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+		fakeClient.PrependReactor("get", "volumesnapshots", readySnapshot)
+
+		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewReturns(fakeClient, nil)
+
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		snapshotCreator = &actions.SnapshotCreator{
+			ClientProvider:       fakeProvider,
+			CPIConfig:            &config.CPI{},
+			Clock:                fakeClock,
+			SnapshotReadyTimeout: 4 * actions.PollInterval,
+			GUIDGeneratorFunc:    func() (string, error) { return "snapshot-guid", nil },
+		}
+	})
+
+	It("gets a client for the context of the disk", func() {
+		_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeProvider.NewCallCount()).To(Equal(1))
+		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+	})
+
+	It("creates a VolumeSnapshot of the claim of the disk", func() {
+		snapshotCID, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snapshotCID).To(Equal(cpi.SnapshotCID("bosh:snapshot-guid")))
+
+		Expect(createdSnapshot().Object).To(Equal(map[string]interface{}{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      "snapshot-snapshot-guid",
+				"namespace": "bosh-namespace",
+				"labels": map[string]interface{}{
+					"bosh.cloudfoundry.org/disk-id":     "disk-id",
+					"bosh.cloudfoundry.org/snapshot-id": "snapshot-guid",
+					"bosh.cloudfoundry.org/deployment":  "redis",
+					"bosh.cloudfoundry.org/job":         "redis-server",
+					"bosh.cloudfoundry.org/index":       "2",
+				},
+			},
+			"spec": map[string]interface{}{
+				"source": map[string]interface{}{
+					"persistentVolumeClaimName": "disk-disk-id",
+				},
+				"volumeSnapshotClassName": "csi-snapclass",
+			},
+		}))
+	})
+
+	Context("when a metadata value is not a valid label value", func() {
+		BeforeEach(func() {
+			metadata["director_name"] = "my director"
+		})
+
+		It("annotates the snapshot with it", func() {
+			_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			snapshot := createdSnapshot()
+			Expect(snapshot.GetLabels()).NotTo(HaveKey("bosh.cloudfoundry.org/director_name"))
+			Expect(snapshot.GetAnnotations()).To(Equal(map[string]string{
+				"bosh.cloudfoundry.org/director_name": "my director",
+			}))
+		})
+	})
+
+	Context("when a metadata key is not a valid label key", func() {
+		BeforeEach(func() {
+			metadata["not valid"] = "value"
+		})
+
+		It("returns an error", func() {
+			_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+			Expect(err).To(MatchError(ContainSubstring("Error setting snapshot metadata:")))
+			Expect(fakeClient.MatchingActions("create", "volumesnapshots")).To(BeEmpty())
+		})
+	})
+
+	Context("when the claim has no snapshot class", func() {
+		BeforeEach(func() {
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-disk-id")
+			Expect(err).NotTo(HaveOccurred())
+			pvc.Annotations = nil
+			_, err = fakeClient.PersistentVolumeClaims().Update(pvc)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("uses the default snapshot class of the cluster", func() {
+			_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(createdSnapshot().Object["spec"]).NotTo(HaveKey("volumeSnapshotClassName"))
+		})
+
+		Context("when the disk defaults name a snapshot class", func() {
+			BeforeEach(func() {
+				snapshotCreator.CPIConfig = &config.CPI{
+					DiskDefaults: config.CloudProperties{"snapshot_class": "default-snapclass"},
+					Contexts: map[string]*config.ContextCPI{
+						"bosh": {DiskDefaults: config.CloudProperties{"snapshot_class": "bosh-snapclass"}},
+					},
+				}
+			})
+
+			It("uses the snapshot class of the context of the disk", func() {
+				_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(createdSnapshot().Object["spec"]).To(HaveKeyWithValue("volumeSnapshotClassName", "bosh-snapclass"))
+			})
+		})
+	})
+
+	Context("when the claim does not exist", func() {
+		BeforeEach(func() {
+			diskCID = actions.NewDiskCID("bosh", "missing-disk")
+		})
+
+		It("returns a disk not found error", func() {
+			_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+			Expect(err).To(MatchError(cpi.DiskNotFoundError{DiskCID: diskCID}))
+		})
+	})
+
+	Context("when creating the snapshot fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("create", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("boom")
+			})
+		})
+
+		It("returns an error", func() {
+			_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+			Expect(err).To(MatchError(bosherr.WrapError(errors.New("boom"), "Creating VolumeSnapshot")))
+		})
+	})
+
+	Describe("waiting for the snapshot", func() {
+		var readyAfter int
+
+		BeforeEach(func() {
+			readyAfter = 1
+			fakeClient.PrependReactor("get", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+				if readyAfter > 0 {
+					readyAfter--
+					snapshot := fakeClient.Snapshots[action.(testing.GetAction).GetName()]
+					snapshot.Object["status"] = map[string]interface{}{"readyToUse": false}
+					return true, snapshot, nil
+				}
+				return false, nil, nil
+			})
+		})
+
+		snapshotDisk := func() <-chan error {
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := snapshotCreator.SnapshotDisk(diskCID, metadata)
+				errCh <- err
+			}()
+			return errCh
+		}
+
+		It("polls the snapshot until it is ready to use", func() {
+			errCh := snapshotDisk()
+
+			Eventually(fakeClock.WatcherCount).Should(Equal(2))
+			Consistently(errCh).ShouldNot(Receive())
+			fakeClock.Increment(actions.PollInterval)
+
+			Eventually(errCh).Should(Receive(BeNil()))
+			Expect(fakeClient.MatchingActions("get", "volumesnapshots")).To(HaveLen(2))
+		})
+
+		Context("when the snapshot is not ready before the timeout", func() {
+			BeforeEach(func() {
+				readyAfter = 100
+			})
+
+			It("returns the state of the snapshot and deletes it", func() {
+				errCh := snapshotDisk()
+
+				for i := 0; i < 4; i++ {
+					Eventually(fakeClock.WatcherCount).Should(Equal(2))
+					fakeClock.Increment(actions.PollInterval)
+				}
+
+				var err error
+				Eventually(errCh).Should(Receive(&err))
+				Expect(err).To(MatchError(ContainSubstring("Waiting for snapshot: Snapshot creation failed with a timeout")))
+
+				diagnostics := cpi.DiagnosticLog(err)
+				Expect(diagnostics).To(HavePrefix("VolumeSnapshot snapshot-snapshot-guid: ready false"))
+
+				matches := fakeClient.MatchingActions("delete", "volumesnapshots")
+				Expect(matches).To(HaveLen(1))
+				Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("snapshot-snapshot-guid"))
+				Expect(fakeClient.Snapshots).To(BeEmpty())
+			})
+		})
+
+		Context("when the snapshot reports an error", func() {
+			BeforeEach(func() {
+				fakeClient.PrependReactor("get", "volumesnapshots", func(action testing.Action) (bool, runtime.Object, error) {
+					snapshot := fakeClient.Snapshots[action.(testing.GetAction).GetName()]
+					snapshot.Object["status"] = map[string]interface{}{
+						"readyToUse": false,
+						"error":      map[string]interface{}{"message": "source volume is not bound"},
+					}
+					return true, snapshot, nil
+				})
+			})
+
+			It("returns the error without waiting for the timeout and deletes the snapshot", func() {
+				var err error
+				Eventually(snapshotDisk()).Should(Receive(&err))
+				Expect(err).To(MatchError(ContainSubstring("Waiting for snapshot: VolumeSnapshot snapshot-snapshot-guid failed: source volume is not bound")))
+				Expect(fakeClient.Snapshots).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,252 @@
+package actions
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	apps "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/util/validation"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+)
+
+const (
+	WorkloadDeployment  = "deployment"
+	WorkloadStatefulSet = "statefulset"
+
+	// PersistentVolumeName is the volume claim template of the per-replica
+	// persistent disks of a StatefulSet.
+	PersistentVolumeName = "bosh-persistent"
+
+	// PersistentDiskPath is where the per-replica persistent disk is
+	// mounted in the bosh-job container.
+	PersistentDiskPath = "/var/vcap/store"
+
+	// maxStatefulSetNameLength leaves room in the names of the pods and
+	// controller revisions, which append an ordinal or a hash.
+	maxStatefulSetNameLength = 52
+)
+
+// createStatefulSet creates a StatefulSet with stable pod names, the
+// headless service that governs their network identity and, when the size
+// of the persistent disk is known, a persistent disk for every replica.
+// The StatefulSet is named after the instance group, so a recreated VM
+// gets back the claims of the replicas before it. The StatefulSet is
+// created as an unstructured apps/v1 object; the typed beta object only
+// provides the fields both versions share.
+func (v *VMCreator) createStatefulSet(client kubecluster.Client,
+	ns, agentID, image, group string,
+	networks *vmNetworks,
+	cloudProps VMCloudProperties,
+	diskCIDs []cpi.DiskCID,
+) (*runtime.Unstructured, error) {
+	if group == "" {
+		return nil, bosherr.Error("A stateful set requires the instance group in env.bosh.group")
+	}
+	name := statefulSetName(group)
+
+	replicas := int32(1)
+	if cloudProps.Replicas != nil {
+		replicas = *cloudProps.Replicas
+	}
+
+	podSpec, err := newPodSpec(agentID, image, v.AgentConfig.MessageBus, cloudProps)
+	if err != nil {
+		return nil, err
+	}
+	// The StatefulSet controller names every replica after its ordinal.
+	podSpec.Hostname = ""
+
+	scheduling, err := schedulingFields(cloudProps, group)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting scheduling fields")
+	}
+
+	annotations, err := podAnnotations(networks, cloudProps)
+	if err != nil {
+		return nil, err
+	}
+
+	claimTemplates, err := persistentClaimTemplates(client, group, cloudProps, diskCIDs)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting persistent disk size")
+	}
+	if len(claimTemplates) > 0 {
+		for i := range podSpec.Containers {
+			if podSpec.Containers[i].Name == "bosh-job" {
+				podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, v1.VolumeMount{
+					Name:      PersistentVolumeName,
+					MountPath: PersistentDiskPath,
+				})
+			}
+		}
+	}
+
+	if err := createHeadlessService(client.Services(), ns, agentID); err != nil {
+		return nil, bosherr.WrapError(err, "Creating headless service")
+	}
+
+	statefulSet, err := toUnstructured(&apps.StatefulSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    podLabels(agentID, group),
+		},
+		Spec: apps.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &unversioned.LabelSelector{
+				MatchLabels: map[string]string{"bosh.cloudfoundry.org/agent-id": agentID},
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: annotations,
+					Labels:      podLabels(agentID, group),
+				},
+				Spec: podSpec,
+			},
+			ServiceName: "agent-" + agentID,
+		},
+	}, kubecluster.AppsGroupVersion.String(), "StatefulSet")
+	if err != nil {
+		return nil, err
+	}
+	if err := setPodSpecFields(statefulSet, scheduling, "spec", "template", "spec"); err != nil {
+		return nil, err
+	}
+	if len(claimTemplates) > 0 {
+		statefulSet.Object["spec"].(map[string]interface{})["volumeClaimTemplates"] = claimTemplates
+	}
+
+	created, err := client.StatefulSets().Create(statefulSet)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating stateful set")
+	}
+
+	if err = v.waitForStatefulSet(client.Pods(), agentID, name, replicas); err != nil {
+		return nil, bosherr.WrapError(agentDiagnostics(client, agentID, err), "Waiting for stateful set")
+	}
+
+	return created, nil
+}
+
+// statefulSetName returns the name of the StatefulSet of an instance group.
+// Groups that are not short DNS labels are hashed.
+func statefulSetName(group string) string {
+	if len(group) <= maxStatefulSetNameLength && len(validation.IsDNS1123Label(group)) == 0 {
+		return group
+	}
+
+	sum := sha1.Sum([]byte(group))
+	return "sha1-" + hex.EncodeToString(sum[:])
+}
+
+// createHeadlessService creates the service that gives every replica of a
+// StatefulSet a stable DNS name.
+func createHeadlessService(serviceClient core.ServiceInterface, ns, agentID string) error {
+	_, err := serviceClient.Create(&v1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "agent-" + agentID,
+			Namespace: ns,
+			Labels: map[string]string{
+				"bosh.cloudfoundry.org/agent-id": agentID,
+			},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Selector: map[string]string{
+				"bosh.cloudfoundry.org/agent-id": agentID,
+			},
+		},
+	})
+	return err
+}
+
+// persistentClaimTemplates returns the claim template of the per-replica
+// persistent disks. The size is persistent_disk_size from the cloud
+// properties or the size of the first BOSH persistent disk of the VM. No
+// template is returned when neither is known. The claims only carry the
+// instance group label: they outlive the agent and delete_vm keeps them.
+// The templates are unstructured because the typed claim has no
+// spec.storageClassName.
+func persistentClaimTemplates(client kubecluster.Client, group string, cloudProps VMCloudProperties, diskCIDs []cpi.DiskCID) ([]interface{}, error) {
+	var size resource.Quantity
+	switch {
+	case cloudProps.PersistentDiskSize > 0:
+		quantity, err := resource.ParseQuantity(fmt.Sprintf("%dMi", cloudProps.PersistentDiskSize))
+		if err != nil {
+			return nil, bosherr.WrapError(err, "Parsing quantity")
+		}
+		size = quantity
+	case len(diskCIDs) > 0:
+		_, diskID := ParseDiskCID(diskCIDs[0])
+		pvc, err := client.PersistentVolumeClaims().Get("disk-" + diskID)
+		if err != nil {
+			return nil, bosherr.WrapErrorf(err, "Getting PVC of disk %s", diskCIDs[0])
+		}
+		size = pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	default:
+		return nil, nil
+	}
+
+	claimTemplate, err := toUnstructured(&v1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   PersistentVolumeName,
+			Labels: map[string]string{GroupLabel: groupLabelValue(group)},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}, v1.SchemeGroupVersion.String(), "PersistentVolumeClaim")
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty storage class would turn off the default class.
+	if cloudProps.StorageClass != "" {
+		claimTemplate.Object["spec"].(map[string]interface{})["storageClassName"] = cloudProps.StorageClass
+	}
+
+	return []interface{}{claimTemplate.Object}, nil
+}
+
+// waitForStatefulSet waits until the agent runs in every replica. The
+// StatefulSet controller starts a replica only after the one before it is
+// ready, so the replicas become ready in order.
+func (v *VMCreator) waitForStatefulSet(podService core.PodInterface, agentID, name string, replicas int32) error {
+	listOptions, err := agentListOptions(agentID)
+	if err != nil {
+		return err
+	}
+
+	w := newPodWaiter(v.Clock, v.DeploymentReadyTimeout, podService, listOptions)
+	w.TimeoutMessage = "Stateful set creation failed with a timeout"
+
+	return w.Wait(func(objects []runtime.Object) (bool, error) {
+		ready := map[string]bool{}
+		for _, obj := range objects {
+			pod := obj.(*v1.Pod)
+			ready[pod.Name] = isAgentContainerRunning(pod)
+		}
+		return allReplicasReady(ready, name, replicas), nil
+	})
+}
+
+func allReplicasReady(ready map[string]bool, name string, replicas int32) bool {
+	for i := int32(0); i < replicas; i++ {
+		if !ready[fmt.Sprintf("%s-%d", name, i)] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,10 +2,17 @@ package actions
 
 import "github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 
+// StemcellCloudProperties are the cloud_properties of the stemcell manifest.
+// The stemcell build writes name, version, infrastructure and other fields
+// next to image; only the image is used.
 type StemcellCloudProperties struct {
 	Image string `json:"image"`
 }
 
+// AcceptsUnknownFields allows the fields of the stemcell manifest that the
+// CPI does not use.
+func (s StemcellCloudProperties) AcceptsUnknownFields() bool { return true }
+
 func CreateStemcell(image string, cloudProps StemcellCloudProperties) (cpi.StemcellCID, error) {
 	return cpi.StemcellCID(cloudProps.Image), nil
 }
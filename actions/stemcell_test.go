@@ -23,6 +23,31 @@ var _ = Describe("Stemcell", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(stemcellCID).To(Equal(cpi.StemcellCID("cloudfoundry/kubernetes-stemcell:999")))
 		})
+
+		It("accepts the cloud properties of a stemcell manifest", func() {
+			req := &cpi.Request{
+				Method: "create_stemcell",
+				Args: []interface{}{
+					"/var/vcap/data/tmp/director/stemcell/image",
+					map[string]interface{}{
+						"name":             "bosh-kubernetes-ubuntu-jammy-go_agent",
+						"version":          "1.404",
+						"infrastructure":   "kubernetes",
+						"hypervisor":       "none",
+						"architecture":     "x86_64",
+						"os_type":          "linux",
+						"os_distro":        "ubuntu",
+						"root_device_name": "/dev/sda1",
+						"image":            "cloudfoundry/kubernetes-stemcell:999",
+					},
+				},
+			}
+
+			resp, err := actions.NewRegistry().Dispatch(req, cpi.Dependencies{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Error).To(BeNil())
+			Expect(resp.Result).To(Equal(cpi.StemcellCID("cloudfoundry/kubernetes-stemcell:999")))
+		})
 	})
 
 	Describe("DeleteStemcell", func() {
@@ -0,0 +1,56 @@
+package actions
+
+import (
+	"encoding/json"
+
+	"k8s.io/client-go/pkg/runtime"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// toUnstructured converts a typed object so fields its type lacks can be
+// added. The apiVersion and kind are not taken from the object because
+// typed objects usually leave them empty.
+func toUnstructured(obj runtime.Object, apiVersion, kind string) (*runtime.Unstructured, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Marshalling object")
+	}
+
+	u := &runtime.Unstructured{}
+	if err := json.Unmarshal(data, &u.Object); err != nil {
+		return nil, bosherr.WrapError(err, "Unmarshalling object")
+	}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+
+	return u, nil
+}
+
+// fromUnstructured converts an unstructured object into obj. Fields the
+// type of obj lacks are dropped.
+func fromUnstructured(u *runtime.Unstructured, obj runtime.Object) error {
+	data, err := json.Marshal(u.Object)
+	if err != nil {
+		return bosherr.WrapError(err, "Marshalling object")
+	}
+
+	if err := json.Unmarshal(data, obj); err != nil {
+		return bosherr.WrapError(err, "Unmarshalling object")
+	}
+
+	return nil
+}
+
+// unstructuredField returns the value at the path of fields or nil.
+func unstructuredField(obj *runtime.Unstructured, fields ...string) interface{} {
+	var value interface{} = obj.Object
+	for _, field := range fields {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[field]
+	}
+	return value
+}
@@ -25,6 +25,15 @@ func ParseDiskCID(diskCID cpi.DiskCID) (context, diskID string) {
 	return parts[0], parts[1]
 }
 
+func NewSnapshotCID(context, snapshotID string) cpi.SnapshotCID {
+	return cpi.SnapshotCID(context + ":" + snapshotID)
+}
+
+func ParseSnapshotCID(snapshotCID cpi.SnapshotCID) (context, snapshotID string) {
+	parts := strings.SplitN(string(snapshotCID), ":", 2)
+	return parts[0], parts[1]
+}
+
 func CreateGUID() (string, error) {
 	guid, err := uuid.NewV4()
 	if err != nil {
@@ -0,0 +1,156 @@
+package actions
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	extensions "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+	v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/fields"
+	"k8s.io/client-go/pkg/runtime"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+)
+
+// vmDeployment returns the deployment of a VM that was created with
+// replicas or nil when the VM is not backed by a deployment.
+func vmDeployment(client kubecluster.Client, agentID string) (*v1beta1.Deployment, error) {
+	deployment, err := client.Deployments().Get("agent-" + agentID)
+	if isNotFoundStatusError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Getting deployment")
+	}
+	return deployment, nil
+}
+
+// vmStatefulSet returns the StatefulSet of a VM that was created with the
+// statefulset workload or nil when the VM is not backed by one. The
+// StatefulSet is named after the instance group, so it is found by its
+// agent label. Its pods are named after their ordinal, so there is no pod
+// named after the agent.
+func vmStatefulSet(client kubecluster.Client, agentID string) (*runtime.Unstructured, error) {
+	listOptions, err := agentListOptions(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := client.StatefulSets().List(&listOptions)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Listing stateful sets")
+	}
+
+	statefulSetList, ok := list.(*runtime.UnstructuredList)
+	if !ok {
+		return nil, bosherr.Errorf("Listing stateful sets: unexpected list %T", list)
+	}
+	if len(statefulSetList.Items) == 0 {
+		return nil, nil
+	}
+	return statefulSetList.Items[0], nil
+}
+
+// updateDeployment attaches or detaches a disk by changing the pod template
+// of the deployment and waits for the rolling update to replace every pod.
+func (v *VolumeManager) updateDeployment(client kubecluster.Client, op Operation, agentID, diskID string, deployment *v1beta1.Deployment) (string, error) {
+	if op == Add && deployment.Spec.Replicas != nil && *deployment.Spec.Replicas > 1 {
+		if err := checkSharedAccess(client, agentID, diskID); err != nil {
+			return "", err
+		}
+	}
+
+	diskHint, _, err := updateConfigMapDisks(client, op, agentID, diskID, false)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Updating disk configMap")
+	}
+
+	updateVolumes(op, &deployment.Spec.Template.Spec, diskID, false)
+	ensureReadinessProbe(&deployment.Spec.Template.Spec, v.messageBus())
+
+	// A merge patch of the volumes and containers leaves the scheduling
+	// fields of the pod template alone; an update of the typed deployment
+	// would drop them.
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"volumes":    deployment.Spec.Template.Spec.Volumes,
+					"containers": deployment.Spec.Template.Spec.Containers,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", bosherr.WrapError(err, "Marshalling deployment patch")
+	}
+
+	updated, err := client.Deployments().Patch(deployment.Name, api.MergePatchType, patch)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Updating deployment")
+	}
+
+	err = waitForRollout(v.Clock, v.DeploymentReadyTimeout, client.Deployments(), updated)
+	if err != nil {
+		return "", bosherr.WrapError(agentDiagnostics(client, agentID, err), "Waiting for deployment rollout")
+	}
+
+	return diskHint, nil
+}
+
+// checkSharedAccess returns a not supported error unless the claim of the
+// disk can be mounted by the pods of several nodes. Every replica of the
+// deployment mounts the same claim, so a missing claim is reported before
+// the rollout would leave them pending.
+func checkSharedAccess(client kubecluster.Client, agentID, diskID string) error {
+	claim, err := client.PersistentVolumeClaims().Get("disk-" + diskID)
+	if isNotFoundStatusError(err) {
+		return cpi.DiskNotFoundError{DiskCID: NewDiskCID(client.Context(), diskID)}
+	}
+	if err != nil {
+		return bosherr.WrapError(err, "Getting PVC")
+	}
+
+	for _, mode := range claim.Spec.AccessModes {
+		if mode == v1.ReadWriteMany || mode == v1.ReadOnlyMany {
+			return nil
+		}
+	}
+
+	return notSupported(bosherr.Errorf("Disk %s cannot be shared by the replicas of VM %s: access modes %v", NewDiskCID(client.Context(), diskID), NewVMCID(client.Context(), agentID), claim.Spec.AccessModes))
+}
+
+// waitForRollout waits until the deployment controller has observed the
+// update and every replica runs the updated pod template.
+func waitForRollout(clk clock.Clock, timeout time.Duration, deploymentService extensions.DeploymentInterface, deployment *v1beta1.Deployment) error {
+	w := newDeploymentWaiter(clk, timeout, deploymentService, v1.ListOptions{
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", deployment.Name).String(),
+		ResourceVersion: deployment.ResourceVersion,
+	})
+	w.TimeoutMessage = "Deployment rollout failed with a timeout"
+
+	return w.Wait(func(objects []runtime.Object) (bool, error) {
+		for _, obj := range objects {
+			d := obj.(*v1beta1.Deployment)
+			if d.Name == deployment.Name && isRolloutComplete(d) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// isRolloutComplete also requires the pods of the old template to be gone
+// so that they no longer hold a detached disk.
+func isRolloutComplete(deployment *v1beta1.Deployment) bool {
+	replicas := *deployment.Spec.Replicas
+	return isDeploymentReady(deployment) &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.Replicas == replicas
+}
@@ -2,28 +2,31 @@ package actions
 
 import (
 	"encoding/json"
-	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/clock"
 
 	"github.ibm.com/Bluemix/kubernetes-cpi/agent"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
 	"k8s.io/client-go/pkg/api/v1"
-	"k8s.io/client-go/pkg/labels"
-	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/pkg/runtime"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
 type VolumeManager struct {
+	AgentConfig    *config.Agent
 	ClientProvider kubecluster.ClientProvider
+	CPIConfig      *config.CPI
 
-	Clock             clock.Clock
-	PodReadyTimeout   time.Duration
-	PostRecreateDelay time.Duration
+	Clock                  clock.Clock
+	DeploymentReadyTimeout time.Duration
+	PodReadyTimeout        time.Duration
 }
 
 type Operation int
@@ -34,23 +37,34 @@ const (
 )
 
 func (v *VolumeManager) AttachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
+	_, err := v.attachDisk(vmcid, diskCID)
+	return err
+}
+
+// AttachDiskV2 implements the version 2 contract of attach_disk. The result
+// is the disk hint the director passes on to the agent.
+func (v *VolumeManager) AttachDiskV2(vmcid cpi.VMCID, diskCID cpi.DiskCID) (interface{}, error) {
+	return v.attachDisk(vmcid, diskCID)
+}
+
+func (v *VolumeManager) attachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) (string, error) {
 	vmContext, agentID := ParseVMCID(vmcid)
 	context, diskID := ParseDiskCID(diskCID)
 	if context != vmContext {
-		return bosherr.Errorf("Kubernetes disk and resource pool contexts must be the same: disk: %q, resource pool: %q", context, vmContext)
+		return "", bosherr.Errorf("Kubernetes disk and resource pool contexts must be the same: disk: %q, resource pool: %q", context, vmContext)
 	}
 
 	client, err := v.ClientProvider.New(context)
 	if err != nil {
-		return bosherr.WrapError(err, "Creating client")
+		return "", bosherr.WrapError(err, "Creating client")
 	}
 
-	err = v.recreatePod(client, Add, agentID, diskID)
+	diskHint, err := v.recreatePod(client, Add, agentID, diskID)
 	if err != nil {
-		return bosherr.WrapError(err, "Recreating pod to attach disk")
+		return "", bosherr.WrapError(err, "Recreating pod to attach disk")
 	}
 
-	return nil
+	return diskHint, nil
 }
 
 func (v *VolumeManager) DetachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
@@ -65,7 +79,7 @@ func (v *VolumeManager) DetachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
 		return bosherr.WrapError(err, "Creating client")
 	}
 
-	err = v.recreatePod(client, Remove, agentID, diskID)
+	_, err = v.recreatePod(client, Remove, agentID, diskID)
 	if err != nil {
 		return bosherr.WrapError(err, "Recreating pod to detach disk")
 	}
@@ -73,26 +87,61 @@ func (v *VolumeManager) DetachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
 	return nil
 }
 
-func (v *VolumeManager) recreatePod(client kubecluster.Client, op Operation, agentID string, diskID string) error {
+func (v *VolumeManager) recreatePod(client kubecluster.Client, op Operation, agentID string, diskID string) (string, error) {
+	pinner, err := contextIPPinner(v.CPIConfig, client.Context())
+	if err != nil {
+		return "", err
+	}
+
+	block := false
+	if op == Add {
+		block, err = isBlockDisk(client, diskID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The pod is read unstructured so the recreated pod keeps the
+	// scheduling fields its type lacks.
 	podService := client.Pods()
-	pod, err := podService.Get("agent-" + agentID)
+	obj, err := client.UnstructuredPods().Get("agent-" + agentID)
+	if isNotFoundStatusError(err) {
+		return v.updateVMDeployment(client, op, agentID, diskID, block)
+	}
 	if err != nil {
-		return bosherr.WrapError(err, "Getting pod")
+		return "", bosherr.WrapError(err, "Getting pod")
+	}
+
+	pod := &v1.Pod{}
+	if err := fromUnstructured(obj, pod); err != nil {
+		return "", bosherr.WrapError(err, "Getting pod")
 	}
 
-	err = updateConfigMapDisks(client, op, agentID, diskID)
+	diskHint, devices, err := updateConfigMapDisks(client, op, agentID, diskID, block)
 	if err != nil {
-		return bosherr.WrapError(err, "Updating disk configMap")
+		return "", bosherr.WrapError(err, "Updating disk configMap")
 	}
 
-	updateVolumes(op, &pod.Spec, diskID)
+	updateVolumes(op, &pod.Spec, diskID, block)
+	ensureReadinessProbe(&pod.Spec, v.messageBus())
 
 	if pod.Annotations == nil {
 		pod.Annotations = map[string]string{}
 	}
 
-	if len(pod.Annotations["bosh.cloudfoundry.org/ip-address"]) == 0 {
-		pod.Annotations["bosh.cloudfoundry.org/ip-address"] = pod.Status.PodIP
+	if len(pod.Annotations[IPAddressAnnotation]) == 0 {
+		pod.Annotations[IPAddressAnnotation] = pod.Status.PodIP
+	}
+
+	staticIP := pod.Annotations[IPAddressAnnotation]
+	if pinner != nil {
+		network, err := agentNetwork(client, agentID, staticIP)
+		if err != nil {
+			return "", bosherr.WrapError(err, "Getting network of static IP")
+		}
+		if err := pinIP(pinner, network, pod.Annotations); err != nil {
+			return "", bosherr.WrapError(err, "Pinning static IP")
+		}
 	}
 
 	pod.ObjectMeta = v1.ObjectMeta{
@@ -105,35 +154,101 @@ func (v *VolumeManager) recreatePod(client kubecluster.Client, op Operation, age
 
 	err = podService.Delete("agent-"+agentID, &v1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
 	if err != nil {
-		return bosherr.WrapError(err, "Deleting pod")
+		return "", bosherr.WrapError(err, "Deleting pod")
 	}
 
-	updated, err := podService.Create(pod)
+	updated, err := createUnstructuredPod(client, pod, devices, podSchedulingFields(obj))
 	if err != nil {
-		return bosherr.WrapError(err, "Recreating pod")
+		return "", bosherr.WrapError(err, "Recreating pod")
 	}
 
-	if err := v.waitForPod(podService, agentID, updated.ResourceVersion); err != nil {
-		return bosherr.WrapError(err, "Waiting for pod recreate")
+	recreated, err := v.waitForPod(podService, agentID, updated)
+	if err != nil {
+		return "", bosherr.WrapError(agentDiagnostics(client, agentID, err), "Waiting for pod recreate")
 	}
 
-	// TODO: Need an agent readiness check that's real
-	v.Clock.Sleep(v.PostRecreateDelay)
+	if pinner != nil {
+		if err := verifyIP(recreated.Name, staticIP, recreated.Status.PodIP); err != nil {
+			return "", err
+		}
+	}
 
-	return nil
+	return diskHint, nil
 }
 
-func updateConfigMapDisks(client kubecluster.Client, op Operation, agentID, diskID string) error {
+// updateVMDeployment changes the disks of a VM without a pod of its own.
+// Such VMs were created with replicas and are backed by a deployment. Disks
+// cannot be attached to VMs backed by a StatefulSet. The
+// containers of the pod template are patched as typed containers, which
+// have no volumeDevices, so block disks are not supported.
+func (v *VolumeManager) updateVMDeployment(client kubecluster.Client, op Operation, agentID, diskID string, block bool) (string, error) {
+	deployment, err := vmDeployment(client, agentID)
+	if err != nil {
+		return "", err
+	}
+	if deployment == nil {
+		statefulSet, err := vmStatefulSet(client, agentID)
+		if err != nil {
+			return "", err
+		}
+		if statefulSet != nil {
+			return "", notSupported(bosherr.Errorf("VM %s is a stateful set whose replicas use the persistent disks of its volume claim templates", NewVMCID(client.Context(), agentID)))
+		}
+		return "", cpi.VMNotFoundError{VMCID: NewVMCID(client.Context(), agentID)}
+	}
+	if block {
+		return "", notSupported(bosherr.Errorf("Block disk %s cannot be attached to VM %s with replicas", NewDiskCID(client.Context(), diskID), NewVMCID(client.Context(), agentID)))
+	}
+
+	return v.updateDeployment(client, op, agentID, diskID, deployment)
+}
+
+// messageBus returns the mbus URL the agents were configured with.
+func (v *VolumeManager) messageBus() string {
+	if v.AgentConfig == nil {
+		return ""
+	}
+	return v.AgentConfig.MessageBus
+}
+
+// isBlockDisk returns whether the claim of the disk has the Block volume
+// mode. A missing claim is attached like a file system and leaves the pod
+// pending.
+func isBlockDisk(client kubecluster.Client, diskID string) (bool, error) {
+	claim, err := client.UnstructuredPersistentVolumeClaims().Get("disk-" + diskID)
+	if isNotFoundStatusError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, bosherr.WrapError(err, "Getting PVC")
+	}
+
+	return unstructuredField(claim, "spec", "volumeMode") == BlockVolumeMode, nil
+}
+
+// diskPath returns the path of the disk in the bosh-job container. Block
+// disks are devices, other disks are mounted file systems.
+func diskPath(diskID string, block bool) string {
+	if block {
+		return "/dev/disk-" + diskID
+	}
+	return "/mnt/" + diskID
+}
+
+// updateConfigMapDisks updates the persistent disks in the agent settings and
+// returns the path of the disk. It also returns the device paths of the
+// block disks in the settings by disk ID.
+func updateConfigMapDisks(client kubecluster.Client, op Operation, agentID, diskID string, block bool) (string, map[string]string, error) {
 	configMapService := client.ConfigMaps()
 	cm, err := configMapService.Get("agent-" + agentID)
 	if err != nil {
-		return bosherr.WrapError(err, "Getting configMaps")
+		return "", nil, bosherr.WrapError(err, "Getting configMaps")
 	}
 
 	var settings agent.Settings
 	err = json.Unmarshal([]byte(cm.Data["instance_settings"]), &settings)
 	if err != nil {
-		return bosherr.WrapError(err, "Unmarshalling instance settings")
+		return "", nil, bosherr.WrapError(err, "Unmarshalling instance settings")
 	}
 
 	diskCID := string(NewDiskCID(client.Context(), diskID))
@@ -141,38 +256,131 @@ func updateConfigMapDisks(client kubecluster.Client, op Operation, agentID, disk
 		settings.Disks.Persistent = map[string]string{}
 	}
 
+	var path string
 	switch op {
 	case Add:
-		settings.Disks.Persistent[diskCID] = "/mnt/" + diskID
+		path = diskPath(diskID, block)
+		settings.Disks.Persistent[diskCID] = path
 	case Remove:
 		delete(settings.Disks.Persistent, diskCID)
 	}
 
 	settingsJSON, err := json.Marshal(settings)
 	if err != nil {
-		return bosherr.WrapError(err, "instance settings")
+		return "", nil, bosherr.WrapError(err, "instance settings")
 	}
 
 	cm.Data["instance_settings"] = string(settingsJSON)
 
 	_, err = configMapService.Update(cm)
 	if err != nil {
-		return bosherr.WrapError(err, "Updating configMap")
+		return "", nil, bosherr.WrapError(err, "Updating configMap")
 	}
 
-	return nil
+	devices := map[string]string{}
+	for cid, p := range settings.Disks.Persistent {
+		if strings.HasPrefix(p, "/dev/") {
+			_, id := ParseDiskCID(cpi.DiskCID(cid))
+			devices[id] = p
+		}
+	}
+
+	return path, devices, nil
+}
+
+// createUnstructuredPod creates the pod with the block devices in the
+// bosh-job container and the scheduling fields in its spec. The vendored
+// pod types have neither volumeDevices nor the scheduling fields, so pods
+// with either are created as unstructured objects.
+func createUnstructuredPod(client kubecluster.Client, pod *v1.Pod, devices map[string]string, scheduling map[string]interface{}) (*v1.Pod, error) {
+	if len(devices) == 0 && len(scheduling) == 0 {
+		return client.Pods().Create(pod)
+	}
+
+	obj, err := toUnstructured(pod, v1.SchemeGroupVersion.String(), "Pod")
+	if err != nil {
+		return nil, err
+	}
+	if err := setPodSpecFields(obj, scheduling, "spec"); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id := range devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var volumeDevices []interface{}
+	for _, id := range ids {
+		volumeDevices = append(volumeDevices, map[string]interface{}{
+			"name":       "disk-" + id,
+			"devicePath": devices[id],
+		})
+	}
+
+	containers, _ := unstructuredField(obj, "spec", "containers").([]interface{})
+	for _, c := range containers {
+		if container, ok := c.(map[string]interface{}); ok && container["name"] == "bosh-job" && len(volumeDevices) > 0 {
+			container["volumeDevices"] = volumeDevices
+		}
+	}
+
+	created, err := client.UnstructuredPods().Create(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	typed := &v1.Pod{}
+	if err := fromUnstructured(created, typed); err != nil {
+		return nil, err
+	}
+	return typed, nil
 }
 
-func updateVolumes(op Operation, spec *v1.PodSpec, diskID string) {
+// agentNetwork returns the network from the agent settings that has the IP.
+// Only the IP is known for networks that are not in the settings.
+func agentNetwork(client kubecluster.Client, agentID, ip string) (cpi.Network, error) {
+	cm, err := client.ConfigMaps().Get("agent-" + agentID)
+	if err != nil {
+		return cpi.Network{}, bosherr.WrapError(err, "Getting configMaps")
+	}
+
+	var settings agent.Settings
+	err = json.Unmarshal([]byte(cm.Data["instance_settings"]), &settings)
+	if err != nil {
+		return cpi.Network{}, bosherr.WrapError(err, "Unmarshalling instance settings")
+	}
+
+	for _, network := range settings.Networks {
+		if network.IP == ip {
+			return cpi.Network{
+				Type:    network.Type,
+				IP:      network.IP,
+				Netmask: network.Netmask,
+				Gateway: network.Gateway,
+				DNS:     network.DNS,
+				Default: network.Default,
+			}, nil
+		}
+	}
+
+	return cpi.Network{IP: ip}, nil
+}
+
+func updateVolumes(op Operation, spec *v1.PodSpec, diskID string, block bool) {
 	switch op {
 	case Add:
-		addVolume(spec, diskID)
+		addVolume(spec, diskID, block)
 	case Remove:
 		removeVolume(spec, diskID)
 	}
 }
 
-func addVolume(spec *v1.PodSpec, diskID string) {
+// addVolume adds the claim of the disk to the pod. File systems are mounted
+// into the bosh-job container; block devices are added when the pod is
+// created.
+func addVolume(spec *v1.PodSpec, diskID string, block bool) {
 	spec.Volumes = append(spec.Volumes, v1.Volume{
 		Name: "disk-" + diskID,
 		VolumeSource: v1.VolumeSource{
@@ -181,12 +389,15 @@ func addVolume(spec *v1.PodSpec, diskID string) {
 			},
 		},
 	})
+	if block {
+		return
+	}
 
 	for i, c := range spec.Containers {
 		if c.Name == "bosh-job" {
 			spec.Containers[i].VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
 				Name:      "disk-" + diskID,
-				MountPath: "/mnt/" + diskID,
+				MountPath: diskPath(diskID, false),
 			})
 			break
 		}
@@ -213,49 +424,31 @@ func removeVolume(spec *v1.PodSpec, diskID string) {
 	}
 }
 
-func (v *VolumeManager) waitForPod(podService core.PodInterface, agentID string, resourceVersion string) error {
-	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
+// waitForPod waits for the agent in the recreated pod. The pod that was
+// deleted can still be listed, so only the recreated pod counts.
+func (v *VolumeManager) waitForPod(podService core.PodInterface, agentID string, recreated *v1.Pod) (*v1.Pod, error) {
+	listOptions, err := agentListOptions(agentID)
 	if err != nil {
-		return bosherr.WrapError(err, "Parsing agent selector")
-	}
-
-	listOptions := v1.ListOptions{
-		LabelSelector:   agentSelector.String(),
-		ResourceVersion: resourceVersion,
-		Watch:           true,
+		return nil, err
 	}
-
-	timer := v.Clock.NewTimer(v.PodReadyTimeout)
-	defer timer.Stop()
-
-	podWatch, err := podService.Watch(listOptions)
-	if err != nil {
-		return bosherr.WrapError(err, "Watching pod")
-	}
-	defer podWatch.Stop()
-
-	for {
-		select {
-		case event := <-podWatch.ResultChan():
-			switch event.Type {
-			case watch.Modified:
-				pod, ok := event.Object.(*v1.Pod)
-				if !ok {
-					return bosherr.Errorf("Unexpected object type: %v", reflect.TypeOf(event.Object))
-				}
-
-				if isAgentContainerRunning(pod) {
-					return nil
-				}
-
-			default:
-				return bosherr.Errorf("Unexpected pod watch event: %s", event.Type)
+	listOptions.ResourceVersion = recreated.ResourceVersion
+
+	w := newPodWaiter(v.Clock, v.PodReadyTimeout, podService, listOptions)
+	w.TimeoutMessage = "Pod create failed with a timeout"
+
+	var pod *v1.Pod
+	err = w.Wait(func(objects []runtime.Object) (bool, error) {
+		for _, obj := range objects {
+			p := obj.(*v1.Pod)
+			if p.UID == recreated.UID && isAgentContainerRunning(p) {
+				pod = p
+				return true, nil
 			}
-
-		case <-timer.C():
-			return bosherr.Error("Pod create failed with a timeout")
 		}
-	}
+		return false, nil
+	})
+
+	return pod, err
 }
 
 func isAgentContainerRunning(pod *v1.Pod) bool {
@@ -0,0 +1,327 @@
+package actions
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	core "k8s.io/client-go/kubernetes/typed/core/v1"
+	extensions "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/meta"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/watch"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+)
+
+var (
+	// WatchRetryDelay is the delay before a watch that was closed or failed
+	// with a transient error is re-established. The delay doubles up to
+	// MaxWatchRetryDelay while the watch keeps failing without events.
+	WatchRetryDelay    = time.Second
+	MaxWatchRetryDelay = 30 * time.Second
+
+	// PollInterval is how often the objects are listed when they cannot be
+	// watched.
+	PollInterval = 5 * time.Second
+)
+
+// waitCondition reports whether a wait is over. It gets the current
+// objects sorted by name; a non-nil error ends the wait.
+type waitCondition func(objects []runtime.Object) (bool, error)
+
+// waiter waits until a condition holds for the objects that match its list
+// options. The condition is checked against the listed objects before the
+// objects are watched. A watch that is closed or whose resource version
+// expired is re-established after a fresh list. When the objects cannot be
+// watched, the waiter polls them instead.
+type waiter struct {
+	Clock          clock.Clock
+	Timeout        time.Duration
+	TimeoutMessage string
+
+	// ListOptions select the objects. Its resource version is where the
+	// watch starts when the list does not return one.
+	ListOptions v1.ListOptions
+
+	// Object has the type of the watched objects.
+	Object runtime.Object
+	List   func(v1.ListOptions) (runtime.Object, error)
+	Watch  func(v1.ListOptions) (watch.Interface, error)
+}
+
+func newPodWaiter(clk clock.Clock, timeout time.Duration, podService core.PodInterface, listOptions v1.ListOptions) *waiter {
+	return &waiter{
+		Clock:       clk,
+		Timeout:     timeout,
+		ListOptions: listOptions,
+		Object:      &v1.Pod{},
+		List:        func(opts v1.ListOptions) (runtime.Object, error) { return podService.List(opts) },
+		Watch:       podService.Watch,
+	}
+}
+
+func newPVCWaiter(clk clock.Clock, timeout time.Duration, pvcService core.PersistentVolumeClaimInterface, listOptions v1.ListOptions) *waiter {
+	return &waiter{
+		Clock:       clk,
+		Timeout:     timeout,
+		ListOptions: listOptions,
+		Object:      &v1.PersistentVolumeClaim{},
+		List:        func(opts v1.ListOptions) (runtime.Object, error) { return pvcService.List(opts) },
+		Watch:       pvcService.Watch,
+	}
+}
+
+func newDeploymentWaiter(clk clock.Clock, timeout time.Duration, deploymentService extensions.DeploymentInterface, listOptions v1.ListOptions) *waiter {
+	return &waiter{
+		Clock:       clk,
+		Timeout:     timeout,
+		ListOptions: listOptions,
+		Object:      &v1beta1.Deployment{},
+		List:        func(opts v1.ListOptions) (runtime.Object, error) { return deploymentService.List(opts) },
+		Watch:       deploymentService.Watch,
+	}
+}
+
+func (w *waiter) Wait(condition waitCondition) error {
+	timer := w.Clock.NewTimer(w.Timeout)
+	defer timer.Stop()
+
+	var delay time.Duration
+	for {
+		if delay > 0 && !w.sleep(timer, delay) {
+			return bosherr.Error(w.TimeoutMessage)
+		}
+
+		objects, resourceVersion, err := w.list()
+		if err != nil {
+			if !cpi.IsRetryable(err) {
+				return err
+			}
+			delay = nextRetryDelay(delay)
+			continue
+		}
+
+		done, err := w.check(objects, condition)
+		if done || err != nil {
+			return err
+		}
+
+		if resourceVersion == "" {
+			resourceVersion = w.ListOptions.ResourceVersion
+		}
+
+		watchOptions := w.ListOptions
+		watchOptions.ResourceVersion = resourceVersion
+		watchOptions.Watch = true
+
+		watcher, err := w.Watch(watchOptions)
+		if err != nil {
+			if !cpi.IsRetryable(err) && !kubeerrors.IsMethodNotSupported(err) {
+				return bosherr.WrapError(err, "Watching")
+			}
+			delay = PollInterval
+			continue
+		}
+
+		result := w.watch(watcher, timer, objects, condition)
+		watcher.Stop()
+
+		switch {
+		case result.err != nil || result.done:
+			return result.err
+		case result.expired:
+			delay = 0
+		case result.received:
+			delay = WatchRetryDelay
+		default:
+			delay = nextRetryDelay(delay)
+		}
+	}
+}
+
+type watchResult struct {
+	done     bool
+	err      error
+	expired  bool
+	received bool
+}
+
+// watch applies the events of the watcher to the objects until the
+// condition holds, the wait times out or the watch has to be restarted.
+func (w *waiter) watch(watcher watch.Interface, timer clock.Timer, objects map[string]runtime.Object, condition waitCondition) watchResult {
+	var result watchResult
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return result
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified, watch.Deleted:
+				name, err := w.objectName(event.Object)
+				if err != nil {
+					result.err = err
+					return result
+				}
+
+				if event.Type == watch.Deleted {
+					delete(objects, name)
+				} else {
+					objects[name] = event.Object
+				}
+				result.received = true
+
+				result.done, result.err = w.check(objects, condition)
+				if result.done || result.err != nil {
+					return result
+				}
+
+			case watch.Error:
+				err := kubeerrors.FromObject(event.Object)
+				if isExpired(err) {
+					result.expired = true
+					return result
+				}
+				if !cpi.IsRetryable(err) {
+					result.err = bosherr.WrapError(err, "Watching")
+				}
+				return result
+
+			default:
+				result.err = bosherr.Errorf("Unexpected watch event: %s", event.Type)
+				return result
+			}
+
+		case <-timer.C():
+			result.err = bosherr.Error(w.TimeoutMessage)
+			return result
+		}
+	}
+}
+
+func (w *waiter) list() (map[string]runtime.Object, string, error) {
+	listOptions := w.ListOptions
+	listOptions.ResourceVersion = ""
+	listOptions.Watch = false
+
+	list, err := w.List(listOptions)
+	if err != nil {
+		return nil, "", bosherr.WrapError(err, "Listing")
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, "", bosherr.WrapError(err, "Extracting list")
+	}
+
+	objects := map[string]runtime.Object{}
+	for _, item := range items {
+		name, err := w.objectName(item)
+		if err != nil {
+			return nil, "", err
+		}
+		objects[name] = item
+	}
+
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, "", bosherr.WrapError(err, "Accessing list")
+	}
+
+	return objects, listMeta.GetResourceVersion(), nil
+}
+
+func (w *waiter) objectName(obj runtime.Object) (string, error) {
+	if reflect.TypeOf(obj) != reflect.TypeOf(w.Object) {
+		return "", bosherr.Errorf("Unexpected object type: %v", reflect.TypeOf(obj))
+	}
+
+	objectMeta, err := meta.Accessor(obj)
+	if err != nil {
+		return "", bosherr.WrapError(err, "Accessing object")
+	}
+
+	return objectMeta.GetName(), nil
+}
+
+func (w *waiter) check(objects map[string]runtime.Object, condition waitCondition) (bool, error) {
+	var names []string
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sorted []runtime.Object
+	for _, name := range names {
+		sorted = append(sorted, objects[name])
+	}
+
+	return condition(sorted)
+}
+
+// poll calls check every PollInterval until it reports true or fails. It
+// waits for objects that cannot be listed and watched as typed objects.
+func poll(clk clock.Clock, timeout time.Duration, timeoutMessage string, check func() (bool, error)) error {
+	timeoutTimer := clk.NewTimer(timeout)
+	defer timeoutTimer.Stop()
+
+	for {
+		done, err := check()
+		if done || err != nil {
+			return err
+		}
+
+		timer := clk.NewTimer(PollInterval)
+		select {
+		case <-timer.C():
+		case <-timeoutTimer.C():
+			timer.Stop()
+			return bosherr.Error(timeoutMessage)
+		}
+	}
+}
+
+// sleep waits for the delay and reports false when the wait timed out
+// first.
+func (w *waiter) sleep(timeout clock.Timer, delay time.Duration) bool {
+	timer := w.Clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-timeout.C():
+		return false
+	}
+}
+
+func nextRetryDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay < WatchRetryDelay {
+		delay = WatchRetryDelay
+	}
+	if delay > MaxWatchRetryDelay {
+		delay = MaxWatchRetryDelay
+	}
+	return delay
+}
+
+// isExpired reports whether a watch failed because its resource version is
+// too old, which the API server reports with 410 Gone.
+func isExpired(err error) bool {
+	statusErr, ok := err.(kubeerrors.APIStatus)
+	if !ok {
+		return false
+	}
+	status := statusErr.Status()
+	return status.Code == http.StatusGone || status.Reason == unversioned.StatusReasonExpired
+}
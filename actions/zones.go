@@ -0,0 +1,137 @@
+package actions
+
+import (
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/labels"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+)
+
+const (
+	// ZoneLabel is the well-known node label of the zone of a node.
+	ZoneLabel = "topology.kubernetes.io/zone"
+
+	// LegacyZoneLabel is the zone label of older clusters.
+	LegacyZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+
+	// ZoneAnnotation records the zone of a VM on its pods and of a disk on
+	// its PVC.
+	ZoneAnnotation = "bosh.cloudfoundry.org/zone"
+
+	// SelectedNodeAnnotation asks the provisioner to create the volume of a
+	// PVC where the node can reach it.
+	SelectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+)
+
+// addZoneAffinity requires the nodes of a pod to be in the zone. The zone
+// is added to every node selector term because the terms are alternatives.
+func addZoneAffinity(affinity *v1.Affinity, zone string) {
+	requirement := v1.NodeSelectorRequirement{
+		Key:      ZoneLabel,
+		Operator: v1.NodeSelectorOpIn,
+		Values:   []string{zone},
+	}
+
+	nodeAffinity := &v1.NodeAffinity{}
+	if affinity.NodeAffinity != nil {
+		*nodeAffinity = *affinity.NodeAffinity
+	}
+
+	var terms []v1.NodeSelectorTerm
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	}
+	if len(terms) == 0 {
+		terms = []v1.NodeSelectorTerm{{}}
+	}
+
+	zoneTerms := make([]v1.NodeSelectorTerm, len(terms))
+	for i, term := range terms {
+		zoneTerms[i].MatchExpressions = append(append([]v1.NodeSelectorRequirement{}, term.MatchExpressions...), requirement)
+	}
+
+	nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{NodeSelectorTerms: zoneTerms}
+	affinity.NodeAffinity = nodeAffinity
+}
+
+// findVMPod returns a pod of the VM or nil when the VM has no pods.
+func findVMPod(podService core.PodInterface, agentID string) (*v1.Pod, error) {
+	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Parsing agent selector")
+	}
+
+	podList, err := podService.List(v1.ListOptions{LabelSelector: agentSelector.String()})
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Listing pods")
+	}
+
+	for i := range podList.Items {
+		if podList.Items[i].Spec.NodeName != "" {
+			return &podList.Items[i], nil
+		}
+	}
+
+	if len(podList.Items) > 0 {
+		return &podList.Items[0], nil
+	}
+
+	return nil, nil
+}
+
+// diskPlacement describes where the volume of a disk is provisioned.
+type diskPlacement struct {
+	StorageClass string
+	Zone         string
+	NodeName     string
+}
+
+// vmDiskPlacement returns the placement of a disk created for a VM. The
+// zone of the VM is the zone of its node or, while the pod is not
+// scheduled, the zone it was created for. VMs in other contexts and VM
+// CIDs that were not created by this CPI are ignored.
+func vmDiskPlacement(client kubecluster.Client, vmcid cpi.VMCID) (diskPlacement, error) {
+	if !strings.Contains(string(vmcid), ":") {
+		return diskPlacement{}, nil
+	}
+
+	vmContext, agentID := ParseVMCID(vmcid)
+	if vmContext != client.Context() {
+		return diskPlacement{}, nil
+	}
+
+	pod, err := findVMPod(client.Pods(), agentID)
+	if err != nil || pod == nil {
+		return diskPlacement{}, err
+	}
+
+	placement := diskPlacement{
+		StorageClass: pod.Annotations[StorageClassAnnotation],
+		Zone:         pod.Annotations[ZoneAnnotation],
+		NodeName:     pod.Spec.NodeName,
+	}
+
+	if placement.NodeName != "" {
+		node, err := client.Core().Nodes().Get(placement.NodeName)
+		if err != nil {
+			return diskPlacement{}, bosherr.WrapErrorf(err, "Getting node %s", placement.NodeName)
+		}
+		if zone := nodeZone(node); zone != "" {
+			placement.Zone = zone
+		}
+	}
+
+	return placement, nil
+}
+
+func nodeZone(node *v1.Node) string {
+	if zone := node.Labels[ZoneLabel]; zone != "" {
+		return zone
+	}
+	return node.Labels[LegacyZoneLabel]
+}
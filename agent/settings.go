@@ -27,6 +27,9 @@ type Network struct {
 	DNS     []string `json:"dns,omitempty"`
 
 	Preconfigured bool `json:"preconfigured,omitempty"`
+
+	// Interface is the interface of the pod the network is attached to.
+	Interface string `json:"interface,omitempty"`
 }
 
 type Networks map[string]Network
@@ -3,11 +3,24 @@ package main_test
 import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
 
 	"testing"
 )
 
+var cpiPath string
+
 func TestCpi(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "CPI Main Command Suite")
 }
+
+var _ = BeforeSuite(func() {
+	var err error
+	cpiPath, err = gexec.Build("github.ibm.com/Bluemix/kubernetes-cpi/cmd/cpi")
+	Expect(err).NotTo(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	gexec.CleanupBuildArtifacts()
+})
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
-	"time"
+	"text/tabwriter"
 
 	"code.cloudfoundry.org/clock"
 
@@ -14,17 +14,11 @@ import (
 	"github.ibm.com/Bluemix/kubernetes-cpi/config"
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+	"github.ibm.com/Bluemix/kubernetes-cpi/redact"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
-const (
-	DefaultDiskReadyTimeout       = 600 * time.Second
-	DefaultPostRecreateDelay      = 15 * time.Second
-	DefaultPodReadyTimeout        = 300 * time.Second
-	DefaultDeploymentReadyTimeout = 300 * time.Second
-)
-
 var agentConfigFlag = flag.String(
 	"agentConfig",
 	"",
@@ -34,188 +28,146 @@ var agentConfigFlag = flag.String(
 var kubeConfigFlag = flag.String(
 	"kubeConfig",
 	"",
-	"Path to the serialized kubernetes configuration file",
+	"Path to the kubernetes configuration file or a standard kubeconfig file; the in-cluster service account is used when empty",
+)
+
+var cpiConfigFlag = flag.String(
+	"cpiConfig",
+	"",
+	"Path to the optional CPI configuration file with cloud property defaults, VM types and timeouts",
 )
 
 var debugFlag = flag.Bool(
 	"debug",
 	false,
-	"Write CPI requests and responses to os.Stderr",
+	"Write CPI requests and responses with credentials masked to os.Stderr",
+)
+
+var listenFlag = flag.String(
+	"listen",
+	"",
+	"Serve CPI requests on a unix socket (unix:/path/to/socket) or a loopback address (127.0.0.1:port)",
+)
+
+var serverFlag = flag.String(
+	"server",
+	"",
+	"Forward the request to the CPI server listening on this address and handle it locally if the server cannot be reached",
+)
+
+var listMethodsFlag = flag.Bool(
+	"list-methods",
+	false,
+	"List the CPI methods and exit",
+)
+
+var lenientArgumentsFlag = flag.Bool(
+	"lenient-arguments",
+	false,
+	"Ignore unknown fields in CPI request arguments instead of rejecting the request",
 )
 
 func main() {
 	flag.Parse()
 
-	kubeConf, err := loadKubeConfig(*kubeConfigFlag)
-	if err != nil {
-		panic(err)
-	}
+	registry := actions.NewRegistry()
+	registry.LenientArguments = *lenientArgumentsFlag
 
-	agentConf, err := loadAgentConfig(*agentConfigFlag)
-	if err != nil {
-		panic(err)
+	if *listMethodsFlag {
+		listMethods(registry)
+		return
 	}
 
-	payload, err := ioutil.ReadAll(os.Stdin)
-	if err != nil {
-		panic(err)
+	if *listenFlag != "" {
+		if err := serve(registry, *listenFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	debugJSON("request", payload)
-
-	var req cpi.Request
-	err = json.Unmarshal(payload, &req)
+	payload, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		panic(err)
-	}
-
-	provider := &kubecluster.Provider{
-		Config: kubeConf.ClientConfig(),
+		fmt.Printf("%s", cpi.MarshalResponse(cpi.NewErrorResponse(cpi.CpiError{Cause: bosherr.WrapError(err, "Reading request")})))
+		return
 	}
 
-	var result *cpi.Response
-	switch req.Method {
-	//Info
-	case "info":
-		result, err = cpi.Dispatch(&req, actions.Info)
-
-	// Stemcell Management
-	case "create_stemcell":
-		result, err = cpi.Dispatch(&req, actions.CreateStemcell)
-
-	case "delete_stemcell":
-		result, err = cpi.Dispatch(&req, actions.DeleteStemcell)
-
-		// VM management
-	case "create_vm":
-		vmCreator := &actions.VMCreator{
-			AgentConfig:    agentConf,
-			ClientProvider: provider,
-			Clock:          clock.NewClock(),
-			DeploymentReadyTimeout: DefaultDeploymentReadyTimeout,
+	if *serverFlag != "" {
+		response, err := cpi.Forward(*serverFlag, payload)
+		if err == nil {
+			fmt.Printf("%s", response)
+			return
 		}
-		result, err = cpi.Dispatch(&req, vmCreator.Create)
-
-	case "delete_vm":
-		vmDeleter := &actions.VMDeleter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, vmDeleter.Delete)
-
-	case "has_vm":
-		vmFinder := &actions.VMFinder{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, vmFinder.HasVM)
-
-	case "set_vm_metadata":
-		vmMetadataSetter := actions.VMMetadataSetter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, vmMetadataSetter.SetVMMetadata)
-
-		// Disk management
-	case "create_disk":
-		diskCreator := actions.DiskCreator{
-			ClientProvider:    provider,
-			Clock:             clock.NewClock(),
-			DiskReadyTimeout:  DefaultDiskReadyTimeout,
-			GUIDGeneratorFunc: actions.CreateGUID,
-		}
-		result, err = cpi.Dispatch(&req, diskCreator.CreateDisk)
-
-	case "attach_disk":
-		volumeManager := actions.VolumeManager{
-			ClientProvider:    provider,
-			Clock:             clock.NewClock(),
-			PodReadyTimeout:   DefaultPodReadyTimeout,
-			PostRecreateDelay: DefaultPostRecreateDelay,
-		}
-		result, err = cpi.Dispatch(&req, volumeManager.AttachDisk)
-
-	case "set_disk_metadata":
-		diskMetadataSetter := actions.DiskMetadataSetter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskMetadataSetter.SetDiskMetadata)
-
-	case "has_disk":
-		diskFinder := actions.DiskFinder{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskFinder.HasDisk)
-
-	case "delete_disk":
-		diskDeleter := actions.DiskDeleter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskDeleter.DeleteDisk)
-
-	case "detach_disk":
-		volumeManager := actions.VolumeManager{
-			ClientProvider:    provider,
-			Clock:             clock.NewClock(),
-			PodReadyTimeout:   DefaultPodReadyTimeout,
-			PostRecreateDelay: DefaultPostRecreateDelay,
-		}
-		result, err = cpi.Dispatch(&req, volumeManager.DetachDisk)
-
-	case "get_disks":
-		diskGetter := actions.DiskGetter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskGetter.GetDisks)
-
-		// Not implemented
-	case "configure_networks":
-		result, err = nil, &cpi.NotSupportedError{}
-
-	case "reboot_vm":
-		result, err = nil, &cpi.NotSupportedError{}
-
-	case "snapshot_disk":
-		result, err = nil, &cpi.NotImplementedError{}
 
-	case "delete_snapshot":
-		result, err = nil, &cpi.NotImplementedError{}
-
-	default:
-		err = fmt.Errorf("Unexpected method: %q", req.Method)
-	}
-
-	if err != nil {
-		panic(err)
+		// The server may have started to handle the request, so it is only
+		// handled again when the server was never reached.
+		if _, ok := err.(cpi.ServerUnavailableError); !ok {
+			fmt.Printf("%s", cpi.MarshalResponse(cpi.NewErrorResponse(cpi.CpiError{Cause: err})))
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Handling request locally: %s\n", err)
 	}
 
-	response, err := json.Marshal(result)
+	handler, err := newRequestHandler(registry)
 	if err != nil {
-		panic(err)
+		fmt.Printf("%s", cpi.MarshalResponse(cpi.NewErrorResponse(cpi.CpiError{Cause: err})))
+		return
 	}
 
-	debugJSON("response", response)
-	fmt.Printf("%s", response)
+	fmt.Printf("%s", handler(payload))
 }
 
-func debugJSON(stem string, payload []byte) {
-	if *debugFlag {
-		fmt.Fprintf(os.Stderr, `{ "%s": %s }%c`, stem, payload, '\n')
+// newRequestHandler loads the configuration and returns a handler for
+// serialized CPI requests. The handler is safe for concurrent use.
+func newRequestHandler(registry *cpi.Registry) (cpi.HandlerFunc, error) {
+	kubeConf, err := config.LoadKubernetes(*kubeConfigFlag)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func loadKubeConfig(path string) (*config.Kubernetes, error) {
-	kubeConfigFile, err := os.Open(path)
+	agentConf, err := config.LoadAgent(*agentConfigFlag)
 	if err != nil {
-		return nil, bosherr.WrapErrorf(err, "Opening kubeConfigFile %s", path)
+		return nil, err
 	}
-	defer kubeConfigFile.Close()
 
-	var kubeConf config.Kubernetes
-	err = json.NewDecoder(kubeConfigFile).Decode(&kubeConf)
-	if err != nil {
-		return nil, bosherr.WrapError(err, "Decoding kubeConfigFile")
+	cpiConf := &config.CPI{}
+	if *cpiConfigFlag != "" {
+		cpiConf, err = config.LoadCPI(*cpiConfigFlag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deps := cpi.Dependencies{
+		ClientProvider: kubecluster.NewProvider(kubeConf),
+		Clock:          clock.NewClock(),
+		AgentConfig:    agentConf,
+		CPIConfig:      cpiConf,
+		Timeouts:       cpi.NewTimeouts(cpiConf.Timeouts),
+		Logger:         log.New(os.Stderr, "", log.LstdFlags),
+		CurrentContext: kubeConf.CurrentContext,
 	}
 
-	return &kubeConf, nil
+	handler := registry.Handler(deps)
+	return func(payload []byte) []byte {
+		debugJSON("request", payload)
+		response := handler(payload)
+		debugJSON("response", response)
+		return response
+	}, nil
 }
 
-func loadAgentConfig(path string) (*config.Agent, error) {
-	agentConfigFile, err := os.Open(path)
-	if err != nil {
-		return nil, bosherr.WrapErrorf(err, "Loading agentConfigFile %s", path)
+func listMethods(registry *cpi.Registry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tSUPPORTED\tIMPLEMENTED\tREQUIRES CONTEXT")
+	for _, method := range registry.Methods() {
+		fmt.Fprintf(w, "%s\t%t\t%t\t%t\n", method.Name, method.Supported, method.Implemented(), method.RequiresContext)
 	}
-	defer agentConfigFile.Close()
+	w.Flush()
+}
 
-	var agentConf config.Agent
-	err = json.NewDecoder(agentConfigFile).Decode(&agentConf)
-	if err != nil {
-		return nil, bosherr.WrapError(err, "Decoding agentConfigFile")
+func debugJSON(stem string, payload []byte) {
+	if *debugFlag {
+		fmt.Fprintf(os.Stderr, `{ "%s": %s }%c`, stem, redact.JSON(payload), '\n')
 	}
-
-	return &agentConf, nil
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// serve handles CPI requests on address until the process is interrupted
+// or terminated. Kubernetes clients are shared between requests.
+func serve(registry *cpi.Registry, address string) error {
+	// Signals are handled before the socket exists, so a client that sees
+	// the socket can always stop the server cleanly. A server shut down
+	// before it serves closes the listener and returns at once.
+	server := &http.Server{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Shutdown(context.Background())
+	}()
+
+	handler, err := newRequestHandler(registry)
+	if err != nil {
+		return err
+	}
+	server.Handler = &cpi.Server{Handler: handler}
+
+	listener, err := cpi.Listen(address)
+	if err != nil {
+		return err
+	}
+
+	err = server.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		return bosherr.WrapError(err, "Serving CPI requests")
+	}
+
+	return nil
+}
@@ -1,7 +1,56 @@
 package config
 
+import (
+	"encoding/json"
+	"os"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
 type Agent struct {
 	Blobstore  interface{} `json:"blobstore,omitempty"`
 	MessageBus string      `json:"mbus"`
 	NTPServers []string    `json:"ntp,omitempty"`
 }
+
+func LoadAgent(path string) (*Agent, error) {
+	agentConfigFile, err := os.Open(path)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Loading agentConfigFile %s", path)
+	}
+	defer agentConfigFile.Close()
+
+	var agentConf Agent
+	err = json.NewDecoder(agentConfigFile).Decode(&agentConf)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Decoding agentConfigFile")
+	}
+
+	if err := agentConf.resolveReferences(); err != nil {
+		return nil, bosherr.WrapError(err, "Resolving agentConfigFile references")
+	}
+
+	return &agentConf, nil
+}
+
+// resolveReferences replaces environment variable and file references in
+// the message bus URL, NTP servers and blobstore configuration.
+func (a *Agent) resolveReferences() error {
+	if err := resolveReferences(&a.MessageBus); err != nil {
+		return bosherr.WrapError(err, "Resolving mbus")
+	}
+
+	for i := range a.NTPServers {
+		if err := resolveReferences(&a.NTPServers[i]); err != nil {
+			return bosherr.WrapError(err, "Resolving ntp")
+		}
+	}
+
+	blobstore, err := resolveValueReferences(a.Blobstore)
+	if err != nil {
+		return bosherr.WrapError(err, "Resolving blobstore")
+	}
+	a.Blobstore = blobstore
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// CloudProperties are cloud properties as sent by the director.
+type CloudProperties map[string]interface{}
+
+// CPI holds defaults for cloud properties and the timeouts of the CPI.
+//
+// The cloud properties of create_vm are merged on top of the VM defaults,
+// the defaults of the context and the vm_type profile they name, in that
+// order. The cloud properties of create_disk are merged on top of the disk
+// defaults and the disk defaults of the context. Objects are merged key by
+// key; other values, including lists, replace the default.
+type CPI struct {
+	Timeouts     Timeouts                   `json:"timeouts"`
+	VMDefaults   CloudProperties            `json:"vm_defaults,omitempty"`
+	DiskDefaults CloudProperties            `json:"disk_defaults,omitempty"`
+	VMTypes      map[string]CloudProperties `json:"vm_types,omitempty"`
+	Contexts     map[string]*ContextCPI     `json:"contexts,omitempty"`
+	IPPinning    *IPPinning                 `json:"ip_pinning,omitempty"`
+}
+
+// ContextCPI holds the defaults of a context. They take precedence over the
+// defaults of the CPI.
+type ContextCPI struct {
+	VMDefaults   CloudProperties            `json:"vm_defaults,omitempty"`
+	DiskDefaults CloudProperties            `json:"disk_defaults,omitempty"`
+	VMTypes      map[string]CloudProperties `json:"vm_types,omitempty"`
+	IPPinning    *IPPinning                 `json:"ip_pinning,omitempty"`
+}
+
+// IPPinning selects how the static IP of a VM is requested from the CNI
+// plugin of a cluster so that pods keep their IP when they are recreated.
+type IPPinning struct {
+	// Strategy is "calico", "template" or empty to disable pinning.
+	Strategy string `json:"strategy,omitempty"`
+
+	// Annotations are the pod annotations of the template strategy. Values
+	// are text/template templates with the fields IP, Netmask and Gateway.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Timeouts override the default timeouts of the CPI. Zero values keep the
+// default.
+type Timeouts struct {
+	DiskReady       Duration `json:"disk_ready,omitempty"`
+	DiskCloneReady  Duration `json:"disk_clone_ready,omitempty"`
+	PodReady        Duration `json:"pod_ready,omitempty"`
+	DeploymentReady Duration `json:"deployment_ready,omitempty"`
+	SnapshotReady   Duration `json:"snapshot_ready,omitempty"`
+}
+
+// Duration is a time.Duration that is serialized as a string like "5m30s".
+// Numbers are interpreted as seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return bosherr.Errorf("Invalid duration %s", data)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return bosherr.WrapErrorf(err, "Invalid duration %s", data)
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+func LoadCPI(path string) (*CPI, error) {
+	cpiConfigFile, err := os.Open(path)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Opening cpiConfigFile %s", path)
+	}
+	defer cpiConfigFile.Close()
+
+	var cpiConf CPI
+	decoder := json.NewDecoder(cpiConfigFile)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cpiConf); err != nil {
+		return nil, bosherr.WrapError(err, "Decoding cpiConfigFile")
+	}
+
+	return &cpiConf, nil
+}
+
+// ApplyVMDefaults merges VM cloud properties on top of the defaults. The
+// context of the cloud properties, or currentContext when they do not name
+// one, selects the context defaults. The vm_type property selects a profile
+// from the vm_types of the context or, when the context has no profile with
+// that name, of the CPI.
+func (c *CPI) ApplyVMDefaults(props CloudProperties, currentContext string) (CloudProperties, error) {
+	if c == nil {
+		c = &CPI{}
+	}
+
+	merged := mergeCloudProperties(c.VMDefaults, props)
+	contextConf := c.contextCPI(merged, currentContext)
+	merged = mergeCloudProperties(c.VMDefaults, contextConf.VMDefaults, props)
+
+	vmType, ok := merged["vm_type"]
+	if !ok || vmType == nil || vmType == "" {
+		return merged, nil
+	}
+
+	name, ok := vmType.(string)
+	if !ok {
+		return nil, bosherr.Errorf("Invalid vm_type %v: expected string", vmType)
+	}
+
+	profile, ok := contextConf.VMTypes[name]
+	if !ok {
+		profile, ok = c.VMTypes[name]
+	}
+	if !ok {
+		return nil, bosherr.Errorf("Unknown vm_type %q", name)
+	}
+
+	return mergeCloudProperties(c.VMDefaults, contextConf.VMDefaults, profile, props), nil
+}
+
+// ApplyDiskDefaults merges disk cloud properties on top of the defaults. The
+// context is selected as in ApplyVMDefaults.
+func (c *CPI) ApplyDiskDefaults(props CloudProperties, currentContext string) (CloudProperties, error) {
+	if c == nil {
+		c = &CPI{}
+	}
+
+	merged := mergeCloudProperties(c.DiskDefaults, props)
+	contextConf := c.contextCPI(merged, currentContext)
+	return mergeCloudProperties(c.DiskDefaults, contextConf.DiskDefaults, props), nil
+}
+
+// ContextIPPinning returns the IP pinning of a context or, when the context
+// does not configure one, the IP pinning of the CPI.
+func (c *CPI) ContextIPPinning(context string) IPPinning {
+	if c == nil {
+		return IPPinning{}
+	}
+
+	if contextConf, ok := c.Contexts[context]; ok && contextConf != nil && contextConf.IPPinning != nil {
+		return *contextConf.IPPinning
+	}
+
+	if c.IPPinning != nil {
+		return *c.IPPinning
+	}
+
+	return IPPinning{}
+}
+
+func (c *CPI) contextCPI(props CloudProperties, currentContext string) *ContextCPI {
+	context, _ := props["context"].(string)
+	if context == "" {
+		context = currentContext
+	}
+
+	if contextConf, ok := c.Contexts[context]; ok && contextConf != nil {
+		return contextConf
+	}
+
+	return &ContextCPI{}
+}
+
+// mergeCloudProperties merges the layers into a new object. Later layers
+// take precedence.
+func mergeCloudProperties(layers ...CloudProperties) CloudProperties {
+	merged := CloudProperties{}
+	for _, layer := range layers {
+		mergeInto(merged, layer)
+	}
+	return merged
+}
+
+func mergeInto(target, source map[string]interface{}) {
+	for key, value := range source {
+		sourceMap, sourceIsMap := asMap(value)
+		targetMap, targetIsMap := asMap(target[key])
+		if sourceIsMap && targetIsMap {
+			merged := map[string]interface{}{}
+			mergeInto(merged, targetMap)
+			mergeInto(merged, sourceMap)
+			target[key] = merged
+		} else if sourceIsMap {
+			copied := map[string]interface{}{}
+			mergeInto(copied, sourceMap)
+			target[key] = copied
+		} else {
+			target[key] = value
+		}
+	}
+}
+
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case CloudProperties:
+		return v, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,175 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+)
+
+var _ = Describe("CPI Config", func() {
+	var cpiConf *config.CPI
+
+	BeforeEach(func() {
+		cpiConf = &config.CPI{
+			VMDefaults: config.CloudProperties{
+				"replicas": 1,
+				"resources": map[string]interface{}{
+					"limits": map[string]interface{}{"memory": "1Gi", "cpu": "500m"},
+				},
+			},
+			DiskDefaults: config.CloudProperties{"storage_class": "standard"},
+			VMTypes: map[string]config.CloudProperties{
+				"small": {"resources": map[string]interface{}{"limits": map[string]interface{}{"memory": "512Mi"}}},
+				"large": {"resources": map[string]interface{}{"limits": map[string]interface{}{"memory": "8Gi"}}},
+			},
+			Contexts: map[string]*config.ContextCPI{
+				"default-context": {
+					VMDefaults: config.CloudProperties{"node_selector": map[string]interface{}{"pool": "default"}},
+				},
+				"fast": {
+					DiskDefaults: config.CloudProperties{"storage_class": "ssd"},
+					VMTypes: map[string]config.CloudProperties{
+						"large": {"resources": map[string]interface{}{"limits": map[string]interface{}{"memory": "16Gi"}}},
+					},
+				},
+			},
+		}
+	})
+
+	Describe("ApplyVMDefaults", func() {
+		It("merges the cloud properties on top of the defaults of the CPI and the current context", func() {
+			props, err := cpiConf.ApplyVMDefaults(config.CloudProperties{"replicas": 2}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props).To(Equal(config.CloudProperties{
+				"replicas":      2,
+				"node_selector": map[string]interface{}{"pool": "default"},
+				"resources": map[string]interface{}{
+					"limits": map[string]interface{}{"memory": "1Gi", "cpu": "500m"},
+				},
+			}))
+		})
+
+		It("merges the named vm_type between the defaults and the cloud properties", func() {
+			props, err := cpiConf.ApplyVMDefaults(config.CloudProperties{
+				"vm_type":   "small",
+				"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "2"}},
+			}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props["resources"]).To(Equal(map[string]interface{}{
+				"limits": map[string]interface{}{"memory": "512Mi", "cpu": "2"},
+			}))
+		})
+
+		It("prefers the vm_types of the context named by the cloud properties", func() {
+			props, err := cpiConf.ApplyVMDefaults(config.CloudProperties{"context": "fast", "vm_type": "large"}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props).NotTo(HaveKey("node_selector"))
+			Expect(props["resources"]).To(Equal(map[string]interface{}{
+				"limits": map[string]interface{}{"memory": "16Gi", "cpu": "500m"},
+			}))
+		})
+
+		It("does not modify the configured defaults", func() {
+			_, err := cpiConf.ApplyVMDefaults(config.CloudProperties{
+				"resources": map[string]interface{}{"limits": map[string]interface{}{"memory": "2Gi"}},
+			}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cpiConf.VMDefaults["resources"]).To(Equal(map[string]interface{}{
+				"limits": map[string]interface{}{"memory": "1Gi", "cpu": "500m"},
+			}))
+		})
+
+		It("returns an error when the vm_type is unknown", func() {
+			_, err := cpiConf.ApplyVMDefaults(config.CloudProperties{"vm_type": "huge"}, "default-context")
+			Expect(err).To(MatchError(`Unknown vm_type "huge"`))
+		})
+
+		It("returns the cloud properties when there is no configuration", func() {
+			var missing *config.CPI
+			props, err := missing.ApplyVMDefaults(config.CloudProperties{"replicas": 2}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props).To(Equal(config.CloudProperties{"replicas": 2}))
+		})
+	})
+
+	Describe("ApplyDiskDefaults", func() {
+		It("merges the cloud properties on top of the defaults", func() {
+			props, err := cpiConf.ApplyDiskDefaults(config.CloudProperties{"context": "default-context"}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props).To(Equal(config.CloudProperties{"context": "default-context", "storage_class": "standard"}))
+		})
+
+		It("prefers the disk defaults of the context", func() {
+			props, err := cpiConf.ApplyDiskDefaults(config.CloudProperties{"context": "fast"}, "default-context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props).To(HaveKeyWithValue("storage_class", "ssd"))
+		})
+	})
+
+	Describe("ContextIPPinning", func() {
+		It("prefers the IP pinning of the context", func() {
+			cpiConf.IPPinning = &config.IPPinning{Strategy: "calico"}
+			cpiConf.Contexts["fast"].IPPinning = &config.IPPinning{Strategy: "template", Annotations: map[string]string{"ip": "{{.IP}}"}}
+
+			Expect(cpiConf.ContextIPPinning("fast").Strategy).To(Equal("template"))
+			Expect(cpiConf.ContextIPPinning("default-context").Strategy).To(Equal("calico"))
+		})
+
+		It("disables pinning when there is no configuration", func() {
+			var missing *config.CPI
+			Expect(missing.ContextIPPinning("fast")).To(Equal(config.IPPinning{}))
+		})
+	})
+
+	Describe("LoadCPI", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "cpi-config")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		It("loads the configuration", func() {
+			path := filepath.Join(tempDir, "cpi.json")
+			Expect(ioutil.WriteFile(path, []byte(`{
+				"timeouts": { "disk_ready": "5m", "pod_ready": 90 },
+				"vm_types": { "small": { "resources": { "limits": { "memory": "512Mi" } } } },
+				"contexts": { "bosh": { "disk_defaults": { "storage_class": "ssd" } } }
+			}`), 0600)).To(Succeed())
+
+			cpiConf, err := config.LoadCPI(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Duration(cpiConf.Timeouts.DiskReady)).To(Equal(5 * time.Minute))
+			Expect(time.Duration(cpiConf.Timeouts.PodReady)).To(Equal(90 * time.Second))
+			Expect(cpiConf.Timeouts.DeploymentReady).To(BeZero())
+			Expect(cpiConf.VMTypes).To(HaveKey("small"))
+			Expect(cpiConf.Contexts["bosh"].DiskDefaults).To(Equal(config.CloudProperties{"storage_class": "ssd"}))
+		})
+
+		It("rejects unknown fields", func() {
+			path := filepath.Join(tempDir, "cpi.json")
+			Expect(ioutil.WriteFile(path, []byte(`{ "vm_defualts": {} }`), 0600)).To(Succeed())
+
+			_, err := config.LoadCPI(path)
+			Expect(err).To(MatchError(ContainSubstring(`unknown field "vm_defualts"`)))
+		})
+
+		It("rejects invalid durations", func() {
+			path := filepath.Join(tempDir, "cpi.json")
+			Expect(ioutil.WriteFile(path, []byte(`{ "timeouts": { "disk_ready": "soon" } }`), 0600)).To(Succeed())
+
+			_, err := config.LoadCPI(path)
+			Expect(err).To(MatchError(ContainSubstring("Invalid duration")))
+		})
+	})
+})
@@ -1,24 +1,63 @@
 package config
 
-import clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.com/ghodss/yaml"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// InClusterContext is the name of the context, cluster and user that are
+// created from the service account of the pod the CPI runs in.
+const InClusterContext = "in-cluster"
+
+// ServiceAccountDir is where kubernetes mounts the service account
+// credentials into pods.
+var ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
 
 type Cluster struct {
 	Server                   string `json:"server"`
 	InsecureSkipTLSVerify    bool   `json:"insecure_skip_tls_verify,omitempty"`
+	CertificateAuthority     string `json:"certificate_authority,omitempty"`
 	CertificateAuthorityData string `json:"certificate_authority_data"`
 }
 
 type AuthInfo struct {
-	ClientCertificateData string `json:"client_certificate_data,omitempty"`
-	ClientKeyData         string `json:"client_key_data,omitempty"`
-	Token                 string `json:"token,omitempty"`
-	Username              string `json:"username,omitempty"`
-	Password              string `json:"password,omitempty"`
-	AuthProvider          string `json:"auth_provider,omitempty"`
-	IdpIssuerURL          string `json:"idp_issuer_url,omitempty"`
-	ClientSecret          string `json:"client_secret,omitempty"`
-	ClientID              string `json:"client_id,omitempty"`
-	RefreshToken          string `json:"refresh_token,omitempty"`
+	ClientCertificate     string            `json:"client_certificate,omitempty"`
+	ClientCertificateData string            `json:"client_certificate_data,omitempty"`
+	ClientKey             string            `json:"client_key,omitempty"`
+	ClientKeyData         string            `json:"client_key_data,omitempty"`
+	Token                 string            `json:"token,omitempty"`
+	TokenFile             string            `json:"token_file,omitempty"`
+	Username              string            `json:"username,omitempty"`
+	Password              string            `json:"password,omitempty"`
+	AuthProvider          string            `json:"auth_provider,omitempty"`
+	AuthProviderConfig    map[string]string `json:"auth_provider_config,omitempty"`
+	IdpIssuerURL          string            `json:"idp_issuer_url,omitempty"`
+	ClientSecret          string            `json:"client_secret,omitempty"`
+	ClientID              string            `json:"client_id,omitempty"`
+	RefreshToken          string            `json:"refresh_token,omitempty"`
+	Exec                  *ExecConfig       `json:"exec,omitempty"`
+}
+
+// ExecConfig runs a client-go exec credential plugin to obtain bearer
+// tokens.
+type ExecConfig struct {
+	Command    string       `json:"command"`
+	Args       []string     `json:"args,omitempty"`
+	Env        []ExecEnvVar `json:"env,omitempty"`
+	APIVersion string       `json:"api_version,omitempty"`
+}
+
+type ExecEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 type Context struct {
@@ -32,6 +71,291 @@ type Kubernetes struct {
 	AuthInfos      map[string]*AuthInfo `json:"users"`
 	Contexts       map[string]*Context  `json:"contexts"`
 	CurrentContext string               `json:"current_context"`
+
+	// InCluster adds the in-cluster context that authenticates with the
+	// service account of the pod the CPI runs in. It becomes the current
+	// context unless another one is set.
+	InCluster bool `json:"in_cluster,omitempty"`
+}
+
+// LoadKubernetes reads the kubernetes configuration at path. The file is
+// either in the format of Kubernetes or a standard kubeconfig file. Relative
+// file references are resolved against the directory of the file. An empty
+// path selects the in-cluster configuration.
+func LoadKubernetes(path string) (*Kubernetes, error) {
+	if path == "" {
+		kubeConf := &Kubernetes{InCluster: true}
+		if err := kubeConf.addInCluster(); err != nil {
+			return nil, err
+		}
+		return kubeConf, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Opening kubeConfigFile %s", path)
+	}
+
+	var kubeConf *Kubernetes
+	if isKubeconfig(data) {
+		kubeConf, err = parseKubeconfig(data)
+	} else {
+		kubeConf, err = parseKubernetes(data)
+	}
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Decoding kubeConfigFile")
+	}
+
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Resolving the directory of kubeConfigFile %s", path)
+	}
+	kubeConf.resolvePaths(base)
+
+	for name, authInfo := range kubeConf.AuthInfos {
+		if err := authInfo.resolveReferences(); err != nil {
+			return nil, bosherr.WrapErrorf(err, "Resolving references of user %s", name)
+		}
+	}
+
+	if kubeConf.InCluster {
+		if err := kubeConf.addInCluster(); err != nil {
+			return nil, err
+		}
+	}
+
+	return kubeConf, nil
+}
+
+// isKubeconfig reports whether data is a standard kubeconfig file. Those
+// are versioned and hold lists of named clusters, users and contexts.
+func isKubeconfig(data []byte) bool {
+	var header struct {
+		APIVersion string      `json:"apiVersion"`
+		Kind       string      `json:"kind"`
+		Clusters   interface{} `json:"clusters"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return false
+	}
+
+	_, namedList := header.Clusters.([]interface{})
+	return header.APIVersion != "" || header.Kind == "Config" || namedList
+}
+
+func parseKubernetes(data []byte) (*Kubernetes, error) {
+	var kubeConf Kubernetes
+	if err := yaml.Unmarshal(data, &kubeConf); err != nil {
+		return nil, err
+	}
+	return &kubeConf, nil
+}
+
+// kubeconfigExec holds the exec stanzas of a kubeconfig file. They are
+// not part of the vendored client-go schema so they are decoded separately.
+type kubeconfigExec struct {
+	Users []struct {
+		Name string `json:"name"`
+		User struct {
+			Exec *struct {
+				Command    string       `json:"command"`
+				Args       []string     `json:"args"`
+				Env        []ExecEnvVar `json:"env"`
+				APIVersion string       `json:"apiVersion"`
+			} `json:"exec"`
+		} `json:"user"`
+	} `json:"users"`
+}
+
+func parseKubeconfig(data []byte) (*Kubernetes, error) {
+	cc, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var execs kubeconfigExec
+	if err := yaml.Unmarshal(data, &execs); err != nil {
+		return nil, err
+	}
+
+	kubeConf := &Kubernetes{
+		Clusters:       map[string]*Cluster{},
+		AuthInfos:      map[string]*AuthInfo{},
+		Contexts:       map[string]*Context{},
+		CurrentContext: cc.CurrentContext,
+	}
+	for name, c := range cc.Clusters {
+		kubeConf.Clusters[name] = &Cluster{
+			Server:                   c.Server,
+			InsecureSkipTLSVerify:    c.InsecureSkipTLSVerify,
+			CertificateAuthority:     c.CertificateAuthority,
+			CertificateAuthorityData: string(c.CertificateAuthorityData),
+		}
+	}
+	for name, a := range cc.AuthInfos {
+		kubeConf.AuthInfos[name] = authInfoFromAPI(a)
+	}
+	for _, u := range execs.Users {
+		if info, ok := kubeConf.AuthInfos[u.Name]; ok && u.User.Exec != nil {
+			info.Exec = &ExecConfig{
+				Command:    u.User.Exec.Command,
+				Args:       u.User.Exec.Args,
+				Env:        u.User.Exec.Env,
+				APIVersion: u.User.Exec.APIVersion,
+			}
+		}
+	}
+	for name, c := range cc.Contexts {
+		kubeConf.Contexts[name] = &Context{
+			Cluster:   c.Cluster,
+			AuthInfo:  c.AuthInfo,
+			Namespace: c.Namespace,
+		}
+	}
+
+	return kubeConf, nil
+}
+
+func authInfoFromAPI(a *clientcmdapi.AuthInfo) *AuthInfo {
+	info := &AuthInfo{
+		ClientCertificate:     a.ClientCertificate,
+		ClientCertificateData: string(a.ClientCertificateData),
+		ClientKey:             a.ClientKey,
+		ClientKeyData:         string(a.ClientKeyData),
+		Token:                 a.Token,
+		TokenFile:             a.TokenFile,
+		Username:              a.Username,
+		Password:              a.Password,
+	}
+
+	if a.AuthProvider == nil {
+		return info
+	}
+
+	info.AuthProvider = a.AuthProvider.Name
+	providerConfig := map[string]string{}
+	for k, v := range a.AuthProvider.Config {
+		providerConfig[k] = v
+	}
+
+	if a.AuthProvider.Name == "oidc" {
+		info.IdpIssuerURL = providerConfig["idp-issuer-url"]
+		info.ClientSecret = providerConfig["client-secret"]
+		info.ClientID = providerConfig["client-id"]
+		info.Token = providerConfig["id-token"]
+		info.RefreshToken = providerConfig["refresh-token"]
+		for _, k := range []string{"idp-issuer-url", "client-secret", "client-id", "id-token", "refresh-token"} {
+			delete(providerConfig, k)
+		}
+	}
+
+	if len(providerConfig) != 0 {
+		info.AuthProviderConfig = providerConfig
+	}
+
+	return info
+}
+
+// resolveReferences replaces environment variable and file references in
+// credentials.
+func (a *AuthInfo) resolveReferences() error {
+	values := []*string{
+		&a.ClientCertificateData,
+		&a.ClientKeyData,
+		&a.Token,
+		&a.Username,
+		&a.Password,
+		&a.IdpIssuerURL,
+		&a.ClientSecret,
+		&a.ClientID,
+		&a.RefreshToken,
+	}
+
+	for k := range a.AuthProviderConfig {
+		value := a.AuthProviderConfig[k]
+		if err := resolveReferences(&value); err != nil {
+			return err
+		}
+		a.AuthProviderConfig[k] = value
+	}
+
+	if a.Exec != nil {
+		for i := range a.Exec.Env {
+			values = append(values, &a.Exec.Env[i].Value)
+		}
+	}
+
+	return resolveReferences(values...)
+}
+
+func (k *Kubernetes) resolvePaths(base string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(base, *path)
+		}
+	}
+
+	for _, c := range k.Clusters {
+		resolve(&c.CertificateAuthority)
+	}
+	for _, a := range k.AuthInfos {
+		resolve(&a.ClientCertificate)
+		resolve(&a.ClientKey)
+		resolve(&a.TokenFile)
+
+		// Like kubectl, only commands with a path separator are relative
+		// to the configuration. Other commands are looked up in PATH.
+		if a.Exec != nil && strings.ContainsRune(a.Exec.Command, filepath.Separator) {
+			resolve(&a.Exec.Command)
+		}
+	}
+}
+
+// addInCluster adds the in-cluster context. The token and CA certificate
+// are referenced by path so the client picks up rotated service account
+// credentials when it is created.
+func (k *Kubernetes) addInCluster() error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return bosherr.Error("Loading in-cluster configuration: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	tokenFile := filepath.Join(ServiceAccountDir, "token")
+	if _, err := os.Stat(tokenFile); err != nil {
+		return bosherr.WrapError(err, "Loading in-cluster configuration")
+	}
+
+	namespace := "default"
+	if ns, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "namespace")); err == nil && len(ns) != 0 {
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	if k.Clusters == nil {
+		k.Clusters = map[string]*Cluster{}
+	}
+	if k.AuthInfos == nil {
+		k.AuthInfos = map[string]*AuthInfo{}
+	}
+	if k.Contexts == nil {
+		k.Contexts = map[string]*Context{}
+	}
+
+	k.Clusters[InClusterContext] = &Cluster{
+		Server:               "https://" + net.JoinHostPort(host, port),
+		CertificateAuthority: filepath.Join(ServiceAccountDir, "ca.crt"),
+	}
+	k.AuthInfos[InClusterContext] = &AuthInfo{TokenFile: tokenFile}
+	k.Contexts[InClusterContext] = &Context{
+		Cluster:   InClusterContext,
+		AuthInfo:  InClusterContext,
+		Namespace: namespace,
+	}
+
+	if k.CurrentContext == "" {
+		k.CurrentContext = InClusterContext
+	}
+
+	return nil
 }
 
 func (k Kubernetes) ClientConfig() clientcmdapi.Config {
@@ -54,8 +378,11 @@ func (a *AuthInfo) api() *clientcmdapi.AuthInfo {
 
 	info := clientcmdapi.NewAuthInfo()
 	info.Token = a.Token
+	info.TokenFile = a.TokenFile
 	info.Username = a.Username
 	info.Password = a.Password
+	info.ClientCertificate = a.ClientCertificate
+	info.ClientKey = a.ClientKey
 	if len(a.ClientCertificateData) != 0 {
 		info.ClientCertificateData = []byte(a.ClientCertificateData)
 	}
@@ -68,8 +395,17 @@ func (a *AuthInfo) api() *clientcmdapi.AuthInfo {
 			Name: a.AuthProvider,
 		}
 
-		if a.AuthProvider == "oidc" {
+		if len(a.AuthProviderConfig) != 0 {
 			authProvider.Config = make(map[string]string)
+			for k, v := range a.AuthProviderConfig {
+				authProvider.Config[k] = v
+			}
+		}
+
+		if a.AuthProvider == "oidc" {
+			if authProvider.Config == nil {
+				authProvider.Config = make(map[string]string)
+			}
 			authProvider.Config["idp-issuer-url"] = a.IdpIssuerURL
 			authProvider.Config["client-secret"] = a.ClientSecret
 			authProvider.Config["client-id"] = a.ClientID
@@ -86,6 +422,7 @@ func (c *Cluster) api() *clientcmdapi.Cluster {
 	cluster := clientcmdapi.NewCluster()
 	cluster.Server = c.Server
 	cluster.InsecureSkipTLSVerify = c.InsecureSkipTLSVerify
+	cluster.CertificateAuthority = c.CertificateAuthority
 	if len(c.CertificateAuthorityData) != 0 {
 		cluster.CertificateAuthorityData = []byte(c.CertificateAuthorityData)
 	}
@@ -2,6 +2,9 @@ package config_test
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	"k8s.io/client-go/pkg/runtime"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -210,4 +213,269 @@ var _ = Describe("Kubernetes Config", func() {
 			}))
 		})
 	})
+
+	Describe("LoadKubernetes", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "kubeconfig")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		writeFile := func(name, content string) string {
+			path := filepath.Join(tempDir, name)
+			Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+			return path
+		}
+
+		It("loads the CPI configuration format", func() {
+			path := writeFile("kube.json", `{
+				"clusters": { "bosh": { "server": "https://192.168.64.17:8443", "certificate_authority": "ca.crt" } },
+				"contexts": { "bosh": { "cluster": "bosh", "user": "bosh", "namespace": "bosh" } },
+				"current_context": "bosh",
+				"users": { "bosh": { "token_file": "token" } }
+			}`)
+
+			kubeConf, err := config.LoadKubernetes(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kubeConf.Clusters["bosh"].CertificateAuthority).To(Equal(filepath.Join(tempDir, "ca.crt")))
+			Expect(kubeConf.AuthInfos["bosh"].TokenFile).To(Equal(filepath.Join(tempDir, "token")))
+			Expect(kubeConf.CurrentContext).To(Equal("bosh"))
+		})
+
+		It("loads standard kubeconfig files", func() {
+			path := writeFile("kubeconfig", `
+apiVersion: v1
+kind: Config
+current-context: minikube
+clusters:
+- name: minikube
+  cluster:
+    server: https://192.168.64.17:8443
+    certificate-authority: certs/ca.crt
+- name: remote
+  cluster:
+    server: https://remote:6443
+    certificate-authority-data: Y2VydGlmaWNhdGUtYXV0aG9yaXR5LWRhdGE=
+users:
+- name: minikube
+  user:
+    client-certificate: /etc/minikube/client.crt
+    client-key: certs/client.key
+- name: robot
+  user:
+    tokenFile: token
+- name: oidc
+  user:
+    auth-provider:
+      name: oidc
+      config:
+        client-id: my-id
+        client-secret: my-secret
+        id-token: id-token
+        idp-issuer-url: https://issuer.com
+        idp-certificate-authority: /etc/oidc/ca.crt
+        refresh-token: refresh-token
+contexts:
+- name: minikube
+  context:
+    cluster: minikube
+    user: minikube
+    namespace: bosh
+- name: remote
+  context:
+    cluster: remote
+    user: robot
+`)
+
+			kubeConf, err := config.LoadKubernetes(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(kubeConf.CurrentContext).To(Equal("minikube"))
+			Expect(kubeConf.Clusters).To(Equal(map[string]*config.Cluster{
+				"minikube": {Server: "https://192.168.64.17:8443", CertificateAuthority: filepath.Join(tempDir, "certs", "ca.crt")},
+				"remote":   {Server: "https://remote:6443", CertificateAuthorityData: "certificate-authority-data"},
+			}))
+			Expect(kubeConf.AuthInfos).To(Equal(map[string]*config.AuthInfo{
+				"minikube": {ClientCertificate: "/etc/minikube/client.crt", ClientKey: filepath.Join(tempDir, "certs", "client.key")},
+				"robot":    {TokenFile: filepath.Join(tempDir, "token")},
+				"oidc": {
+					AuthProvider:       "oidc",
+					AuthProviderConfig: map[string]string{"idp-certificate-authority": "/etc/oidc/ca.crt"},
+					ClientID:           "my-id",
+					ClientSecret:       "my-secret",
+					Token:              "id-token",
+					IdpIssuerURL:       "https://issuer.com",
+					RefreshToken:       "refresh-token",
+				},
+			}))
+			Expect(kubeConf.Contexts).To(Equal(map[string]*config.Context{
+				"minikube": {Cluster: "minikube", AuthInfo: "minikube", Namespace: "bosh"},
+				"remote":   {Cluster: "remote", AuthInfo: "robot"},
+			}))
+
+			cc := kubeConf.ClientConfig()
+			Expect(cc.AuthInfos["oidc"].AuthProvider.Config).To(HaveKeyWithValue("idp-certificate-authority", "/etc/oidc/ca.crt"))
+			Expect(cc.AuthInfos["oidc"].AuthProvider.Config).To(HaveKeyWithValue("refresh-token", "refresh-token"))
+			Expect(cc.AuthInfos["robot"].TokenFile).To(Equal(filepath.Join(tempDir, "token")))
+			Expect(cc.Clusters["minikube"].CertificateAuthority).To(Equal(filepath.Join(tempDir, "certs", "ca.crt")))
+		})
+
+		It("loads exec credential plugins", func() {
+			path := writeFile("kubeconfig", `
+apiVersion: v1
+kind: Config
+current-context: eks
+clusters:
+- name: eks
+  cluster:
+    server: https://eks.example.com
+users:
+- name: eks
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: bin/token-helper
+      args: ["token", "-i", "cluster"]
+      env:
+      - name: AWS_PROFILE
+        value: bosh
+- name: gke
+  user:
+    exec:
+      command: gke-gcloud-auth-plugin
+contexts:
+- name: eks
+  context:
+    cluster: eks
+    user: eks
+`)
+
+			kubeConf, err := config.LoadKubernetes(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kubeConf.AuthInfos["eks"].Exec).To(Equal(&config.ExecConfig{
+				Command:    filepath.Join(tempDir, "bin", "token-helper"),
+				Args:       []string{"token", "-i", "cluster"},
+				Env:        []config.ExecEnvVar{{Name: "AWS_PROFILE", Value: "bosh"}},
+				APIVersion: "client.authentication.k8s.io/v1beta1",
+			}))
+			Expect(kubeConf.AuthInfos["gke"].Exec).To(Equal(&config.ExecConfig{
+				Command: "gke-gcloud-auth-plugin",
+			}))
+		})
+
+		It("resolves references in credentials", func() {
+			os.Setenv("KUBE_CONFIG_TEST_PASSWORD", "password")
+			defer os.Unsetenv("KUBE_CONFIG_TEST_PASSWORD")
+			tokenPath := writeFile("refresh-token", "refresh-token\n")
+
+			path := writeFile("kube.json", `{
+				"clusters": { "bosh": { "server": "https://192.168.64.17:8443" } },
+				"contexts": { "bosh": { "cluster": "bosh", "user": "bosh" } },
+				"users": {
+					"bosh": { "username": "admin", "password": "${KUBE_CONFIG_TEST_PASSWORD}" },
+					"oidc": { "auth_provider": "oidc", "refresh_token": "file://`+tokenPath+`" }
+				}
+			}`)
+
+			kubeConf, err := config.LoadKubernetes(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kubeConf.AuthInfos["bosh"].Password).To(Equal("password"))
+			Expect(kubeConf.AuthInfos["oidc"].RefreshToken).To(Equal("refresh-token"))
+		})
+
+		It("returns an error when a credential reference cannot be resolved", func() {
+			path := writeFile("kube.json", `{ "users": { "bosh": { "token": "file://`+filepath.Join(tempDir, "missing")+`" } } }`)
+
+			_, err := config.LoadKubernetes(path)
+			Expect(err).To(MatchError(ContainSubstring("Resolving references of user bosh")))
+		})
+
+		It("returns an error when the file cannot be decoded", func() {
+			path := writeFile("kube.json", `{ "clusters": [ `)
+			_, err := config.LoadKubernetes(path)
+			Expect(err).To(MatchError(ContainSubstring("Decoding kubeConfigFile")))
+		})
+
+		Context("in cluster", func() {
+			var serviceAccountDir string
+			var savedEnv map[string]string
+
+			BeforeEach(func() {
+				serviceAccountDir = filepath.Join(tempDir, "serviceaccount")
+				Expect(os.Mkdir(serviceAccountDir, 0700)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(serviceAccountDir, "token"), []byte("token"), 0600)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(serviceAccountDir, "namespace"), []byte("bosh\n"), 0600)).To(Succeed())
+				config.ServiceAccountDir = serviceAccountDir
+
+				savedEnv = map[string]string{}
+				for _, name := range []string{"KUBERNETES_SERVICE_HOST", "KUBERNETES_SERVICE_PORT"} {
+					savedEnv[name] = os.Getenv(name)
+				}
+				os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+				os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+			})
+
+			AfterEach(func() {
+				config.ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+				for name, value := range savedEnv {
+					os.Setenv(name, value)
+				}
+			})
+
+			It("uses the service account when no path is provided", func() {
+				kubeConf, err := config.LoadKubernetes("")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(kubeConf.CurrentContext).To(Equal(config.InClusterContext))
+				Expect(kubeConf.Clusters[config.InClusterContext]).To(Equal(&config.Cluster{
+					Server:               "https://10.0.0.1:443",
+					CertificateAuthority: filepath.Join(serviceAccountDir, "ca.crt"),
+				}))
+				Expect(kubeConf.AuthInfos[config.InClusterContext]).To(Equal(&config.AuthInfo{
+					TokenFile: filepath.Join(serviceAccountDir, "token"),
+				}))
+				Expect(kubeConf.Contexts[config.InClusterContext]).To(Equal(&config.Context{
+					Cluster:   config.InClusterContext,
+					AuthInfo:  config.InClusterContext,
+					Namespace: "bosh",
+				}))
+			})
+
+			It("adds the in-cluster context to a configuration file", func() {
+				path := writeFile("kube.json", `{
+					"clusters": { "remote": { "server": "https://remote:6443" } },
+					"contexts": { "remote": { "cluster": "remote", "user": "remote" } },
+					"users": { "remote": { "token": "token" } },
+					"current_context": "remote",
+					"in_cluster": true
+				}`)
+
+				kubeConf, err := config.LoadKubernetes(path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(kubeConf.CurrentContext).To(Equal("remote"))
+				Expect(kubeConf.Contexts).To(HaveKey("remote"))
+				Expect(kubeConf.Contexts).To(HaveKey(config.InClusterContext))
+			})
+
+			It("returns an error when not running in a cluster", func() {
+				os.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+				_, err := config.LoadKubernetes("")
+				Expect(err).To(MatchError(ContainSubstring("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")))
+			})
+
+			It("returns an error when the service account token is missing", func() {
+				Expect(os.Remove(filepath.Join(serviceAccountDir, "token"))).To(Succeed())
+
+				_, err := config.LoadKubernetes("")
+				Expect(err).To(MatchError(ContainSubstring("Loading in-cluster configuration")))
+			})
+		})
+	})
 })
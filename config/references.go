@@ -0,0 +1,92 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const fileReferencePrefix = "file://"
+
+var envReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ResolveReference returns the value a configuration value refers to so
+// credentials don't have to be stored in configuration files.
+//
+// A value of the form file:///path/to/file is replaced by the content of
+// the file without trailing newlines. Every ${NAME} in other values is
+// replaced by the value of the environment variable NAME. Referencing a
+// file or variable that does not exist is an error.
+func ResolveReference(value string) (string, error) {
+	if strings.HasPrefix(value, fileReferencePrefix) {
+		path := strings.TrimPrefix(value, fileReferencePrefix)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", bosherr.WrapErrorf(err, "Resolving reference to file %s", path)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var missing []string
+	resolved := envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReferencePattern.FindStringSubmatch(reference)[1]
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return envValue
+	})
+
+	if len(missing) != 0 {
+		return "", bosherr.Errorf("Resolving reference to unset environment variable %s", strings.Join(missing, ", "))
+	}
+
+	return resolved, nil
+}
+
+// resolveReferences resolves references in place.
+func resolveReferences(values ...*string) error {
+	for _, value := range values {
+		resolved, err := ResolveReference(*value)
+		if err != nil {
+			return err
+		}
+		*value = resolved
+	}
+	return nil
+}
+
+// resolveValueReferences resolves references in the strings of a value
+// produced by decoding JSON into an interface{}.
+func resolveValueReferences(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return ResolveReference(v)
+
+	case map[string]interface{}:
+		for key, child := range v {
+			resolved, err := resolveValueReferences(child)
+			if err != nil {
+				return nil, bosherr.WrapErrorf(err, "Resolving %s", key)
+			}
+			v[key] = resolved
+		}
+		return v, nil
+
+	case []interface{}:
+		for i, child := range v {
+			resolved, err := resolveValueReferences(child)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+		return v, nil
+
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,393 @@
+package cpi
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// OpenSchema is implemented by argument types that are generated by the
+// director rather than written by operators. Newer directors may add fields
+// to them so unknown fields are accepted even when decoding strictly.
+type OpenSchema interface {
+	AcceptsUnknownFields() bool
+}
+
+// ArgumentError describes an argument that does not match the parameter
+// type of the action.
+type ArgumentError struct {
+	Index   int
+	Type    reflect.Type
+	Path    string
+	Problem string
+}
+
+func (e ArgumentError) Error() string {
+	return fmt.Sprintf("Invalid argument %d (%s): %s: %s", e.Index, e.Type, e.Path, e.Problem)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	openSchemaType      = reflect.TypeOf((*OpenSchema)(nil)).Elem()
+	identifierPattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// decodeArg decodes the argument at index into a new value of argType.
+// When strict is set, fields that are not part of argType are rejected.
+// Otherwise their paths are returned so they can be reported.
+func decodeArg(index int, arg interface{}, argType reflect.Type, strict bool) (reflect.Value, []string, error) {
+	encoded, err := json.Marshal(arg)
+	if err != nil {
+		return reflect.Value{}, nil, bosherr.WrapErrorf(err, "Marshalling argument %d", index)
+	}
+
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return reflect.Value{}, nil, bosherr.WrapErrorf(err, "Decoding argument %d", index)
+	}
+
+	v := &validator{strict: strict}
+	validationErr := v.validate(generic, argType, fmt.Sprintf("arguments[%d]", index))
+	if validationErr != nil && strict {
+		validationErr.Index, validationErr.Type = index, argType
+		return reflect.Value{}, nil, *validationErr
+	}
+
+	argValue := reflect.New(argType)
+	if err := json.Unmarshal(encoded, argValue.Interface()); err != nil {
+		if validationErr != nil {
+			validationErr.Index, validationErr.Type = index, argType
+			return reflect.Value{}, nil, *validationErr
+		}
+		return reflect.Value{}, nil, bosherr.WrapErrorf(err, "Invalid argument %d (%s)", index, argType)
+	}
+
+	return reflect.Indirect(argValue), v.unknown, nil
+}
+
+type validator struct {
+	strict  bool
+	unknown []string
+}
+
+// validate checks a value produced by decoding JSON with UseNumber against
+// the type it will be unmarshalled into. It follows the rules of
+// encoding/json: null is accepted everywhere, object keys are matched
+// case-insensitively and types with their own unmarshalling accept any value.
+func (v *validator) validate(value interface{}, t reflect.Type, path string) *ArgumentError {
+	if value == nil {
+		return nil
+	}
+
+	if t.Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(jsonUnmarshalerType) ||
+		t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return v.validate(value, t.Elem(), path)
+
+	case reflect.Interface:
+		return nil
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return mismatch(path, "bool", value)
+		}
+
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return mismatch(path, "string", value)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := value.(json.Number)
+		if !ok {
+			return mismatch(path, t.Kind().String(), value)
+		}
+		i, err := strconv.ParseInt(n.String(), 10, 64)
+		if err != nil || reflect.Zero(t).OverflowInt(i) {
+			return &ArgumentError{Path: path, Problem: fmt.Sprintf("expected %s, got %s", t.Kind(), n)}
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := value.(json.Number)
+		if !ok {
+			return mismatch(path, t.Kind().String(), value)
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		if err != nil || reflect.Zero(t).OverflowUint(u) {
+			return &ArgumentError{Path: path, Problem: fmt.Sprintf("expected %s, got %s", t.Kind(), n)}
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			return mismatch(path, t.Kind().String(), value)
+		}
+
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			if _, ok := value.(string); !ok {
+				return mismatch(path, "base64 string", value)
+			}
+			return nil
+		}
+
+		elements, ok := value.([]interface{})
+		if !ok {
+			return mismatch(path, "array", value)
+		}
+		for i, element := range elements {
+			if err := v.validate(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch(path, "object", value)
+		}
+		for _, key := range sortedKeys(object) {
+			if err := v.validate(object[key], t.Elem(), fieldPath(path, key)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch(path, "object", value)
+		}
+		return v.validateStruct(object, t, path)
+	}
+
+	return nil
+}
+
+// acceptsUnknownFields asks a zero value of t whether it is an OpenSchema
+// that accepts unknown fields.
+func acceptsUnknownFields(t reflect.Type) bool {
+	if t.Implements(openSchemaType) {
+		return reflect.Zero(t).Interface().(OpenSchema).AcceptsUnknownFields()
+	}
+	if reflect.PtrTo(t).Implements(openSchemaType) {
+		return reflect.New(t).Interface().(OpenSchema).AcceptsUnknownFields()
+	}
+	return false
+}
+
+func (v *validator) validateStruct(object map[string]interface{}, t reflect.Type, path string) *ArgumentError {
+	fields := jsonFields(t)
+	open := acceptsUnknownFields(t)
+
+	for _, key := range sortedKeys(object) {
+		field, ok := lookupField(fields, key)
+		if !ok {
+			if open {
+				continue
+			}
+
+			keyPath := fieldPath(path, key)
+			if !v.strict {
+				v.unknown = append(v.unknown, keyPath)
+				continue
+			}
+
+			problem := "unknown field"
+			if suggestion := closestField(fields, key); suggestion != "" {
+				problem = fmt.Sprintf("unknown field, did you mean %q?", suggestion)
+			}
+			return &ArgumentError{Path: keyPath, Problem: problem}
+		}
+
+		value := object[key]
+		if field.quoted {
+			if s, ok := value.(string); ok {
+				value = json.Number(s)
+				if field.typ.Kind() == reflect.String || field.typ.Kind() == reflect.Bool {
+					continue
+				}
+			}
+		}
+
+		if err := v.validate(value, field.typ, fieldPath(path, field.name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type jsonField struct {
+	name   string
+	typ    reflect.Type
+	quoted bool
+}
+
+// jsonFields returns the fields encoding/json uses for a struct. Fields of
+// embedded structs are promoted unless a shallower field has the same name.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	var embedded []reflect.Type
+	seen := map[string]bool{}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, options = tag[:idx], tag[idx:]
+		}
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		seen[name] = true
+		fields = append(fields, jsonField{
+			name:   name,
+			typ:    sf.Type,
+			quoted: strings.Contains(options, ",string"),
+		})
+	}
+
+	for _, et := range embedded {
+		for _, field := range jsonFields(et) {
+			if !seen[field.name] {
+				seen[field.name] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+
+	return fields
+}
+
+func lookupField(fields []jsonField, key string) (jsonField, bool) {
+	for _, field := range fields {
+		if field.name == key {
+			return field, true
+		}
+	}
+
+	for _, field := range fields {
+		if strings.EqualFold(field.name, key) {
+			return field, true
+		}
+	}
+
+	return jsonField{}, false
+}
+
+// closestField returns the name of a field that is a likely misspelling of
+// key or an empty string.
+func closestField(fields []jsonField, key string) string {
+	best, bestDistance := "", 3
+	for _, field := range fields {
+		if d := editDistance(strings.ToLower(field.name), strings.ToLower(key)); d < bestDistance {
+			best, bestDistance = field.name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func mismatch(path, expected string, value interface{}) *ArgumentError {
+	return &ArgumentError{Path: path, Problem: fmt.Sprintf("expected %s, got %s", expected, jsonTypeName(value))}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "null"
+	}
+}
+
+func fieldPath(path, key string) string {
+	if identifierPattern.MatchString(key) {
+		return path + "." + key
+	}
+	return fmt.Sprintf("%s[%q]", path, key)
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,16 +1,26 @@
 package cpi
 
 import (
-	"encoding/json"
+	"log"
 	"reflect"
 
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
 )
 
 type Request struct {
-	Method  string        `json:"method"`
-	Args    []interface{} `json:"arguments"`
-	Context Context       `json:"context"`
+	Method     string        `json:"method"`
+	Args       []interface{} `json:"arguments"`
+	Context    Context       `json:"context"`
+	APIVersion int           `json:"api_version,omitempty"`
+}
+
+// Version returns the CPI API version requested by the director. Requests
+// without an api_version are version 1 requests.
+func (r *Request) Version() int {
+	if r.APIVersion < 1 {
+		return 1
+	}
+	return r.APIVersion
 }
 
 type Response struct {
@@ -25,7 +35,40 @@ type ResponseError struct {
 	CanRetry bool   `json:"ok_to_retry"`
 }
 
+// Versioned holds the implementations of an action whose contract differs
+// between CPI API versions. V2 is optional and defaults to V1.
+type Versioned struct {
+	V1 interface{}
+	V2 interface{}
+}
+
+func (v Versioned) actionFunc(version int) interface{} {
+	if version >= 2 && v.V2 != nil {
+		return v.V2
+	}
+	return v.V1
+}
+
+// DispatchOptions controls how request arguments are decoded.
+type DispatchOptions struct {
+	// Lenient ignores argument fields that are not part of the action's
+	// parameter types instead of rejecting the request. Ignored fields are
+	// reported to Logger when it is set.
+	Lenient bool
+	Logger  *log.Logger
+}
+
+// Dispatch decodes the request arguments strictly into the parameters of
+// the action function, calls it, and converts the results to a response.
 func Dispatch(req *Request, actionFunc interface{}) (*Response, error) {
+	return DispatchWithOptions(req, actionFunc, DispatchOptions{})
+}
+
+func DispatchWithOptions(req *Request, actionFunc interface{}, opts DispatchOptions) (*Response, error) {
+	if versioned, ok := actionFunc.(Versioned); ok {
+		actionFunc = versioned.actionFunc(req.Version())
+	}
+
 	actionValue := reflect.ValueOf(actionFunc)
 	actionType := actionValue.Type()
 
@@ -37,27 +80,27 @@ func Dispatch(req *Request, actionFunc interface{}) (*Response, error) {
 	}
 
 	if argCount < requiredArgCount {
-		return nil, bosherr.Errorf("Not enough arguments: have %d, want %d", argCount, requiredArgCount)
+		return nil, CpiError{Cause: bosherr.Errorf("Not enough arguments: have %d, want %d", argCount, requiredArgCount)}
 	}
 
 	if argCount > requiredArgCount && !actionType.IsVariadic() {
-		return nil, bosherr.Errorf("Too many arguments: have %d, want %d", argCount, requiredArgCount)
+		return nil, CpiError{Cause: bosherr.Errorf("Too many arguments: have %d, want %d", argCount, requiredArgCount)}
 	}
 
 	var args []reflect.Value
 	for i, arg := range req.Args {
-		bytes, err := json.Marshal(arg)
+		argValue, unknown, err := decodeArg(i, arg, argType(actionType, i), !opts.Lenient)
 		if err != nil {
-			return nil, bosherr.WrapError(err, "Marshalling arg")
+			return nil, CpiError{Cause: err}
 		}
 
-		argValue := newArgValue(actionType, i)
-		err = json.Unmarshal(bytes, argValue.Interface())
-		if err != nil {
-			return nil, bosherr.WrapError(err, "Unmarshalling arg")
+		for _, path := range unknown {
+			if opts.Logger != nil {
+				opts.Logger.Printf("Ignoring unknown field %s in %s request", path, req.Method)
+			}
 		}
 
-		args = append(args, reflect.Indirect(argValue))
+		args = append(args, argValue)
 	}
 
 	actionResult := actionValue.Call(args)
@@ -65,22 +108,17 @@ func Dispatch(req *Request, actionFunc interface{}) (*Response, error) {
 	return newResponse(actionResult)
 }
 
-func newArgValue(actionType reflect.Type, index int) reflect.Value {
+func argType(actionType reflect.Type, index int) reflect.Type {
 	argCount := actionType.NumIn()
-
-	var argType reflect.Type
 	if actionType.IsVariadic() && index >= argCount-1 {
-		argType = actionType.In(argCount - 1).Elem()
-	} else {
-		argType = actionType.In(index)
+		return actionType.In(argCount - 1).Elem()
 	}
-
-	return reflect.New(argType)
+	return actionType.In(index)
 }
 
 func newResponse(result []reflect.Value) (*Response, error) {
 	if len(result) < 1 || len(result) > 2 {
-		return nil, bosherr.Error("Too many action results")
+		return nil, CpiError{Cause: bosherr.Error("Too many action results")}
 	}
 
 	var resultValue, errValue reflect.Value
@@ -95,11 +133,11 @@ func newResponse(result []reflect.Value) (*Response, error) {
 	case 2:
 		resultValue, errValue = result[0], result[1]
 		if !isErrorValue(errValue) {
-			return nil, bosherr.Error("Action error is not an error")
+			return nil, CpiError{Cause: bosherr.Error("Action error is not an error")}
 		}
 
 	default:
-		return nil, bosherr.Error("Invalid action results")
+		return nil, CpiError{Cause: bosherr.Error("Invalid action results")}
 	}
 
 	resp := &Response{}
@@ -109,7 +147,8 @@ func newResponse(result []reflect.Value) (*Response, error) {
 
 	if errValue.IsValid() && !errValue.IsNil() {
 		err := errValue.Interface().(error)
-		resp.Error = &ResponseError{Message: err.Error()}
+		resp.Error = NewResponseError(err)
+		resp.Log = DiagnosticLog(err)
 	}
 
 	return resp, nil
@@ -2,9 +2,11 @@ package cpi_test
 
 import (
 	"errors"
+	"log"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
 )
 
@@ -52,15 +54,59 @@ var _ = Describe("Dispatch", func() {
 			Expect(resp.Result).To(BeNil())
 			Expect(resp.Error).NotTo(BeNil())
 			Expect(resp.Error.Message).To(Equal("welp"))
+			Expect(resp.Error.Type).To(Equal("Bosh::Clouds::CloudError"))
 
 			Expect(delegate.CallCount).To(Equal(1))
 		})
 	})
 
+	Context("when the action returns a typed error", func() {
+		It("sets the error type on the response", func() {
+			resp, err := cpi.Dispatch(req, delegate.ReturnNotSupported)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Error).To(Equal(&cpi.ResponseError{
+				Type:    "Bosh::Clouds::NotSupported",
+				Message: "Not supported",
+			}))
+		})
+	})
+
+	Context("when the action is versioned", func() {
+		var versioned cpi.Versioned
+
+		BeforeEach(func() {
+			versioned = cpi.Versioned{V1: delegate.NoArgs, V2: delegate.NoArgsReturnBool}
+		})
+
+		It("calls the version 1 action when no api version is requested", func() {
+			resp, err := cpi.Dispatch(req, versioned)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(BeNil())
+		})
+
+		It("calls the version 2 action when version 2 is requested", func() {
+			req.APIVersion = 2
+			resp, err := cpi.Dispatch(req, versioned)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal(true))
+		})
+
+		It("falls back to the version 1 action when there is no version 2 action", func() {
+			req.APIVersion = 2
+			versioned.V2 = nil
+
+			resp, err := cpi.Dispatch(req, versioned)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(BeNil())
+			Expect(delegate.CallCount).To(Equal(1))
+		})
+	})
+
 	Context("when the action takes more arguments than were provided", func() {
 		It("returns an error", func() {
 			_, err := cpi.Dispatch(req, delegate.OneStringArg)
 			Expect(err).To(MatchError("Not enough arguments: have 0, want 1"))
+			Expect(err).To(BeAssignableToTypeOf(cpi.CpiError{}))
 		})
 
 		It("does not call the action", func() {
@@ -174,6 +220,17 @@ var _ = Describe("Dispatch", func() {
 				req.Args = []interface{}{"hello", "world", 12345}
 			})
 
+			It("names the argument in the error", func() {
+				_, err := cpi.Dispatch(req, delegate.VariadicStrings)
+				Expect(err).To(MatchError("Invalid argument 2 (string): arguments[2]: expected string, got number"))
+			})
+		})
+
+		Context("when the variadic args are of the wrong type and the action is called", func() {
+			BeforeEach(func() {
+				req.Args = []interface{}{"hello", "world", 12345}
+			})
+
 			It("calls the action function and marshals the result", func() {
 				_, err := cpi.Dispatch(req, delegate.VariadicStrings)
 				Expect(err).To(HaveOccurred())
@@ -188,6 +245,201 @@ var _ = Describe("Dispatch", func() {
 	})
 })
 
+var _ = Describe("Argument decoding", func() {
+	var req *cpi.Request
+	var delegate *Delegate
+	var props map[string]interface{}
+
+	BeforeEach(func() {
+		delegate = &Delegate{}
+		props = map[string]interface{}{
+			"name":     "web",
+			"replicas": 2,
+			"services": []interface{}{
+				map[string]interface{}{
+					"name": "http",
+					"ports": []interface{}{
+						map[string]interface{}{"port": 80, "target_port": 8080},
+						map[string]interface{}{"port": 443, "target_port": 8443},
+					},
+				},
+			},
+			"labels": map[string]interface{}{"app.kubernetes.io/name": "web"},
+		}
+		req = &cpi.Request{Method: "create", Args: []interface{}{"agent-id", props}}
+	})
+
+	It("decodes arguments that match the parameter types", func() {
+		resp, err := cpi.Dispatch(req, delegate.TakesProperties)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Result).To(Equal(Properties{
+			Name:     "web",
+			Replicas: int32Ptr(2),
+			Services: []PropertiesService{{
+				Name:  "http",
+				Ports: []PropertiesPort{{Port: 80, TargetPort: 8080}, {Port: 443, TargetPort: 8443}},
+			}},
+			Labels: map[string]string{"app.kubernetes.io/name": "web"},
+		}))
+	})
+
+	It("matches field names case-insensitively like encoding/json", func() {
+		delete(props, "name")
+		props["Name"] = "web"
+
+		resp, err := cpi.Dispatch(req, delegate.TakesProperties)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Result.(Properties).Name).To(Equal("web"))
+	})
+
+	It("accepts null values", func() {
+		props["replicas"] = nil
+		props["services"] = nil
+
+		_, err := cpi.Dispatch(req, delegate.TakesProperties)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("when a nested field has the wrong type", func() {
+		BeforeEach(func() {
+			ports := props["services"].([]interface{})[0].(map[string]interface{})["ports"].([]interface{})
+			ports[1].(map[string]interface{})["target_port"] = "https"
+		})
+
+		It("returns an error with the path of the field", func() {
+			_, err := cpi.Dispatch(req, delegate.TakesProperties)
+			Expect(err).To(BeAssignableToTypeOf(cpi.CpiError{}))
+			Expect(err).To(MatchError("Invalid argument 1 (cpi_test.Properties): arguments[1].services[0].ports[1].target_port: expected int, got string"))
+			Expect(delegate.CallCount).To(Equal(0))
+		})
+
+		It("returns the error in lenient mode", func() {
+			_, err := cpi.DispatchWithOptions(req, delegate.TakesProperties, cpi.DispatchOptions{Lenient: true})
+			Expect(err).To(MatchError(ContainSubstring("arguments[1].services[0].ports[1].target_port: expected int, got string")))
+		})
+	})
+
+	Context("when a number does not fit the field", func() {
+		BeforeEach(func() {
+			props["replicas"] = 1.5
+		})
+
+		It("returns an error with the value", func() {
+			_, err := cpi.Dispatch(req, delegate.TakesProperties)
+			Expect(err).To(MatchError(ContainSubstring("arguments[1].replicas: expected int32, got 1.5")))
+		})
+	})
+
+	Context("when a map value has the wrong type", func() {
+		BeforeEach(func() {
+			props["labels"] = map[string]interface{}{"app.kubernetes.io/name": true}
+		})
+
+		It("quotes keys that are not identifiers", func() {
+			_, err := cpi.Dispatch(req, delegate.TakesProperties)
+			Expect(err).To(MatchError(ContainSubstring(`arguments[1].labels["app.kubernetes.io/name"]: expected string, got bool`)))
+		})
+	})
+
+	Context("when an object is expected", func() {
+		BeforeEach(func() {
+			props["services"] = []interface{}{"http"}
+		})
+
+		It("returns an error", func() {
+			_, err := cpi.Dispatch(req, delegate.TakesProperties)
+			Expect(err).To(MatchError(ContainSubstring("arguments[1].services[0]: expected object, got string")))
+		})
+	})
+
+	Context("when an argument has an unknown field", func() {
+		BeforeEach(func() {
+			delete(props, "replicas")
+			props["replica"] = 2
+		})
+
+		It("rejects the argument and suggests the closest field", func() {
+			_, err := cpi.Dispatch(req, delegate.TakesProperties)
+			Expect(err).To(MatchError(`Invalid argument 1 (cpi_test.Properties): arguments[1].replica: unknown field, did you mean "replicas"?`))
+			Expect(delegate.CallCount).To(Equal(0))
+		})
+
+		It("does not suggest unrelated fields", func() {
+			delete(props, "replica")
+			props["volumes"] = []interface{}{}
+
+			_, err := cpi.Dispatch(req, delegate.TakesProperties)
+			Expect(err).To(MatchError(ContainSubstring("arguments[1].volumes: unknown field")))
+			Expect(err.Error()).NotTo(ContainSubstring("did you mean"))
+		})
+
+		Context("and the request is dispatched leniently", func() {
+			It("ignores the field and logs it", func() {
+				logBuffer := gbytes.NewBuffer()
+				opts := cpi.DispatchOptions{Lenient: true, Logger: log.New(logBuffer, "", 0)}
+
+				resp, err := cpi.DispatchWithOptions(req, delegate.TakesProperties, opts)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(resp.Result.(Properties).Replicas).To(BeNil())
+				Expect(logBuffer).To(gbytes.Say("Ignoring unknown field arguments\\[1\\].replica in create request"))
+			})
+		})
+	})
+
+	Context("when the type accepts unknown fields", func() {
+		BeforeEach(func() {
+			req.Args = []interface{}{map[string]interface{}{
+				"default": map[string]interface{}{"type": "manual", "ip": "10.0.0.2", "prefix": "32"},
+			}}
+		})
+
+		It("decodes the argument", func() {
+			resp, err := cpi.Dispatch(req, delegate.TakesNetworks)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal(cpi.Networks{"default": {Type: "manual", IP: "10.0.0.2"}}))
+		})
+	})
+
+	Context("when the type declines unknown fields", func() {
+		BeforeEach(func() {
+			req.Args = []interface{}{map[string]interface{}{"name": "web", "bogus": true}}
+		})
+
+		It("rejects the argument", func() {
+			_, err := cpi.Dispatch(req, delegate.TakesClosedProperties)
+			Expect(err).To(MatchError(ContainSubstring("arguments[0].bogus: unknown field")))
+		})
+	})
+})
+
+// ClosedProperties implements OpenSchema but does not accept unknown fields.
+type ClosedProperties struct {
+	Name string `json:"name"`
+}
+
+func (ClosedProperties) AcceptsUnknownFields() bool { return false }
+
+type Properties struct {
+	Name     string              `json:"name"`
+	Replicas *int32              `json:"replicas"`
+	Services []PropertiesService `json:"services,omitempty"`
+	Labels   map[string]string   `json:"labels"`
+}
+
+type PropertiesService struct {
+	Name  string           `json:"name"`
+	Ports []PropertiesPort `json:"ports"`
+}
+
+type PropertiesPort struct {
+	Port       int32 `json:"port"`
+	TargetPort int   `json:"target_port"`
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
 type Delegate struct {
 	CallCount int
 }
@@ -207,6 +459,26 @@ func (d *Delegate) ReturnErr(msg string) error {
 	return errors.New(msg)
 }
 
+func (d *Delegate) ReturnNotSupported() error {
+	d.CallCount++
+	return &cpi.NotSupportedError{}
+}
+
+func (d *Delegate) TakesProperties(agentID string, props Properties) (Properties, error) {
+	d.CallCount++
+	return props, nil
+}
+
+func (d *Delegate) TakesClosedProperties(props ClosedProperties) (ClosedProperties, error) {
+	d.CallCount++
+	return props, nil
+}
+
+func (d *Delegate) TakesNetworks(networks cpi.Networks) (cpi.Networks, error) {
+	d.CallCount++
+	return networks, nil
+}
+
 func (d *Delegate) OneStringArg(s string) error {
 	d.CallCount++
 	return nil
@@ -1,5 +1,24 @@
 package cpi
 
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+)
+
+const CloudErrorType = "Bosh::Clouds::CloudError"
+
+type CpiError struct {
+	Cause error
+}
+
+func (e CpiError) Type() string  { return "Bosh::Clouds::CpiError" }
+func (e CpiError) Error() string { return e.Cause.Error() }
+
 type NotSupportedError struct{}
 
 func (e NotSupportedError) Type() string  { return "Bosh::Clouds::NotSupported" }
@@ -14,3 +33,138 @@ type DiskNotAttachedError struct{}
 
 func (e DiskNotAttachedError) Type() string  { return "Bosh::Clouds::DiskNotAttached" }
 func (e DiskNotAttachedError) Error() string { return "Disk not attached" }
+
+type VMNotFoundError struct {
+	VMCID VMCID
+}
+
+func (e VMNotFoundError) Type() string  { return "Bosh::Clouds::VMNotFound" }
+func (e VMNotFoundError) Error() string { return fmt.Sprintf("VM not found: %s", e.VMCID) }
+
+type DiskNotFoundError struct {
+	DiskCID DiskCID
+}
+
+func (e DiskNotFoundError) Type() string  { return "Bosh::Clouds::DiskNotFound" }
+func (e DiskNotFoundError) Error() string { return fmt.Sprintf("Disk not found: %s", e.DiskCID) }
+
+// VMCreationFailedError tells the director that create_vm failed. The
+// director will only retry the creation when CanRetry reports true.
+type VMCreationFailedError struct {
+	Cause error
+}
+
+func (e VMCreationFailedError) Type() string   { return "Bosh::Clouds::VMCreationFailed" }
+func (e VMCreationFailedError) Error() string  { return "VM creation failed: " + e.Cause.Error() }
+func (e VMCreationFailedError) CanRetry() bool { return IsRetryable(e.Cause) }
+
+// DiagnosticError adds what the CPI found out about the Kubernetes objects
+// involved in a failure, such as their conditions, events and logs. The
+// diagnostics are part of the message and are returned as the log of the
+// response.
+type DiagnosticError struct {
+	Cause       error
+	Diagnostics string
+}
+
+func (e DiagnosticError) Error() string { return e.Cause.Error() + "\n" + e.Diagnostics }
+
+// DiagnosticLog returns the diagnostics of every DiagnosticError in the
+// cause chain of err.
+func DiagnosticLog(err error) string {
+	var diagnostics []string
+	for _, e := range causes(err) {
+		if diagnostic, ok := e.(DiagnosticError); ok {
+			diagnostics = append(diagnostics, diagnostic.Diagnostics)
+		}
+	}
+	return strings.Join(diagnostics, "\n")
+}
+
+type typedError interface {
+	Type() string
+}
+
+type retryableError interface {
+	CanRetry() bool
+}
+
+// NewResponseError converts an error into the error structure returned to
+// the director. The type is taken from the first error in the cause chain
+// that implements Type() and defaults to Bosh::Clouds::CloudError.
+func NewResponseError(err error) *ResponseError {
+	respErr := &ResponseError{
+		Type:     CloudErrorType,
+		Message:  err.Error(),
+		CanRetry: IsRetryable(err),
+	}
+
+	for _, e := range causes(err) {
+		if typed, ok := e.(typedError); ok {
+			respErr.Type = typed.Type()
+			break
+		}
+	}
+
+	return respErr
+}
+
+// NewErrorResponse creates a response that only carries an error and its
+// diagnostics.
+func NewErrorResponse(err error) *Response {
+	return &Response{Error: NewResponseError(err), Log: DiagnosticLog(err)}
+}
+
+// IsRetryable reports whether an error, or any of its causes, represents a
+// transient failure. Kubernetes timeouts, throttling and server errors are
+// considered transient.
+func IsRetryable(err error) bool {
+	for _, e := range causes(err) {
+		switch e := e.(type) {
+		case retryableError:
+			if e.CanRetry() {
+				return true
+			}
+		case kubeerrors.APIStatus:
+			if isRetryableStatus(e.Status()) {
+				return true
+			}
+		case net.Error:
+			if e.Timeout() || e.Temporary() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isRetryableStatus(status unversioned.Status) bool {
+	switch status.Reason {
+	case unversioned.StatusReasonTimeout, unversioned.StatusReasonServerTimeout:
+		return true
+	}
+
+	return status.Code == kubeerrors.StatusTooManyRequests || status.Code >= 500
+}
+
+// causes flattens the bosh-utils error wrapping and the CPI errors that
+// carry a cause into a list that starts with the outermost error.
+func causes(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case bosherr.ComplexError:
+		return append(append([]error{e}, causes(e.Err)...), causes(e.Cause)...)
+	case *bosherr.ComplexError:
+		return append(append([]error{e}, causes(e.Err)...), causes(e.Cause)...)
+	case VMCreationFailedError:
+		return append([]error{e}, causes(e.Cause)...)
+	case DiagnosticError:
+		return append([]error{e}, causes(e.Cause)...)
+	default:
+		return []error{e}
+	}
+}
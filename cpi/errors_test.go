@@ -0,0 +1,109 @@
+package cpi_test
+
+import (
+	"errors"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+)
+
+var _ = Describe("Errors", func() {
+	Describe("NewResponseError", func() {
+		It("uses the CloudError type for untyped errors", func() {
+			respErr := cpi.NewResponseError(errors.New("welp"))
+			Expect(respErr).To(Equal(&cpi.ResponseError{
+				Type:    "Bosh::Clouds::CloudError",
+				Message: "welp",
+			}))
+		})
+
+		It("uses the type of a typed error", func() {
+			respErr := cpi.NewResponseError(cpi.VMNotFoundError{VMCID: "context:agent-id"})
+			Expect(respErr).To(Equal(&cpi.ResponseError{
+				Type:    "Bosh::Clouds::VMNotFound",
+				Message: "VM not found: context:agent-id",
+			}))
+		})
+
+		It("finds typed errors wrapped by bosh errors", func() {
+			err := bosherr.WrapError(&cpi.DiskNotAttachedError{}, "Detaching disk")
+			respErr := cpi.NewResponseError(err)
+			Expect(respErr.Type).To(Equal("Bosh::Clouds::DiskNotAttached"))
+			Expect(respErr.Message).To(Equal("Detaching disk: Disk not attached"))
+		})
+
+		It("prefers the outermost typed error", func() {
+			err := cpi.VMCreationFailedError{Cause: bosherr.WrapError(cpi.NotSupportedError{}, "Creating pod")}
+			Expect(cpi.NewResponseError(err).Type).To(Equal("Bosh::Clouds::VMCreationFailed"))
+		})
+	})
+
+	Describe("IsRetryable", func() {
+		It("is false for generic errors", func() {
+			Expect(cpi.IsRetryable(errors.New("welp"))).To(BeFalse())
+		})
+
+		It("is false for kubernetes client errors", func() {
+			err := kubeerrors.NewNotFound(unversioned.GroupResource{Resource: "pods"}, "name")
+			Expect(cpi.IsRetryable(err)).To(BeFalse())
+		})
+
+		It("is true for kubernetes timeouts", func() {
+			err := kubeerrors.NewServerTimeout(unversioned.GroupResource{Resource: "pods"}, "create", 1)
+			Expect(cpi.IsRetryable(bosherr.WrapError(err, "Creating pod"))).To(BeTrue())
+		})
+
+		It("is true when the server is throttling requests", func() {
+			err := kubeerrors.NewGenericServerResponse(429, "POST", unversioned.GroupResource{Resource: "pods"}, "name", "", 1, true)
+			Expect(cpi.IsRetryable(err)).To(BeTrue())
+		})
+
+		It("is true for kubernetes server errors", func() {
+			err := kubeerrors.NewInternalError(errors.New("welp"))
+			Expect(cpi.IsRetryable(err)).To(BeTrue())
+		})
+
+		It("sets ok_to_retry on the response error", func() {
+			err := cpi.VMCreationFailedError{Cause: kubeerrors.NewInternalError(errors.New("welp"))}
+			respErr := cpi.NewResponseError(err)
+			Expect(respErr.Type).To(Equal("Bosh::Clouds::VMCreationFailed"))
+			Expect(respErr.CanRetry).To(BeTrue())
+		})
+	})
+
+	Describe("DiagnosticError", func() {
+		var err error
+
+		BeforeEach(func() {
+			err = cpi.VMCreationFailedError{
+				Cause: bosherr.WrapError(cpi.DiagnosticError{
+					Cause:       errors.New("Agent readiness failed with a timeout"),
+					Diagnostics: "Pod agent-id: Pending",
+				}, "Waiting for agent"),
+			}
+		})
+
+		It("adds the diagnostics to the message", func() {
+			Expect(err).To(MatchError("VM creation failed: Waiting for agent: Agent readiness failed with a timeout\nPod agent-id: Pending"))
+		})
+
+		It("returns the diagnostics as the response log", func() {
+			resp := cpi.NewErrorResponse(err)
+			Expect(resp.Error.Type).To(Equal("Bosh::Clouds::VMCreationFailed"))
+			Expect(resp.Log).To(Equal("Pod agent-id: Pending"))
+		})
+
+		It("keeps the retryability of the cause", func() {
+			err = cpi.DiagnosticError{Cause: kubeerrors.NewInternalError(errors.New("welp")), Diagnostics: "details"}
+			Expect(cpi.IsRetryable(err)).To(BeTrue())
+		})
+
+		It("is empty for errors without diagnostics", func() {
+			Expect(cpi.DiagnosticLog(errors.New("welp"))).To(BeEmpty())
+		})
+	})
+})
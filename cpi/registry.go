@@ -0,0 +1,249 @@
+package cpi
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+// Dependencies are shared by the actions created for CPI requests.
+type Dependencies struct {
+	ClientProvider kubecluster.ClientProvider
+	Clock          clock.Clock
+	AgentConfig    *config.Agent
+	CPIConfig      *config.CPI
+	Timeouts       Timeouts
+	Logger         *log.Logger
+
+	// CurrentContext is the kubernetes context of requests whose cloud
+	// properties do not name one.
+	CurrentContext string
+
+	// Registry is the registry that dispatches the request. Dispatch sets
+	// it so actions like info can describe the registered methods.
+	Registry *Registry
+}
+
+// Timeouts controls how long actions wait for Kubernetes resources.
+type Timeouts struct {
+	DiskReady       time.Duration
+	DiskCloneReady  time.Duration
+	PodReady        time.Duration
+	DeploymentReady time.Duration
+	SnapshotReady   time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	DiskReady:       600 * time.Second,
+	DiskCloneReady:  1800 * time.Second,
+	PodReady:        300 * time.Second,
+	DeploymentReady: 300 * time.Second,
+	SnapshotReady:   600 * time.Second,
+}
+
+// NewTimeouts returns the default timeouts overridden by the configured
+// ones.
+func NewTimeouts(conf config.Timeouts) Timeouts {
+	timeouts := DefaultTimeouts
+	override := func(timeout *time.Duration, configured config.Duration) {
+		if configured > 0 {
+			*timeout = time.Duration(configured)
+		}
+	}
+
+	override(&timeouts.DiskReady, conf.DiskReady)
+	override(&timeouts.DiskCloneReady, conf.DiskCloneReady)
+	override(&timeouts.PodReady, conf.PodReady)
+	override(&timeouts.DeploymentReady, conf.DeploymentReady)
+	override(&timeouts.SnapshotReady, conf.SnapshotReady)
+
+	return timeouts
+}
+
+// ActionConstructor creates the action function for a request. The result
+// is passed to Dispatch so it may also be a Versioned action.
+type ActionConstructor func(deps Dependencies) interface{}
+
+// Method describes a CPI method.
+type Method struct {
+	Name string
+
+	// New is nil for methods without an implementation. Those fail with
+	// NotImplementedError when Supported is set and with NotSupportedError
+	// otherwise.
+	New ActionConstructor
+
+	// Supported is set for methods this CPI intends to provide.
+	Supported bool
+
+	// RequiresContext is set for methods that need a Kubernetes client.
+	RequiresContext bool
+
+	// CloudProperties identifies the argument that holds cloud properties
+	// so the configured defaults can be applied to it.
+	CloudProperties *CloudPropertiesArg
+}
+
+type CloudPropertiesKind int
+
+const (
+	VMCloudProperties CloudPropertiesKind = iota
+	DiskCloudProperties
+)
+
+// CloudPropertiesArg identifies an argument that holds cloud properties.
+type CloudPropertiesArg struct {
+	Index int
+	Kind  CloudPropertiesKind
+}
+
+func (m Method) Implemented() bool {
+	return m.New != nil
+}
+
+// Registry maps CPI method names to their actions.
+type Registry struct {
+	methods map[string]Method
+
+	// LenientArguments ignores unknown argument fields instead of failing
+	// the request. It exists for compatibility with manifests written for
+	// older releases of the CPI.
+	LenientArguments bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{methods: map[string]Method{}}
+}
+
+// Register adds a method to the registry. A method registered with the name
+// of an existing method replaces it.
+func (r *Registry) Register(method Method) {
+	r.methods[method.Name] = method
+}
+
+func (r *Registry) Lookup(name string) (Method, bool) {
+	method, ok := r.methods[name]
+	return method, ok
+}
+
+// Methods returns the registered methods ordered by name.
+func (r *Registry) Methods() []Method {
+	methods := []Method{}
+	for _, method := range r.methods {
+		methods = append(methods, method)
+	}
+
+	sort.Sort(methodsByName(methods))
+	return methods
+}
+
+// Dispatch creates the action for the requested method and dispatches the
+// request to it.
+func (r *Registry) Dispatch(req *Request, deps Dependencies) (*Response, error) {
+	method, ok := r.methods[req.Method]
+	if !ok {
+		return nil, bosherr.WrapComplexError(bosherr.Errorf("Unexpected method: %q", req.Method), &NotImplementedError{})
+	}
+
+	if !method.Implemented() {
+		if method.Supported {
+			return nil, &NotImplementedError{}
+		}
+		return nil, &NotSupportedError{}
+	}
+
+	deps.Registry = r
+
+	if method.RequiresContext && deps.ClientProvider == nil {
+		return nil, CpiError{Cause: bosherr.Errorf("Method %q requires a kubernetes client provider", req.Method)}
+	}
+
+	if method.CloudProperties != nil {
+		var err error
+		req, err = applyDefaults(req, *method.CloudProperties, deps.CPIConfig, deps.CurrentContext)
+		if err != nil {
+			return nil, CpiError{Cause: err}
+		}
+	}
+
+	return DispatchWithOptions(req, method.New(deps), DispatchOptions{
+		Lenient: r.LenientArguments,
+		Logger:  deps.Logger,
+	})
+}
+
+// applyDefaults returns a copy of the request with the cloud properties
+// merged on top of the configured defaults. Arguments that are not objects
+// are left for Dispatch to reject.
+func applyDefaults(req *Request, arg CloudPropertiesArg, cpiConf *config.CPI, currentContext string) (*Request, error) {
+	if arg.Index >= len(req.Args) {
+		return req, nil
+	}
+
+	props := config.CloudProperties{}
+	if req.Args[arg.Index] != nil {
+		var ok bool
+		if props, ok = req.Args[arg.Index].(map[string]interface{}); !ok {
+			return req, nil
+		}
+	}
+
+	var merged config.CloudProperties
+	var err error
+	switch arg.Kind {
+	case VMCloudProperties:
+		merged, err = cpiConf.ApplyVMDefaults(props, currentContext)
+	case DiskCloudProperties:
+		merged, err = cpiConf.ApplyDiskDefaults(props, currentContext)
+	}
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Applying defaults to arguments[%d]", arg.Index)
+	}
+
+	withDefaults := *req
+	withDefaults.Args = append([]interface{}{}, req.Args...)
+	withDefaults.Args[arg.Index] = map[string]interface{}(merged)
+	return &withDefaults, nil
+}
+
+// Handler returns a HandlerFunc that decodes requests, dispatches them
+// through the registry and encodes the responses.
+func (r *Registry) Handler(deps Dependencies) HandlerFunc {
+	return func(payload []byte) []byte {
+		var req Request
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return MarshalResponse(NewErrorResponse(CpiError{Cause: bosherr.WrapError(err, "Decoding request")}))
+		}
+
+		resp, err := r.Dispatch(&req, deps)
+		if err != nil {
+			resp = NewErrorResponse(err)
+		}
+
+		return MarshalResponse(resp)
+	}
+}
+
+// MarshalResponse serializes a response. Results that cannot be serialized
+// are reported as errors.
+func MarshalResponse(resp *Response) []byte {
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		payload, _ = json.Marshal(NewErrorResponse(CpiError{Cause: bosherr.WrapError(err, "Marshalling response")}))
+	}
+
+	return payload
+}
+
+type methodsByName []Method
+
+func (m methodsByName) Len() int           { return len(m) }
+func (m methodsByName) Less(i, j int) bool { return m[i].Name < m[j].Name }
+func (m methodsByName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
@@ -0,0 +1,208 @@
+package cpi_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster/fakes"
+)
+
+var _ = Describe("Registry", func() {
+	var (
+		registry *cpi.Registry
+		deps     cpi.Dependencies
+		delegate *Delegate
+		req      *cpi.Request
+	)
+
+	BeforeEach(func() {
+		delegate = &Delegate{}
+		deps = cpi.Dependencies{ClientProvider: &fakes.ClientProvider{}}
+		req = &cpi.Request{Method: "echo", Args: []interface{}{"hello", "world"}}
+
+		registry = cpi.NewRegistry()
+		registry.Register(cpi.Method{
+			Name:      "echo",
+			Supported: true,
+			New: func(d cpi.Dependencies) interface{} {
+				Expect(d.Registry).To(BeIdenticalTo(registry))
+				d.Registry = nil
+				Expect(d).To(Equal(deps))
+				return delegate.VariadicStrings
+			},
+		})
+		registry.Register(cpi.Method{Name: "unsupported"})
+		registry.Register(cpi.Method{Name: "unimplemented", Supported: true})
+		registry.Register(cpi.Method{
+			Name:            "cluster",
+			Supported:       true,
+			RequiresContext: true,
+			New:             func(cpi.Dependencies) interface{} { return delegate.NoArgs },
+		})
+	})
+
+	Describe("Dispatch", func() {
+		It("constructs the action with the dependencies and dispatches the request", func() {
+			resp, err := registry.Dispatch(req, deps)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal([]string{"hello", "world"}))
+			Expect(delegate.CallCount).To(Equal(1))
+		})
+
+		Context("when the method is not registered", func() {
+			It("returns a not implemented error", func() {
+				req.Method = "bogus"
+				_, err := registry.Dispatch(req, deps)
+				Expect(cpi.NewResponseError(err).Type).To(Equal("Bosh::Clouds::NotImplemented"))
+				Expect(err.Error()).To(ContainSubstring(`Unexpected method: "bogus"`))
+			})
+		})
+
+		Context("when the method is not supported", func() {
+			It("returns a not supported error", func() {
+				req.Method = "unsupported"
+				_, err := registry.Dispatch(req, deps)
+				Expect(err).To(MatchError(&cpi.NotSupportedError{}))
+			})
+		})
+
+		Context("when the method is supported but has no implementation", func() {
+			It("returns a not implemented error", func() {
+				req.Method = "unimplemented"
+				_, err := registry.Dispatch(req, deps)
+				Expect(err).To(MatchError(&cpi.NotImplementedError{}))
+			})
+		})
+
+		Context("when the method requires a context and there is no client provider", func() {
+			It("returns a CPI error", func() {
+				req.Method = "cluster"
+				req.Args = []interface{}{}
+				_, err := registry.Dispatch(req, cpi.Dependencies{})
+				Expect(err).To(BeAssignableToTypeOf(cpi.CpiError{}))
+				Expect(delegate.CallCount).To(Equal(0))
+			})
+		})
+	})
+
+	Context("when arguments are lenient", func() {
+		It("ignores unknown fields", func() {
+			registry.Register(cpi.Method{
+				Name:      "properties",
+				Supported: true,
+				New:       func(cpi.Dependencies) interface{} { return delegate.TakesProperties },
+			})
+			req = &cpi.Request{Method: "properties", Args: []interface{}{"agent-id", map[string]interface{}{"bogus": true}}}
+
+			_, err := registry.Dispatch(req, deps)
+			Expect(err).To(MatchError(ContainSubstring("arguments[1].bogus: unknown field")))
+
+			registry.LenientArguments = true
+			_, err = registry.Dispatch(req, deps)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when the method takes cloud properties", func() {
+		BeforeEach(func() {
+			registry.Register(cpi.Method{
+				Name:            "properties",
+				Supported:       true,
+				CloudProperties: &cpi.CloudPropertiesArg{Index: 1, Kind: cpi.DiskCloudProperties},
+				New:             func(cpi.Dependencies) interface{} { return delegate.TakesProperties },
+			})
+			req = &cpi.Request{Method: "properties", Args: []interface{}{"agent-id", map[string]interface{}{"name": "web"}}}
+
+			deps.CPIConfig = &config.CPI{
+				DiskDefaults: config.CloudProperties{"name": "default", "labels": map[string]interface{}{"team": "bosh"}},
+			}
+		})
+
+		It("merges the cloud properties on top of the configured defaults", func() {
+			resp, err := registry.Dispatch(req, deps)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal(Properties{Name: "web", Labels: map[string]string{"team": "bosh"}}))
+			Expect(req.Args[1]).To(Equal(map[string]interface{}{"name": "web"}))
+		})
+
+		It("applies the defaults of the current context", func() {
+			deps.CPIConfig.Contexts = map[string]*config.ContextCPI{
+				"bosh": {DiskDefaults: config.CloudProperties{"labels": map[string]interface{}{"team": "core"}}},
+			}
+			deps.CurrentContext = "bosh"
+
+			resp, err := registry.Dispatch(req, deps)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal(Properties{Name: "web", Labels: map[string]string{"team": "core"}}))
+		})
+
+		It("returns a CPI error when the defaults cannot be applied", func() {
+			registry.Register(cpi.Method{
+				Name:            "properties",
+				Supported:       true,
+				CloudProperties: &cpi.CloudPropertiesArg{Index: 1, Kind: cpi.VMCloudProperties},
+				New:             func(cpi.Dependencies) interface{} { return delegate.TakesProperties },
+			})
+			req.Args[1] = map[string]interface{}{"vm_type": "huge"}
+
+			_, err := registry.Dispatch(req, deps)
+			Expect(err).To(BeAssignableToTypeOf(cpi.CpiError{}))
+			Expect(err).To(MatchError(ContainSubstring(`Applying defaults to arguments[1]: Unknown vm_type "huge"`)))
+			Expect(delegate.CallCount).To(Equal(0))
+		})
+	})
+
+	Describe("Register", func() {
+		It("replaces methods with the same name", func() {
+			registry.Register(cpi.Method{Name: "echo"})
+
+			method, ok := registry.Lookup("echo")
+			Expect(ok).To(BeTrue())
+			Expect(method.Supported).To(BeFalse())
+			Expect(method.Implemented()).To(BeFalse())
+		})
+	})
+
+	Describe("Methods", func() {
+		It("returns the methods ordered by name", func() {
+			var names []string
+			for _, method := range registry.Methods() {
+				names = append(names, method.Name)
+			}
+			Expect(names).To(Equal([]string{"cluster", "echo", "unimplemented", "unsupported"}))
+		})
+	})
+
+	Describe("Handler", func() {
+		It("decodes the request and encodes the response", func() {
+			handler := registry.Handler(deps)
+			payload, err := json.Marshal(req)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(handler(payload)).To(MatchJSON(`{"result":["hello","world"],"error":null,"log":""}`))
+		})
+
+		It("returns errors as responses", func() {
+			handler := registry.Handler(deps)
+			Expect(handler([]byte(`{"method":"unsupported","arguments":[]}`))).To(MatchJSON(`{
+				"result": null,
+				"error": { "type": "Bosh::Clouds::NotSupported", "message": "Not supported", "ok_to_retry": false },
+				"log": ""
+			}`))
+		})
+
+		Context("when the request cannot be decoded", func() {
+			It("returns a CPI error response", func() {
+				handler := registry.Handler(deps)
+
+				var resp cpi.Response
+				Expect(json.Unmarshal(handler([]byte(`{ not-json`)), &resp)).To(Succeed())
+				Expect(resp.Error.Type).To(Equal("Bosh::Clouds::CpiError"))
+				Expect(resp.Error.Message).To(ContainSubstring("Decoding request"))
+			})
+		})
+	})
+})
@@ -0,0 +1,145 @@
+package cpi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+)
+
+const unixAddressPrefix = "unix:"
+
+// HandlerFunc processes a serialized CPI request and returns the serialized
+// response.
+type HandlerFunc func(payload []byte) []byte
+
+// Server accepts CPI requests over HTTP. Every POST body is a CPI request
+// and every response body is a CPI response. Requests are handled
+// concurrently.
+type Server struct {
+	Handler HandlerFunc
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Reading request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.Handler(payload))
+}
+
+// Listen creates a listener for a CPI server. The address is either a unix
+// socket path prefixed with "unix:" or a host:port on the loopback
+// interface. The server is unauthenticated so other addresses are rejected.
+func Listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		path := strings.TrimPrefix(address, unixAddressPrefix)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, bosherr.WrapErrorf(err, "Removing stale socket %s", path)
+		}
+
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, bosherr.WrapErrorf(err, "Listening on %s", path)
+		}
+
+		if err := os.Chmod(path, 0600); err != nil {
+			listener.Close()
+			return nil, bosherr.WrapErrorf(err, "Restricting access to %s", path)
+		}
+
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Parsing address %s", address)
+	}
+
+	if !isLoopback(host) {
+		return nil, bosherr.Errorf("Refusing to listen on non-loopback address %s", address)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, bosherr.WrapErrorf(err, "Listening on %s", address)
+	}
+
+	return listener, nil
+}
+
+// ServerUnavailableError is returned by Forward when no connection to the
+// server can be established, for example because the socket does not exist
+// or the connection is refused. The server has not seen the request, so it
+// is safe to handle it elsewhere.
+type ServerUnavailableError struct {
+	Cause error
+}
+
+func (e ServerUnavailableError) Error() string { return "CPI server unavailable: " + e.Cause.Error() }
+
+// Forward sends a serialized CPI request to the server listening on address
+// and returns the serialized response. It returns a ServerUnavailableError
+// when it cannot connect to the server.
+func Forward(address string, payload []byte) ([]byte, error) {
+	network, dialAddress := "tcp", address
+	url := "http://" + address + "/"
+
+	if strings.HasPrefix(address, unixAddressPrefix) {
+		network, dialAddress = "unix", strings.TrimPrefix(address, unixAddressPrefix)
+		url = "http://cpi/"
+	}
+
+	var dialErr error
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				conn, err := net.Dial(network, dialAddress)
+				dialErr = err
+				return conn, err
+			},
+		},
+	}
+
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(payload))
+	if dialErr != nil {
+		return nil, ServerUnavailableError{Cause: dialErr}
+	}
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Forwarding request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, bosherr.Errorf("Forwarding request: unexpected status %s", resp.Status)
+	}
+
+	response, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Reading response")
+	}
+
+	return response, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,143 @@
+package cpi_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.ibm.com/Bluemix/kubernetes-cpi/cpi"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		server   *cpi.Server
+		payloads chan []byte
+	)
+
+	BeforeEach(func() {
+		payloads = make(chan []byte, 1)
+		server = &cpi.Server{
+			Handler: func(payload []byte) []byte {
+				payloads <- payload
+				return []byte(`{"result":true,"error":null,"log":""}`)
+			},
+		}
+	})
+
+	serveOn := func(address string) net.Listener {
+		listener, err := cpi.Listen(address)
+		Expect(err).NotTo(HaveOccurred())
+
+		go http.Serve(listener, server)
+		return listener
+	}
+
+	Context("when listening on a unix socket", func() {
+		var tempDir string
+		var address string
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "cpi-server")
+			Expect(err).NotTo(HaveOccurred())
+
+			address = "unix:" + filepath.Join(tempDir, "cpi.sock")
+			listener = serveOn(address)
+		})
+
+		AfterEach(func() {
+			listener.Close()
+			os.RemoveAll(tempDir)
+		})
+
+		It("forwards requests to the handler", func() {
+			response, err := cpi.Forward(address, []byte(`{"method":"info"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response).To(MatchJSON(`{"result":true,"error":null,"log":""}`))
+			Expect(payloads).To(Receive(MatchJSON(`{"method":"info"}`)))
+		})
+
+		It("restricts access to the socket to the owner", func() {
+			info, err := os.Stat(filepath.Join(tempDir, "cpi.sock"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("replaces a stale socket", func() {
+			listener.Close()
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "cpi.sock"), []byte{}, 0600)).To(Succeed())
+
+			listener = serveOn(address)
+			_, err := cpi.Forward(address, []byte(`{}`))
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("when listening on a loopback address", func() {
+		It("forwards requests to the handler", func() {
+			listener := serveOn("127.0.0.1:0")
+			defer listener.Close()
+
+			response, err := cpi.Forward(listener.Addr().String(), []byte(`{"method":"info"}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response).To(MatchJSON(`{"result":true,"error":null,"log":""}`))
+		})
+	})
+
+	Context("when the address is not a loopback address", func() {
+		It("refuses to listen", func() {
+			_, err := cpi.Listen("0.0.0.0:0")
+			Expect(err).To(MatchError("Refusing to listen on non-loopback address 0.0.0.0:0"))
+		})
+	})
+
+	Context("when the request is not a POST", func() {
+		It("rejects the request", func() {
+			listener := serveOn("127.0.0.1:0")
+			defer listener.Close()
+
+			resp, err := http.Get("http://" + listener.Addr().String() + "/")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("when no server is listening", func() {
+		It("returns a server unavailable error", func() {
+			_, err := cpi.Forward("unix:/does/not/exist.sock", []byte(`{}`))
+			Expect(err).To(BeAssignableToTypeOf(cpi.ServerUnavailableError{}))
+		})
+
+		It("returns a server unavailable error when the connection is refused", func() {
+			listener, err := cpi.Listen("127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			address := listener.Addr().String()
+			listener.Close()
+
+			_, err = cpi.Forward(address, []byte(`{}`))
+			Expect(err).To(BeAssignableToTypeOf(cpi.ServerUnavailableError{}))
+		})
+	})
+
+	Context("when the server fails the request", func() {
+		It("returns an error that is not a server unavailable error", func() {
+			listener, err := cpi.Listen("127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+
+			go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "boom", http.StatusInternalServerError)
+			}))
+
+			_, err = cpi.Forward(listener.Addr().String(), []byte(`{}`))
+			Expect(err).To(MatchError("Forwarding request: unexpected status 500 Internal Server Error"))
+			Expect(err).NotTo(BeAssignableToTypeOf(cpi.ServerUnavailableError{}))
+		})
+	})
+})
@@ -16,6 +16,10 @@ type Network struct {
 	CloudProperties map[string]interface{} `json:"cloud_properties"`
 }
 
+// AcceptsUnknownFields allows newer directors to send additional network
+// settings.
+func (n Network) AcceptsUnknownFields() bool { return true }
+
 // TODO: Add methods to extract context and ID  from the disk and VM ID's.
 // TODO: Move NewVMCID and NewDiskCID from actions here
 
@@ -52,7 +52,8 @@ var _ = Describe("Checking info", func() {
 			outputBytes, err := testHelper.RunCpi(rootTemplatePath, tmpConfigPath, agentPath, jsonPayload)
 			Expect(err).ToNot(HaveOccurred())
 			expect_info := make(map[string]interface{})
-			expect_info["api_version"] = "1.0"
+			expect_info["api_version"] = float64(2)
+			expect_info["stemcell_formats"] = []interface{}{"docker-light"}
 			err = json.Unmarshal(outputBytes, &resultOutput)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(resultOutput["result"]).ToNot(BeNil())
@@ -1,11 +1,40 @@
 package kubecluster
 
 import (
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
 	v1beta1 "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/runtime"
 )
 
+var (
+	// SnapshotGroupVersion is the API group version of CSI volume snapshots.
+	SnapshotGroupVersion = unversioned.GroupVersion{Group: "snapshot.storage.k8s.io", Version: "v1"}
+
+	// StorageGroupVersion is the API group version of storage classes with
+	// allowVolumeExpansion.
+	StorageGroupVersion = unversioned.GroupVersion{Group: "storage.k8s.io", Version: "v1"}
+
+	// AppsGroupVersion is the API group version of StatefulSets. The apps
+	// group of the vendored client is the beta version that current
+	// clusters no longer serve.
+	AppsGroupVersion = unversioned.GroupVersion{Group: "apps", Version: "v1"}
+)
+
+// UnstructuredInterface manages objects as unstructured data. It is used
+// for resources and fields the vendored client has no types for.
+type UnstructuredInterface interface {
+	Create(obj *runtime.Unstructured) (*runtime.Unstructured, error)
+	Get(name string) (*runtime.Unstructured, error)
+	List(opts runtime.Object) (runtime.Object, error)
+	Delete(name string, opts *v1.DeleteOptions) error
+	Patch(name string, pt api.PatchType, data []byte) (*runtime.Unstructured, error)
+}
+
 type Client interface {
 	Context() string
 	Namespace() string
@@ -17,8 +46,30 @@ type Client interface {
 	PersistentVolumeClaims() core.PersistentVolumeClaimInterface
 	Pods() core.PodInterface
 	Deployments() v1beta1.DeploymentInterface
+	ReplicaSets() v1beta1.ReplicaSetInterface
+	StatefulSets() UnstructuredInterface
+	Secrets() core.SecretInterface
 	Services() core.ServiceInterface
 	IngressService() v1beta1.IngressInterface
+
+	VolumeSnapshots() UnstructuredInterface
+
+	// UnstructuredPersistentVolumeClaims manages claims with fields that
+	// v1.PersistentVolumeClaim lacks, like spec.dataSource.
+	UnstructuredPersistentVolumeClaims() UnstructuredInterface
+
+	// UnstructuredPods manages pods with fields that v1.Pod lacks, like
+	// the volumeDevices of containers.
+	UnstructuredPods() UnstructuredInterface
+
+	// UnstructuredDeployments manages deployments with pod template fields
+	// that v1.PodSpec lacks, like affinity and tolerations.
+	UnstructuredDeployments() UnstructuredInterface
+
+	StorageClasses() UnstructuredInterface
+
+	Events() core.EventInterface
+	PodLogs(name string, opts *v1.PodLogOptions) ([]byte, error)
 }
 
 type client struct {
@@ -26,6 +77,11 @@ type client struct {
 	namespace string
 
 	*kubernetes.Clientset
+	unstructured *dynamic.Client
+	snapshots    *dynamic.Client
+	storage      *dynamic.Client
+	apps         *dynamic.Client
+	extensions   *dynamic.Client
 }
 
 var _ Client = &client{}
@@ -58,6 +114,18 @@ func (c *client) Deployments() v1beta1.DeploymentInterface {
 	return c.Extensions().Deployments(c.namespace)
 }
 
+func (c *client) ReplicaSets() v1beta1.ReplicaSetInterface {
+	return c.Extensions().ReplicaSets(c.namespace)
+}
+
+func (c *client) StatefulSets() UnstructuredInterface {
+	return c.apps.Resource(&unversioned.APIResource{Name: "statefulsets", Namespaced: true}, c.namespace)
+}
+
+func (c *client) Secrets() core.SecretInterface {
+	return c.Core().Secrets(c.namespace)
+}
+
 func (c *client) Services() core.ServiceInterface {
 	return c.Core().Services(c.namespace)
 }
@@ -65,3 +133,31 @@ func (c *client) Services() core.ServiceInterface {
 func (c *client) IngressService() v1beta1.IngressInterface {
 	return c.Extensions().Ingresses(c.namespace)
 }
+
+func (c *client) VolumeSnapshots() UnstructuredInterface {
+	return c.snapshots.Resource(&unversioned.APIResource{Name: "volumesnapshots", Namespaced: true}, c.namespace)
+}
+
+func (c *client) UnstructuredPersistentVolumeClaims() UnstructuredInterface {
+	return c.unstructured.Resource(&unversioned.APIResource{Name: "persistentvolumeclaims", Namespaced: true}, c.namespace)
+}
+
+func (c *client) UnstructuredPods() UnstructuredInterface {
+	return c.unstructured.Resource(&unversioned.APIResource{Name: "pods", Namespaced: true}, c.namespace)
+}
+
+func (c *client) UnstructuredDeployments() UnstructuredInterface {
+	return c.extensions.Resource(&unversioned.APIResource{Name: "deployments", Namespaced: true}, c.namespace)
+}
+
+func (c *client) StorageClasses() UnstructuredInterface {
+	return c.storage.Resource(&unversioned.APIResource{Name: "storageclasses"}, "")
+}
+
+func (c *client) Events() core.EventInterface {
+	return c.Core().Events(c.namespace)
+}
+
+func (c *client) PodLogs(name string, opts *v1.PodLogOptions) ([]byte, error) {
+	return c.Core().Pods(c.namespace).GetLogs(name, opts).DoRaw()
+}
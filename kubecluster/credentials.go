@@ -0,0 +1,235 @@
+package kubecluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+)
+
+const DefaultExecAPIVersion = "client.authentication.k8s.io/v1beta1"
+
+// TokenSource provides the bearer token for requests to the API server.
+// Refresh is called when the API server rejected the current token.
+type TokenSource interface {
+	Token() (string, error)
+	Refresh()
+}
+
+// NewTokenSource returns a token source for credentials that can change
+// while the CPI runs: exec credential plugins and token files. A static
+// token takes precedence over a token file as it does in client-go. Nil is
+// returned for all other credentials.
+func NewTokenSource(authInfo *config.AuthInfo) TokenSource {
+	switch {
+	case authInfo == nil:
+		return nil
+	case authInfo.Exec != nil:
+		return &ExecTokenSource{Config: *authInfo.Exec}
+	case authInfo.TokenFile != "" && authInfo.Token == "":
+		return &FileTokenSource{Path: authInfo.TokenFile}
+	default:
+		return nil
+	}
+}
+
+// FileTokenSource reads a bearer token from a file. The file is read again
+// when it changes so rotated tokens, like those of projected service
+// accounts, are picked up.
+type FileTokenSource struct {
+	Path string
+
+	mutex   sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+	stale   bool
+}
+
+func (f *FileTokenSource) Token() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Reading token file %s", f.Path)
+	}
+
+	if f.token != "" && !f.stale && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.token, nil
+	}
+
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return "", bosherr.WrapErrorf(err, "Reading token file %s", f.Path)
+	}
+
+	f.token = strings.TrimSpace(string(data))
+	f.modTime, f.size, f.stale = info.ModTime(), info.Size(), false
+
+	return f.token, nil
+}
+
+func (f *FileTokenSource) Refresh() {
+	f.mutex.Lock()
+	f.stale = true
+	f.mutex.Unlock()
+}
+
+// ExecTokenSource runs a client-go exec credential plugin. Tokens are
+// cached until they expire or are refreshed.
+type ExecTokenSource struct {
+	Config config.ExecConfig
+
+	// Now defaults to time.Now.
+	Now func() time.Time
+
+	mutex      sync.Mutex
+	token      string
+	expiration time.Time
+}
+
+type execCredential struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Spec       execCredentialSpec    `json:"spec"`
+	Status     *execCredentialStatus `json:"status,omitempty"`
+}
+
+type execCredentialSpec struct {
+	Interactive bool `json:"interactive"`
+}
+
+type execCredentialStatus struct {
+	ExpirationTimestamp *time.Time `json:"expirationTimestamp,omitempty"`
+	Token               string     `json:"token,omitempty"`
+}
+
+func (e *ExecTokenSource) Token() (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.token != "" && (e.expiration.IsZero() || e.now().Before(e.expiration)) {
+		return e.token, nil
+	}
+
+	apiVersion := e.Config.APIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultExecAPIVersion
+	}
+
+	execInfo, err := json.Marshal(execCredential{APIVersion: apiVersion, Kind: "ExecCredential"})
+	if err != nil {
+		return "", bosherr.WrapError(err, "Marshalling exec credential request")
+	}
+
+	cmd := exec.Command(e.Config.Command, e.Config.Args...)
+	cmd.Env = append(os.Environ(), "KUBERNETES_EXEC_INFO="+string(execInfo))
+	for _, env := range e.Config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", bosherr.WrapErrorf(err, "Running exec credential plugin %s: %s", e.Config.Command, strings.TrimSpace(stderr.String()))
+	}
+
+	var cred execCredential
+	if err := json.Unmarshal(stdout.Bytes(), &cred); err != nil {
+		return "", bosherr.WrapErrorf(err, "Decoding output of exec credential plugin %s", e.Config.Command)
+	}
+
+	if cred.Kind != "ExecCredential" || cred.APIVersion != apiVersion {
+		return "", bosherr.Errorf("Exec credential plugin %s returned %s %s, want ExecCredential %s", e.Config.Command, cred.APIVersion, cred.Kind, apiVersion)
+	}
+
+	if cred.Status == nil || cred.Status.Token == "" {
+		return "", bosherr.Errorf("Exec credential plugin %s did not return a token", e.Config.Command)
+	}
+
+	e.token = cred.Status.Token
+	e.expiration = time.Time{}
+	if cred.Status.ExpirationTimestamp != nil {
+		e.expiration = *cred.Status.ExpirationTimestamp
+	}
+
+	return e.token, nil
+}
+
+func (e *ExecTokenSource) Refresh() {
+	e.mutex.Lock()
+	e.token = ""
+	e.mutex.Unlock()
+}
+
+func (e *ExecTokenSource) now() time.Time {
+	if e.Now != nil {
+		return e.Now()
+	}
+	return time.Now()
+}
+
+// tokenRoundTripper authenticates requests with tokens from a TokenSource.
+// When the API server responds with 401 Unauthorized the token is refreshed
+// and the request is sent once more if its body can be replayed. Requests
+// that cannot be replayed fail, but the next request uses the new token.
+type tokenRoundTripper struct {
+	source TokenSource
+	rt     http.RoundTripper
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	t.source.Refresh()
+
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry = cloneRequest(req)
+		retry.Body = body
+	}
+
+	resp.Body.Close()
+	return t.roundTrip(retry)
+}
+
+func (t *tokenRoundTripper) roundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	authorized := cloneRequest(req)
+	authorized.Header.Set("Authorization", "Bearer "+token)
+	return t.rt.RoundTrip(authorized)
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+	return clone
+}
@@ -0,0 +1,276 @@
+package kubecluster_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+var _ = Describe("Credentials", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "credentials")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	writeFile := func(name, content string, mode os.FileMode) string {
+		path := filepath.Join(tempDir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), mode)).To(Succeed())
+		return path
+	}
+
+	Describe("NewTokenSource", func() {
+		It("prefers exec plugins", func() {
+			source := kubecluster.NewTokenSource(&config.AuthInfo{TokenFile: "token", Exec: &config.ExecConfig{Command: "plugin"}})
+			Expect(source).To(Equal(&kubecluster.ExecTokenSource{Config: config.ExecConfig{Command: "plugin"}}))
+		})
+
+		It("uses token files", func() {
+			source := kubecluster.NewTokenSource(&config.AuthInfo{TokenFile: "token"})
+			Expect(source).To(Equal(&kubecluster.FileTokenSource{Path: "token"}))
+		})
+
+		It("ignores the token file when a token is set", func() {
+			Expect(kubecluster.NewTokenSource(&config.AuthInfo{Token: "token", TokenFile: "token"})).To(BeNil())
+		})
+
+		It("returns nil for static credentials", func() {
+			Expect(kubecluster.NewTokenSource(&config.AuthInfo{Username: "user", Password: "password"})).To(BeNil())
+		})
+	})
+
+	Describe("FileTokenSource", func() {
+		var source *kubecluster.FileTokenSource
+
+		BeforeEach(func() {
+			source = &kubecluster.FileTokenSource{Path: writeFile("token", "token-1\n", 0600)}
+		})
+
+		It("reads the token from the file", func() {
+			Expect(source.Token()).To(Equal("token-1"))
+		})
+
+		It("reads the file again when it changes", func() {
+			Expect(source.Token()).To(Equal("token-1"))
+
+			writeFile("token", "rotated-token\n", 0600)
+			Expect(source.Token()).To(Equal("rotated-token"))
+		})
+
+		It("reads the file again after a refresh", func() {
+			Expect(source.Token()).To(Equal("token-1"))
+
+			writeFile("token", "token-2\n", 0600)
+			Expect(os.Chtimes(source.Path, time.Unix(0, 0), time.Unix(0, 0))).To(Succeed())
+			Expect(source.Token()).To(Equal("token-2"))
+
+			writeFile("token", "token-3\n", 0600)
+			Expect(os.Chtimes(source.Path, time.Unix(0, 0), time.Unix(0, 0))).To(Succeed())
+			Expect(source.Token()).To(Equal("token-2"))
+
+			source.Refresh()
+			Expect(source.Token()).To(Equal("token-3"))
+		})
+
+		It("returns an error when the file is missing", func() {
+			source.Path = filepath.Join(tempDir, "missing")
+			_, err := source.Token()
+			Expect(err).To(MatchError(ContainSubstring("Reading token file")))
+		})
+	})
+
+	Describe("ExecTokenSource", func() {
+		var source *kubecluster.ExecTokenSource
+		var counterFile string
+		var now time.Time
+
+		BeforeEach(func() {
+			counterFile = filepath.Join(tempDir, "count")
+			plugin := writeFile("plugin", `#!/bin/sh
+echo x >> "$COUNTER_FILE"
+count=$(wc -l < "$COUNTER_FILE" | tr -d ' ')
+case "$KUBERNETES_EXEC_INFO" in
+  *'"kind":"ExecCredential"'*) ;;
+  *) echo "missing exec info" >&2; exit 1 ;;
+esac
+cat <<JSON
+{
+  "apiVersion": "client.authentication.k8s.io/v1beta1",
+  "kind": "ExecCredential",
+  "status": { "token": "$1-$count", "expirationTimestamp": "2017-07-01T12:00:00Z" }
+}
+JSON
+`, 0700)
+
+			now = time.Date(2017, 7, 1, 11, 0, 0, 0, time.UTC)
+			source = &kubecluster.ExecTokenSource{
+				Config: config.ExecConfig{
+					Command: plugin,
+					Args:    []string{"token"},
+					Env:     []config.ExecEnvVar{{Name: "COUNTER_FILE", Value: counterFile}},
+				},
+				Now: func() time.Time { return now },
+			}
+		})
+
+		It("runs the plugin and caches the token until it expires", func() {
+			Expect(source.Token()).To(Equal("token-1"))
+			Expect(source.Token()).To(Equal("token-1"))
+
+			now = now.Add(2 * time.Hour)
+			Expect(source.Token()).To(Equal("token-2"))
+		})
+
+		It("runs the plugin again after a refresh", func() {
+			Expect(source.Token()).To(Equal("token-1"))
+			source.Refresh()
+			Expect(source.Token()).To(Equal("token-2"))
+		})
+
+		It("returns an error with the plugin output when the plugin fails", func() {
+			source.Config.Command = writeFile("failing", "#!/bin/sh\necho 'login required' >&2\nexit 1\n", 0700)
+
+			_, err := source.Token()
+			Expect(err).To(MatchError(ContainSubstring("login required")))
+		})
+
+		It("returns an error when the api version does not match", func() {
+			source.Config.APIVersion = "client.authentication.k8s.io/v1"
+
+			_, err := source.Token()
+			Expect(err).To(MatchError(ContainSubstring("want ExecCredential client.authentication.k8s.io/v1")))
+		})
+	})
+
+	Describe("TokenRoundTripper", func() {
+		var source *fakeTokenSource
+		var tokens []string
+		var roundTripper http.RoundTripper
+
+		BeforeEach(func() {
+			source = &fakeTokenSource{token: "expired-token"}
+			tokens = nil
+
+			roundTripper = kubecluster.NewTokenRoundTripper(source, roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				tokens = append(tokens, req.Header.Get("Authorization"))
+				return &http.Response{StatusCode: http.StatusUnauthorized, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			}))
+		})
+
+		It("refreshes the token when a POST that cannot be replayed is unauthorized", func() {
+			req, err := http.NewRequest("POST", "https://example.com/api/v1/namespaces/bosh/configmaps", ioutil.NopCloser(strings.NewReader("{}")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(req.GetBody).To(BeNil())
+
+			resp, err := roundTripper.RoundTrip(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(tokens).To(Equal([]string{"Bearer expired-token"}))
+			Expect(source.refreshes).To(Equal(1))
+		})
+	})
+
+	Describe("Provider", func() {
+		var server *ghttp.Server
+		var tokenFile string
+		var provider *kubecluster.Provider
+
+		BeforeEach(func() {
+			server = ghttp.NewTLSServer()
+			tokenFile = writeFile("token", "expired-token", 0600)
+
+			provider = kubecluster.NewProvider(&config.Kubernetes{
+				Clusters: map[string]*config.Cluster{
+					"cluster": {Server: server.URL(), InsecureSkipTLSVerify: true},
+				},
+				AuthInfos: map[string]*config.AuthInfo{
+					"robot": {TokenFile: tokenFile},
+				},
+				Contexts: map[string]*config.Context{
+					"context": {Cluster: "cluster", AuthInfo: "robot", Namespace: "bosh"},
+				},
+				CurrentContext: "context",
+			})
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("refreshes the token and retries when the request is unauthorized", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeaderKV("Authorization", "Bearer expired-token"),
+					func(http.ResponseWriter, *http.Request) {
+						writeFile("token", "fresh-token", 0600)
+						Expect(os.Chtimes(tokenFile, time.Unix(0, 0), time.Unix(0, 0))).To(Succeed())
+					},
+					ghttp.RespondWith(http.StatusUnauthorized, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/api/v1/namespaces/bosh/configmaps"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer fresh-token"),
+					ghttp.VerifyJSON(`{"kind":"ConfigMap","apiVersion":"v1","metadata":{"name":"config","creationTimestamp":null}}`),
+					ghttp.RespondWithJSONEncoded(http.StatusCreated, v1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "config"}}),
+				),
+			)
+
+			client, err := provider.New("")
+			Expect(err).NotTo(HaveOccurred())
+
+			cm, err := client.ConfigMaps().Create(&v1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: "config"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cm.Name).To(Equal("config"))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("fails when the refreshed token is also rejected", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusUnauthorized, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`),
+				ghttp.RespondWith(http.StatusUnauthorized, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`),
+			)
+
+			client, err := provider.New("")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.Pods().Get("pod")
+			Expect(err).To(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+})
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type fakeTokenSource struct {
+	token     string
+	refreshes int
+}
+
+func (f *fakeTokenSource) Token() (string, error) {
+	return f.token, nil
+}
+
+func (f *fakeTokenSource) Refresh() {
+	f.refreshes++
+}
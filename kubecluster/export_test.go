@@ -0,0 +1,7 @@
+package kubecluster
+
+import "net/http"
+
+func NewTokenRoundTripper(source TokenSource, rt http.RoundTripper) http.RoundTripper {
+	return &tokenRoundTripper{source: source, rt: rt}
+}
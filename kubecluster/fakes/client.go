@@ -5,7 +5,12 @@ import (
 	"k8s.io/client-go/kubernetes/fake"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
 	extensions "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+	v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/apimachinery/registered"
 	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/watch"
 	"k8s.io/client-go/testing"
 )
 
@@ -15,11 +20,34 @@ type clientContext interface {
 	Namespace() string
 }
 
+// NewClient creates a client whose Clientset serves the objects like
+// fake.NewSimpleClientset. It keeps the object tracker to itself so claims
+// and pods created from unstructured objects can be added to it.
 func NewClient(objects ...runtime.Object) *Client {
-	return &Client{
+	tracker := testing.NewObjectTracker(api.Scheme, api.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			panic(err)
+		}
+	}
+
+	client := &Client{
 		ClientContext: ClientContext{},
-		Clientset:     *fake.NewSimpleClientset(objects...),
+		Snapshots:     map[string]*runtime.Unstructured{},
+		Classes:       map[string]*runtime.Unstructured{},
+		Sets:          map[string]*runtime.Unstructured{},
 	}
+	client.AddReactor("*", "*", testing.ObjectReaction(tracker, registered.RESTMapper()))
+	client.AddWatchReactor("*", testing.DefaultWatchReactor(watch.NewFake(), nil))
+	client.PrependReactor("*", "volumesnapshots", storeReaction(volumeSnapshotsResource.GroupResource(), client.Snapshots))
+	client.PrependReactor("*", "storageclasses", storeReaction(storageClassesResource.GroupResource(), client.Classes))
+	client.PrependReactor("*", "statefulsets", storeReaction(statefulSetsResource.GroupResource(), client.Sets))
+	client.PrependReactor("create", "persistentvolumeclaims", unstructuredCreateReaction(tracker, func() runtime.Object { return &v1.PersistentVolumeClaim{} }))
+	client.PrependReactor("create", "pods", unstructuredCreateReaction(tracker, func() runtime.Object { return &v1.Pod{} }))
+	client.PrependReactor("create", "deployments", unstructuredCreateReaction(tracker, func() runtime.Object { return &v1beta1.Deployment{} }))
+	client.PrependReactor("patch", "persistentvolumeclaims", patchReaction(tracker, v1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"), func() runtime.Object { return &v1.PersistentVolumeClaim{} }))
+	client.PrependReactor("patch", "deployments", patchReaction(tracker, v1beta1.SchemeGroupVersion.WithKind("Deployment"), func() runtime.Object { return &v1beta1.Deployment{} }))
+	return client
 }
 
 var _ kubecluster.Client = NewClient()
@@ -29,6 +57,15 @@ var _ kubecluster.Client = NewClient()
 type Client struct {
 	ClientContext
 	fake.Clientset
+
+	// Logs holds the logs returned by PodLogs by pod name.
+	Logs map[string]string
+
+	// Snapshots, Classes and Sets hold the VolumeSnapshots, storage
+	// classes and StatefulSets of a client created by NewClient by name.
+	Snapshots map[string]*runtime.Unstructured
+	Classes   map[string]*runtime.Unstructured
+	Sets      map[string]*runtime.Unstructured
 }
 
 func (c *Client) ConfigMaps() core.ConfigMapInterface {
@@ -55,10 +92,39 @@ func (c *Client) Deployments() extensions.DeploymentInterface {
 	return c.Extensions().Deployments(c.Namespace())
 }
 
+func (c *Client) ReplicaSets() extensions.ReplicaSetInterface {
+	return c.Extensions().ReplicaSets(c.Namespace())
+}
+
+func (c *Client) Secrets() core.SecretInterface {
+	return c.Core().Secrets(c.Namespace())
+}
+
 func (c *Client) IngressService() extensions.IngressInterface {
 	return c.Extensions().Ingresses(c.Namespace())
 }
 
+func (c *Client) Events() core.EventInterface {
+	return c.Core().Events(c.Namespace())
+}
+
+// PodLogs records a get action on the log subresource of the pod. The fake
+// Clientset cannot serve logs, so they come from Logs.
+func (c *Client) PodLogs(name string, opts *v1.PodLogOptions) ([]byte, error) {
+	action := testing.GenericActionImpl{
+		ActionImpl: testing.ActionImpl{
+			Namespace:   c.Namespace(),
+			Verb:        "get",
+			Resource:    v1.SchemeGroupVersion.WithResource("pods"),
+			Subresource: "log",
+		},
+		Value: opts,
+	}
+	c.Invokes(action, nil)
+
+	return []byte(c.Logs[name]), nil
+}
+
 func (c *Client) MatchingActions(verb, resource string) []testing.Action {
 	result := []testing.Action{}
 	for _, action := range c.Actions() {
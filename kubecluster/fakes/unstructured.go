@@ -0,0 +1,240 @@
+package fakes
+
+import (
+	"encoding/json"
+
+	"github.ibm.com/Bluemix/kubernetes-cpi/kubecluster"
+	"k8s.io/client-go/pkg/api"
+	kubeerrors "k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/labels"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/testing"
+)
+
+var (
+	volumeSnapshotsResource = kubecluster.SnapshotGroupVersion.WithResource("volumesnapshots")
+	storageClassesResource  = kubecluster.StorageGroupVersion.WithResource("storageclasses")
+	statefulSetsResource    = kubecluster.AppsGroupVersion.WithResource("statefulsets")
+)
+
+// VolumeSnapshots records the actions on VolumeSnapshots like the typed
+// fakes of the Clientset. Reactors prepended for "volumesnapshots" take
+// precedence over the snapshots kept in Snapshots.
+func (c *Client) VolumeSnapshots() kubecluster.UnstructuredInterface {
+	return &unstructuredResource{client: c, resource: volumeSnapshotsResource}
+}
+
+// StorageClasses serves the storage classes kept in Classes.
+func (c *Client) StorageClasses() kubecluster.UnstructuredInterface {
+	return &unstructuredResource{client: c, resource: storageClassesResource}
+}
+
+// StatefulSets serves the StatefulSets kept in Sets.
+func (c *Client) StatefulSets() kubecluster.UnstructuredInterface {
+	return &unstructuredResource{client: c, resource: statefulSetsResource}
+}
+
+// UnstructuredPersistentVolumeClaims records the actions on claims with the
+// unstructured objects. Claims are stored as typed claims, so the fields
+// their type lacks are dropped.
+func (c *Client) UnstructuredPersistentVolumeClaims() kubecluster.UnstructuredInterface {
+	return &unstructuredResource{client: c, resource: v1.SchemeGroupVersion.WithResource("persistentvolumeclaims")}
+}
+
+// UnstructuredPods records the actions on pods with the unstructured
+// objects. Pods are stored as typed pods, so the fields their type lacks
+// are dropped.
+func (c *Client) UnstructuredPods() kubecluster.UnstructuredInterface {
+	return &unstructuredResource{client: c, resource: v1.SchemeGroupVersion.WithResource("pods")}
+}
+
+// UnstructuredDeployments records the actions on deployments with the
+// unstructured objects. Deployments are stored as typed deployments, so the
+// fields their type lacks are dropped.
+func (c *Client) UnstructuredDeployments() kubecluster.UnstructuredInterface {
+	return &unstructuredResource{client: c, resource: extensions.SchemeGroupVersion.WithResource("deployments")}
+}
+
+type unstructuredResource struct {
+	client   *Client
+	resource unversioned.GroupVersionResource
+}
+
+func (r *unstructuredResource) Create(obj *runtime.Unstructured) (*runtime.Unstructured, error) {
+	result, err := r.client.Invokes(testing.NewCreateAction(r.resource, r.client.Namespace(), obj), nil)
+	if result == nil {
+		return nil, err
+	}
+	return asUnstructured(result), err
+}
+
+func (r *unstructuredResource) Get(name string) (*runtime.Unstructured, error) {
+	result, err := r.client.Invokes(testing.NewGetAction(r.resource, r.client.Namespace(), name), nil)
+	if result == nil {
+		return nil, err
+	}
+	return asUnstructured(result), err
+}
+
+func (r *unstructuredResource) List(opts runtime.Object) (runtime.Object, error) {
+	listOptions := v1.ListOptions{}
+	if opts, ok := opts.(*v1.ListOptions); ok {
+		listOptions = *opts
+	}
+	return r.client.Invokes(testing.NewListAction(r.resource, r.client.Namespace(), listOptions), nil)
+}
+
+func (r *unstructuredResource) Delete(name string, opts *v1.DeleteOptions) error {
+	_, err := r.client.Invokes(testing.NewDeleteAction(r.resource, r.client.Namespace(), name), nil)
+	return err
+}
+
+func (r *unstructuredResource) Patch(name string, pt api.PatchType, data []byte) (*runtime.Unstructured, error) {
+	result, err := r.client.Invokes(testing.NewPatchAction(r.resource, r.client.Namespace(), name, data), nil)
+	if result == nil {
+		return nil, err
+	}
+	return asUnstructured(result), err
+}
+
+// storeReaction serves the create, get, list, delete and merge patch
+// actions of a resource from the objects. The object tracker of the
+// Clientset cannot store types it has no scheme for.
+func storeReaction(groupResource unversioned.GroupResource, objects map[string]*runtime.Unstructured) testing.ReactionFunc {
+	return func(action testing.Action) (bool, runtime.Object, error) {
+		switch action := action.(type) {
+		case testing.ListActionImpl:
+			selector := action.GetListRestrictions().Labels
+			list := &runtime.UnstructuredList{}
+			for _, obj := range objects {
+				if selector.Matches(labels.Set(obj.GetLabels())) {
+					list.Items = append(list.Items, asUnstructured(obj))
+				}
+			}
+			return true, list, nil
+
+		case testing.PatchActionImpl:
+			obj, ok := objects[action.GetName()]
+			if !ok {
+				return true, nil, kubeerrors.NewNotFound(groupResource, action.GetName())
+			}
+
+			var patch map[string]interface{}
+			if err := json.Unmarshal(action.GetPatch(), &patch); err != nil {
+				return true, nil, err
+			}
+			mergePatch(obj.Object, patch)
+			return true, asUnstructured(obj), nil
+
+		case testing.CreateActionImpl:
+			obj := action.GetObject().(*runtime.Unstructured)
+			if _, ok := objects[obj.GetName()]; ok {
+				return true, nil, kubeerrors.NewAlreadyExists(groupResource, obj.GetName())
+			}
+			objects[obj.GetName()] = asUnstructured(obj)
+			return true, asUnstructured(obj), nil
+
+		case testing.GetActionImpl:
+			obj, ok := objects[action.GetName()]
+			if !ok {
+				return true, nil, kubeerrors.NewNotFound(groupResource, action.GetName())
+			}
+			return true, obj, nil
+
+		case testing.DeleteActionImpl:
+			if _, ok := objects[action.GetName()]; !ok {
+				return true, nil, kubeerrors.NewNotFound(groupResource, action.GetName())
+			}
+			delete(objects, action.GetName())
+			return true, nil, nil
+		}
+
+		return false, nil, nil
+	}
+}
+
+// unstructuredCreateReaction stores objects created from unstructured
+// objects in the tracker as the typed objects returned by newObject.
+func unstructuredCreateReaction(tracker testing.ObjectTracker, newObject func() runtime.Object) testing.ReactionFunc {
+	return func(action testing.Action) (bool, runtime.Object, error) {
+		obj, ok := action.(testing.CreateAction).GetObject().(*runtime.Unstructured)
+		if !ok {
+			return false, nil, nil
+		}
+
+		typed := newObject()
+		convert(obj.Object, typed)
+		if err := tracker.Add(typed); err != nil {
+			return true, nil, err
+		}
+		return true, typed, nil
+	}
+}
+
+// patchReaction applies merge patches to the objects of kind gvk in the
+// tracker and stores them as the typed objects returned by newObject.
+func patchReaction(tracker testing.ObjectTracker, gvk unversioned.GroupVersionKind, newObject func() runtime.Object) testing.ReactionFunc {
+	return func(action testing.Action) (bool, runtime.Object, error) {
+		patchAction := action.(testing.PatchActionImpl)
+
+		obj, err := tracker.Get(gvk, patchAction.GetNamespace(), patchAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		var patch map[string]interface{}
+		if err := json.Unmarshal(patchAction.GetPatch(), &patch); err != nil {
+			return true, nil, err
+		}
+
+		patched := asUnstructured(obj).Object
+		mergePatch(patched, patch)
+
+		typed := newObject()
+		convert(patched, typed)
+		if err := tracker.Update(typed); err != nil {
+			return true, nil, err
+		}
+		return true, typed, nil
+	}
+}
+
+func mergePatch(target, patch map[string]interface{}) {
+	for key, value := range patch {
+		patchMap, ok := value.(map[string]interface{})
+		targetMap, targetIsMap := target[key].(map[string]interface{})
+		switch {
+		case value == nil:
+			delete(target, key)
+		case ok && targetIsMap:
+			mergePatch(targetMap, patchMap)
+		default:
+			target[key] = value
+		}
+	}
+}
+
+// asUnstructured copies obj, as if it had been sent to the API server, so
+// the objects of the actions are kept apart from the stored ones.
+func asUnstructured(obj runtime.Object) *runtime.Unstructured {
+	u := &runtime.Unstructured{}
+	if unstructured, ok := obj.(*runtime.Unstructured); ok {
+		convert(unstructured.Object, &u.Object)
+	} else {
+		convert(obj, &u.Object)
+	}
+	return u
+}
+
+func convert(from, to interface{}) {
+	data, err := json.Marshal(from)
+	if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(data, to); err != nil {
+		panic(err)
+	}
+}
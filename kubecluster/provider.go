@@ -1,8 +1,17 @@
 package kubecluster
 
 import (
+	"net/http"
+	"sync"
+
 	bosherr "github.com/cloudfoundry/bosh-utils/errors"
+	"github.ibm.com/Bluemix/kubernetes-cpi/config"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
@@ -14,8 +23,33 @@ type ClientProvider interface {
 	New(context string) (Client, error)
 }
 
+// Provider creates clients from a kubernetes client configuration. Clients
+// are cached per context so a long running CPI reuses its connections.
 type Provider struct {
 	clientcmdapi.Config
+
+	// TokenSources provide the bearer tokens of users, by name, whose
+	// credentials change over time. Their tokens are refreshed when the
+	// API server responds with 401 Unauthorized.
+	TokenSources map[string]TokenSource
+
+	mutex   sync.Mutex
+	clients map[string]Client
+}
+
+// NewProvider creates a provider for the CPI kubernetes configuration.
+func NewProvider(kubeConf *config.Kubernetes) *Provider {
+	tokenSources := map[string]TokenSource{}
+	for name, authInfo := range kubeConf.AuthInfos {
+		if source := NewTokenSource(authInfo); source != nil {
+			tokenSources[name] = source
+		}
+	}
+
+	return &Provider{
+		Config:       kubeConf.ClientConfig(),
+		TokenSources: tokenSources,
+	}
 }
 
 func (p *Provider) New(context string) (Client, error) {
@@ -23,6 +57,27 @@ func (p *Provider) New(context string) (Client, error) {
 		context = p.Config.CurrentContext
 	}
 
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if client, ok := p.clients[context]; ok {
+		return client, nil
+	}
+
+	client, err := p.newClient(context)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.clients == nil {
+		p.clients = map[string]Client{}
+	}
+	p.clients[context] = client
+
+	return client, nil
+}
+
+func (p *Provider) newClient(context string) (Client, error) {
 	kubeClientConfig := clientcmd.NewNonInteractiveClientConfig(
 		p.Config,
 		context,
@@ -35,19 +90,65 @@ func (p *Provider) New(context string) (Client, error) {
 		return nil, bosherr.WrapError(err, "Getting kubeClientConfig")
 	}
 
+	if kubeContext, ok := p.Config.Contexts[context]; ok {
+		if source, ok := p.TokenSources[kubeContext.AuthInfo]; ok {
+			restConfig.BearerToken = ""
+			restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+				return &tokenRoundTripper{source: source, rt: rt}
+			}
+		}
+	}
+
 	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, bosherr.WrapError(err, "Creating a new clientset from config")
 	}
 
+	unstructuredClient, err := newDynamicClient(restConfig, "/api", v1.SchemeGroupVersion)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating an unstructured client from config")
+	}
+
+	snapshotClient, err := newDynamicClient(restConfig, "/apis", SnapshotGroupVersion)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating a snapshot client from config")
+	}
+
+	storageClient, err := newDynamicClient(restConfig, "/apis", StorageGroupVersion)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating a storage client from config")
+	}
+
+	appsClient, err := newDynamicClient(restConfig, "/apis", AppsGroupVersion)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating an apps client from config")
+	}
+
+	extensionsClient, err := newDynamicClient(restConfig, "/apis", extensions.SchemeGroupVersion)
+	if err != nil {
+		return nil, bosherr.WrapError(err, "Creating an extensions client from config")
+	}
+
 	ns, _, err := kubeClientConfig.Namespace()
 	if err != nil {
 		return nil, bosherr.WrapError(err, "Getting namespace based on client and context")
 	}
 
 	return &client{
-		context:   context,
-		namespace: ns,
-		Clientset: kubeClient,
+		context:      context,
+		namespace:    ns,
+		Clientset:    kubeClient,
+		unstructured: unstructuredClient,
+		snapshots:    snapshotClient,
+		storage:      storageClient,
+		apps:         appsClient,
+		extensions:   extensionsClient,
 	}, nil
 }
+
+func newDynamicClient(restConfig *rest.Config, apiPath string, groupVersion unversioned.GroupVersion) (*dynamic.Client, error) {
+	dynamicConfig := *restConfig
+	dynamicConfig.APIPath = apiPath
+	dynamicConfig.GroupVersion = &groupVersion
+	return dynamic.NewClient(&dynamicConfig)
+}
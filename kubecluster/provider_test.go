@@ -133,6 +133,22 @@ var _ = Describe("Provider", func() {
 		})
 	})
 
+	Context("when a client has already been created for the context", func() {
+		It("reuses the client", func() {
+			client, err := provider.New("test_context")
+			Expect(err).NotTo(HaveOccurred())
+
+			again, err := provider.New("test_context")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(BeIdenticalTo(client))
+
+			defaultClient, err := provider.New("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(defaultClient).NotTo(BeIdenticalTo(client))
+			Expect(defaultClient.Context()).To(Equal("default"))
+		})
+	})
+
 	Context("when an invalid context name is specified", func() {
 		It("raises an error", func() {
 			_, err := provider.New("does-not-exist")
@@ -0,0 +1,133 @@
+// Package redact masks credentials in values that are written to logs.
+package redact
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces sensitive values.
+const Mask = "<redacted>"
+
+var (
+	// sensitiveKeyParts identify the keys of sensitive values. Keys are
+	// compared in lower case.
+	sensitiveKeyParts = []string{"password", "secret", "token", "credential", "private_key", "access_key", "json_key"}
+
+	// secretDataKeys hold the payload of kubernetes secrets declared in
+	// cloud properties.
+	secretDataKeys = map[string]bool{"data": true, "string_data": true, "stringData": true}
+
+	urlPasswordPattern = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://[^:/@\s]*:)[^@/\s]+@`)
+)
+
+// Value returns a copy of a value produced by decoding JSON into an
+// interface{} with sensitive values masked. Values are sensitive when their
+// key looks like a credential, when they are the data of a secret, or when
+// they are the password of a URL.
+func Value(value interface{}) interface{} {
+	return redact(value, "")
+}
+
+// JSON masks sensitive values in a serialized JSON document. Documents that
+// cannot be parsed are masked completely.
+func JSON(payload []byte) []byte {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return []byte(`"` + Mask + `"`)
+	}
+
+	redacted, err := json.Marshal(Value(value))
+	if err != nil {
+		return []byte(`"` + Mask + `"`)
+	}
+
+	return redacted
+}
+
+// String masks the passwords of URLs in s.
+func String(s string) string {
+	return urlPasswordPattern.ReplaceAllString(s, "${1}"+Mask+"@")
+}
+
+func redact(value interface{}, parentKey string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if isSensitiveKey(key) || (parentKey == "secrets" && secretDataKeys[key]) {
+				redacted[key] = mask(child)
+			} else {
+				redacted[key] = redact(child, key)
+			}
+		}
+		return redacted
+
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, child := range v {
+			redacted[i] = redact(child, parentKey)
+		}
+		return redacted
+
+	case string:
+		return String(v)
+
+	default:
+		return v
+	}
+}
+
+// mask replaces every string and number in value. The keys of objects are
+// kept so the structure remains visible.
+func mask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			masked[key] = mask(child)
+		}
+		return masked
+
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, child := range v {
+			masked[i] = mask(child)
+		}
+		return masked
+
+	case nil, bool:
+		return v
+
+	case string:
+		if v == "" {
+			return v
+		}
+		return Mask
+
+	default:
+		return Mask
+	}
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if key == "key" {
+		return true
+	}
+
+	// Secrets are masked by secretDataKeys and references to secrets by
+	// name are not sensitive.
+	if key == "secrets" || strings.HasSuffix(key, "name") {
+		return false
+	}
+
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+
+	return false
+}